@@ -1,4 +1,5 @@
 import { SyncRequest, SyncResponse } from "../types";
+import { generateUUID } from "../utils/uuid";
 
 export const SERVER_URL_KEY = 'lingolift_server_url';
 
@@ -10,10 +11,23 @@ export const setServerUrl = (url: string) => {
     localStorage.setItem(SERVER_URL_KEY, url);
 };
 
+const DEVICE_ID_KEY = 'lingolift_device_id';
+
+// Stable per-install ID the server uses to track this device's sync state
+export const getDeviceId = (): string => {
+    let id = localStorage.getItem(DEVICE_ID_KEY);
+    if (!id) {
+        id = generateUUID();
+        localStorage.setItem(DEVICE_ID_KEY, id);
+    }
+    return id;
+};
+
 const getHeaders = () => {
     const apiKey = localStorage.getItem('api_key');
     return {
         'Content-Type': 'application/json',
+        'X-Device-ID': getDeviceId(),
         ...(apiKey ? { 'Authorization': `Bearer ${apiKey}` } : {})
     };
 };
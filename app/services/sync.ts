@@ -1,9 +1,13 @@
 import { getAllLessons, saveLesson, deleteLesson, getDeletedLessonIds, getDeletedCardIds, getLesson } from './db';
 import { Lesson, Flashcard, SyncRequest, SyncResponse, NewUserCard, CardProgress } from '../types';
-import { performBiDirectionalSync } from './api';
+import { performBiDirectionalSync, getDeviceId } from './api';
 
 const LAST_SYNC_KEY = 'last_sync_timestamp';
 
+// Sync protocol spoken by this client, see server/internal/handlers/sync_protocol.go
+const SYNC_PROTOCOL_VERSION = 6;
+const SYNC_CAPABILITIES = ['cardDeletes', 'lessonDeletes', 'fullResync'];
+
 export const syncLessons = async () => {
     const lastSync = parseInt(localStorage.getItem(LAST_SYNC_KEY) || '0');
     const lessons = await getAllLessons();
@@ -46,6 +50,9 @@ export const syncLessons = async () => {
     console.log('[Sync] Deleted Lesson IDs found:', deletedLessonIds);
 
     const req: SyncRequest = {
+        protocolVersion: SYNC_PROTOCOL_VERSION,
+        capabilities: SYNC_CAPABILITIES,
+        device: { id: getDeviceId(), name: navigator.platform, platform: 'mobile', appVersion: '' },
         lastSyncTimestamp: lastSync,
         changes: {
             createdCards,
@@ -88,6 +95,17 @@ export const syncLessons = async () => {
             await saveLesson(remoteLesson);
         }
 
+        // C2. Full Resync: the server purged deletions we never saw, so
+        // lessons holds everything and any other local lesson is gone
+        if (data.fullResync) {
+            const remoteIds = new Set(data.updates.lessons.map(l => l.id));
+            for (const lesson of await getAllLessons()) {
+                if (!remoteIds.has(lesson.id)) {
+                    await deleteLesson(lesson.id);
+                }
+            }
+        }
+
         // D. Remote Progress
         for (const update of data.updates.remoteProgress) {
             const allLessons = await getAllLessons();
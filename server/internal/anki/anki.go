@@ -0,0 +1,102 @@
+// Package anki reads and writes Anki deck packages (.apkg). A package is a
+// zip archive holding an SQLite collection and the media its notes use.
+package anki
+
+import "time"
+
+// Card types and queues, as stored in the cards table.
+const (
+	CardTypeNew        = 0
+	CardTypeLearning   = 1
+	CardTypeReview     = 2
+	CardTypeRelearning = 3
+
+	QueueSuspended   = -1
+	QueueSchedBuried = -2
+	QueueUserBuried  = -3
+	QueueNew         = 0
+	QueueLearning    = 1
+	QueueReview      = 2
+	QueueDayLearning = 3
+)
+
+const (
+	legacyCollection   = "collection.anki2"
+	legacy21Collection = "collection.anki21"
+	v18Collection      = "collection.anki21b" // zstd compressed, schema 18
+
+	fieldSeparator    = "\x1f"
+	deckNameSeparator = "::"
+	v18DeckSeparator  = "\x1f"
+)
+
+// Collection is the content of a package.
+type Collection struct {
+	Created   time.Time // day the collection was created; review due dates count from it
+	Decks     map[int64]Deck
+	NoteTypes map[int64]NoteType
+	Notes     []Note
+	Cards     []Card
+	Revlog    []Review
+	Media     map[string][]byte // file name as referenced by notes -> content
+	// Media to write from disk rather than memory: file name -> path
+	MediaPaths map[string]string
+}
+
+type Deck struct {
+	ID          int64
+	Name        string // nested decks are separated by "::"
+	Description string
+}
+
+type NoteType struct {
+	ID        int64
+	Name      string
+	Fields    []string
+	Templates []Template
+	Cloze     bool
+}
+
+// Template renders one card of a note type, in Anki's template syntax.
+type Template struct {
+	Name  string
+	Front string
+	Back  string
+}
+
+type Note struct {
+	ID         int64
+	GUID       string // globally unique, identifies the note across collections
+	NoteTypeID int64
+	Fields     []string // raw HTML
+	Tags       []string
+	Modified   int64 // unix seconds
+}
+
+type Card struct {
+	ID       int64
+	NoteID   int64
+	DeckID   int64
+	Ordinal  int // template, or cloze number - 1
+	Type     int
+	Queue    int
+	Due      int64 // days since Created for reviews, unix seconds for learning, position for new cards
+	Interval int   // days, negative seconds while learning
+	Factor   int   // ease in permille
+	Reps     int
+	Lapses   int
+	Data     string // JSON, holds FSRS memory state in recent versions
+	Modified int64  // unix seconds
+}
+
+// Review is one entry of the review log.
+type Review struct {
+	ID           int64 // unix millis of the review
+	CardID       int64
+	Ease         int // 1 (again) to 4 (easy), 0 for manual rescheduling
+	Interval     int // days, negative seconds while learning
+	LastInterval int
+	Factor       int
+	TimeMs       int64
+	Type         int // 0 learn, 1 review, 2 relearn, 3 filtered, 4 manual
+}
@@ -0,0 +1,48 @@
+package anki
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedProto = errors.New("malformed protobuf message")
+
+// protoField is one field of a protobuf message. Only the wire types Anki
+// uses in packages are supported: varints and length-delimited values.
+type protoField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// parseProto splits a protobuf message into its fields, in wire order.
+func parseProto(msg []byte) ([]protoField, error) {
+	fields := make([]protoField, 0)
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errMalformedProto
+		}
+		msg = msg[n:]
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.varint, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errMalformedProto
+			}
+			msg = msg[n:]
+		case 2:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return nil, errMalformedProto
+			}
+			field.bytes = msg[n : n+int(length)]
+			msg = msg[n+int(length):]
+		default:
+			return nil, errMalformedProto
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
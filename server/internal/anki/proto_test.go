@@ -0,0 +1,59 @@
+package anki
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func appendVarintField(msg []byte, number int, value uint64) []byte {
+	msg = binary.AppendUvarint(msg, uint64(number)<<3)
+	return binary.AppendUvarint(msg, value)
+}
+
+func appendBytesField(msg []byte, number int, value []byte) []byte {
+	msg = binary.AppendUvarint(msg, uint64(number)<<3|2)
+	msg = binary.AppendUvarint(msg, uint64(len(value)))
+	return append(msg, value...)
+}
+
+func TestParseProto(t *testing.T) {
+	msg := appendBytesField(nil, 1, []byte("cat.jpg"))
+	msg = appendVarintField(msg, 2, 300)
+	msg = appendBytesField(msg, 3, nil)
+	msg = appendVarintField(msg, 255, 7)
+
+	fields, err := parseProto(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []protoField{{number: 1, bytes: []byte("cat.jpg")}, {number: 2, varint: 300}, {number: 3}, {number: 255, varint: 7}}
+	if len(fields) != len(want) {
+		t.Fatalf("got %d fields, want %d", len(fields), len(want))
+	}
+	for i, field := range fields {
+		if field.number != want[i].number || field.varint != want[i].varint || string(field.bytes) != string(want[i].bytes) {
+			t.Errorf("field %d: got %+v, want %+v", i, field, want[i])
+		}
+	}
+
+	if fields, err := parseProto(nil); err != nil || len(fields) != 0 {
+		t.Errorf("empty message: got %v, %v", fields, err)
+	}
+}
+
+func TestParseProtoMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated key":    {0x80},
+		"truncated varint": {0x08, 0x80},
+		"missing length":   {0x0a},
+		"length too long":  {0x0a, 0x05, 'a', 'b'},
+		"huge length":      {0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"fixed32 field":    {0x0d, 0x01, 0x02, 0x03, 0x04},
+	}
+	for name, msg := range tests {
+		if _, err := parseProto(msg); !errors.Is(err, errMalformedProto) {
+			t.Errorf("%s: got error %v, want errMalformedProto", name, err)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	_ "modernc.org/sqlite"
+)
+
+// Limits on what a package may decompress to, so a small upload can't fill the disk.
+const (
+	MaxCollectionBytes = 1 << 30
+	MaxMediaFileBytes  = 100 << 20
+	MaxMediaBytes      = 512 << 20
+)
+
+var (
+	ErrNoCollection = errors.New("package contains no Anki collection")
+	ErrTooLarge     = errors.New("package content exceeds the size limit")
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Read parses an .apkg package. Packages from Anki 2.1.50 and later store a
+// zstd compressed schema 18 collection next to a legacy placeholder; the
+// newest collection in the archive is the one read.
+func Read(r io.ReaderAt, size int64) (*Collection, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var collectionFile *zip.File
+	for _, name := range []string{v18Collection, legacy21Collection, legacyCollection} {
+		if f, ok := files[name]; ok {
+			collectionFile = f
+			break
+		}
+	}
+	if collectionFile == nil {
+		return nil, ErrNoCollection
+	}
+	data, err := readZipFile(collectionFile, MaxCollectionBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := readCollection(data)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Media, err = readMedia(files); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// readZipFile returns the content of f, decompressing it if Anki stored it
+// zstd compressed.
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := readLimited(rc, limit)
+	if err != nil || !bytes.HasPrefix(data, zstdMagic) {
+		return data, err
+	}
+	decoder, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return readLimited(decoder, limit)
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, err
+}
+
+// readCollection opens the SQLite collection, which has to be on disk for
+// the driver, and loads everything the import needs.
+func readCollection(data []byte) (*Collection, error) {
+	tmp, err := os.CreateTemp("", "lingolift-anki-*.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+tmp.Name()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	c := &Collection{Decks: make(map[int64]Deck), NoteTypes: make(map[int64]NoteType)}
+	var crt int64
+	var models, decks string
+	if err := db.QueryRow("SELECT crt, models, decks FROM col").Scan(&crt, &models, &decks); err != nil {
+		return nil, fmt.Errorf("reading collection: %w", err)
+	}
+	c.Created = time.Unix(crt, 0)
+
+	var v18 int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'notetypes'").Scan(&v18); err != nil {
+		return nil, err
+	}
+	if v18 > 0 {
+		err = readV18Schema(db, c)
+	} else {
+		err = readLegacySchema(models, decks, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := readRows(db, "SELECT id, guid, mid, flds, tags, mod FROM notes ORDER BY id", func(rows *sql.Rows) error {
+		var note Note
+		var fields, tags string
+		if err := rows.Scan(&note.ID, &note.GUID, &note.NoteTypeID, &fields, &tags, &note.Modified); err != nil {
+			return err
+		}
+		note.Fields = strings.Split(fields, fieldSeparator)
+		note.Tags = strings.Fields(tags)
+		c.Notes = append(c.Notes, note)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := readRows(db, "SELECT id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses, data, mod FROM cards ORDER BY nid, ord", func(rows *sql.Rows) error {
+		var card Card
+		if err := rows.Scan(&card.ID, &card.NoteID, &card.DeckID, &card.Ordinal, &card.Type, &card.Queue, &card.Due,
+			&card.Interval, &card.Factor, &card.Reps, &card.Lapses, &card.Data, &card.Modified); err != nil {
+			return err
+		}
+		c.Cards = append(c.Cards, card)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := readRows(db, "SELECT id, cid, ease, ivl, lastIvl, factor, time, type FROM revlog ORDER BY cid, id", func(rows *sql.Rows) error {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.CardID, &review.Ease, &review.Interval, &review.LastInterval,
+			&review.Factor, &review.TimeMs, &review.Type); err != nil {
+			return err
+		}
+		c.Revlog = append(c.Revlog, review)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readRows(db *sql.DB, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// readLegacySchema reads note types and decks from the JSON columns of col.
+func readLegacySchema(models, decks string, c *Collection) error {
+	var noteTypes map[string]struct {
+		ID     int64  `json:"id"`
+		Name   string `json:"name"`
+		Type   int    `json:"type"` // 1 for cloze
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"flds"`
+		Templates []struct {
+			Name  string `json:"name"`
+			Front string `json:"qfmt"`
+			Back  string `json:"afmt"`
+		} `json:"tmpls"`
+	}
+	if err := json.Unmarshal([]byte(models), &noteTypes); err != nil {
+		return fmt.Errorf("reading note types: %w", err)
+	}
+	for _, nt := range noteTypes {
+		noteType := NoteType{ID: nt.ID, Name: nt.Name, Cloze: nt.Type == 1}
+		for _, field := range nt.Fields {
+			noteType.Fields = append(noteType.Fields, field.Name)
+		}
+		for _, template := range nt.Templates {
+			noteType.Templates = append(noteType.Templates, Template(template))
+		}
+		c.NoteTypes[nt.ID] = noteType
+	}
+
+	var deckList map[string]struct {
+		ID          int64  `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"desc"`
+	}
+	if err := json.Unmarshal([]byte(decks), &deckList); err != nil {
+		return fmt.Errorf("reading decks: %w", err)
+	}
+	for _, deck := range deckList {
+		c.Decks[deck.ID] = Deck(deck)
+	}
+	return nil
+}
+
+// readV18Schema reads note types and decks from their own tables. Whether a
+// note type is a cloze type is only recorded in its protobuf config.
+func readV18Schema(db *sql.DB, c *Collection) error {
+	if err := readRows(db, "SELECT id, name, config FROM notetypes", func(rows *sql.Rows) error {
+		var noteType NoteType
+		var config []byte
+		if err := rows.Scan(&noteType.ID, &noteType.Name, &config); err != nil {
+			return err
+		}
+		fields, err := parseProto(config)
+		if err != nil {
+			return fmt.Errorf("reading note type %q: %w", noteType.Name, err)
+		}
+		for _, field := range fields {
+			if field.number == 1 { // kind
+				noteType.Cloze = field.varint == 1
+			}
+		}
+		c.NoteTypes[noteType.ID] = noteType
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := readRows(db, "SELECT ntid, name FROM fields ORDER BY ntid, ord", func(rows *sql.Rows) error {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if noteType, ok := c.NoteTypes[id]; ok {
+			noteType.Fields = append(noteType.Fields, name)
+			c.NoteTypes[id] = noteType
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := readRows(db, "SELECT ntid, name, config FROM templates ORDER BY ntid, ord", func(rows *sql.Rows) error {
+		var id int64
+		var template Template
+		var config []byte
+		if err := rows.Scan(&id, &template.Name, &config); err != nil {
+			return err
+		}
+		fields, err := parseProto(config)
+		if err != nil {
+			return fmt.Errorf("reading template %q: %w", template.Name, err)
+		}
+		for _, field := range fields {
+			switch field.number {
+			case 1:
+				template.Front = string(field.bytes)
+			case 2:
+				template.Back = string(field.bytes)
+			}
+		}
+		if noteType, ok := c.NoteTypes[id]; ok {
+			noteType.Templates = append(noteType.Templates, template)
+			c.NoteTypes[id] = noteType
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return readRows(db, "SELECT id, name FROM decks", func(rows *sql.Rows) error {
+		var deck Deck
+		if err := rows.Scan(&deck.ID, &deck.Name); err != nil {
+			return err
+		}
+		deck.Name = strings.ReplaceAll(deck.Name, v18DeckSeparator, deckNameSeparator)
+		c.Decks[deck.ID] = deck
+		return nil
+	})
+}
+
+// readMedia loads the media files listed in the package's media index. The
+// index is JSON mapping zip entry names to file names in legacy packages and
+// a zstd compressed protobuf list in newer ones, where entry i is named "i".
+func readMedia(files map[string]*zip.File) (map[string][]byte, error) {
+	media := make(map[string][]byte)
+	indexFile, ok := files["media"]
+	if !ok {
+		return media, nil
+	}
+	index, err := readZipFile(indexFile, MaxMediaFileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]string) // zip entry -> file name
+	if trimmed := bytes.TrimSpace(index); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("reading media index: %w", err)
+		}
+	} else {
+		list, err := parseProto(index)
+		if err != nil {
+			return nil, fmt.Errorf("reading media index: %w", err)
+		}
+		for i, item := range list {
+			entry, err := parseProto(item.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("reading media index: %w", err)
+			}
+			zipName := strconv.Itoa(i)
+			var name string
+			for _, field := range entry {
+				switch field.number {
+				case 1:
+					name = string(field.bytes)
+				case 255:
+					zipName = strconv.FormatUint(field.varint, 10)
+				}
+			}
+			entries[zipName] = name
+		}
+	}
+
+	var total int64
+	for zipName, name := range entries {
+		f, ok := files[zipName]
+		if !ok || name == "" {
+			continue
+		}
+		data, err := readZipFile(f, MaxMediaFileBytes)
+		if err != nil {
+			return nil, fmt.Errorf("reading media %q: %w", name, err)
+		}
+		if total += int64(len(data)); total > MaxMediaBytes {
+			return nil, ErrTooLarge
+		}
+		media[name] = data
+	}
+	return media, nil
+}
@@ -0,0 +1,125 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zipFiles builds an archive of files and returns its entries by name.
+func zipFiles(t *testing.T, files map[string][]byte) map[string]*zip.File {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		entries[f.Name] = f
+	}
+	return entries
+}
+
+func TestReadMediaLegacyIndex(t *testing.T) {
+	media, err := readMedia(zipFiles(t, map[string][]byte{
+		"media": []byte(`{"0": "cat.jpg", "1": "dog.mp3", "2": ""}`),
+		"0":     []byte("meow"),
+		"2":     []byte("unnamed"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || string(media["cat.jpg"]) != "meow" {
+		t.Errorf("got media %q", media)
+	}
+}
+
+func TestReadMediaProtobufIndex(t *testing.T) {
+	entry := func(name string, zipName uint64, explicit bool) []byte {
+		msg := appendBytesField(nil, 1, []byte(name))
+		msg = appendVarintField(msg, 2, 4)
+		if explicit {
+			msg = appendVarintField(msg, 255, zipName)
+		}
+		return msg
+	}
+	// Entries are named by their position unless they carry legacy_zip_filename
+	var index []byte
+	index = appendBytesField(index, 1, entry("cat.jpg", 0, false))
+	index = appendBytesField(index, 1, entry("dog.mp3", 7, true))
+	index = appendBytesField(index, 1, entry("missing.png", 0, false))
+	encoder, _ := zstd.NewWriter(nil)
+	compressed := encoder.EncodeAll(index, nil)
+	encoder.Close()
+
+	media, err := readMedia(zipFiles(t, map[string][]byte{
+		"media": compressed,
+		"0":     []byte("meow"),
+		"1":     []byte("not dog"),
+		"7":     []byte("woof"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 2 || string(media["cat.jpg"]) != "meow" || string(media["dog.mp3"]) != "woof" {
+		t.Errorf("got media %q", media)
+	}
+
+	if _, err := readMedia(zipFiles(t, map[string][]byte{"media": {0x0a, 0x05, 'a'}})); !errors.Is(err, errMalformedProto) {
+		t.Errorf("malformed index: got error %v, want errMalformedProto", err)
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	if _, err := readLimited(bytes.NewReader(make([]byte, 11)), 10); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got error %v, want ErrTooLarge", err)
+	}
+	if data, err := readLimited(bytes.NewReader(make([]byte, 10)), 10); err != nil || len(data) != 10 {
+		t.Errorf("got %d bytes, error %v", len(data), err)
+	}
+}
+
+func TestWriteThenRead(t *testing.T) {
+	c := &Collection{
+		Created:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Decks:     map[int64]Deck{2: {ID: 2, Name: "Spanish::Greetings"}},
+		NoteTypes: map[int64]NoteType{3: {ID: 3, Name: "Basic", Fields: []string{"Front", "Back"}, Templates: []Template{{Name: "Card 1", Front: "{{Front}}", Back: "{{Back}}"}}}},
+		Notes:     []Note{{ID: 4, GUID: "abc", NoteTypeID: 3, Fields: []string{"hola <img src=\"cat.jpg\">", "hello"}, Tags: []string{"es"}, Modified: 1700000000}},
+		Cards:     []Card{{ID: 5, NoteID: 4, DeckID: 2, Type: CardTypeReview, Queue: QueueReview, Due: 30, Interval: 10, Factor: 2500, Reps: 3, Modified: 1700000000}},
+		Media:     map[string][]byte{"cat.jpg": []byte("meow")},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read.Notes) != 1 || read.Notes[0].GUID != "abc" || read.Notes[0].Fields[1] != "hello" {
+		t.Errorf("got notes %+v", read.Notes)
+	}
+	if len(read.Cards) != 1 || read.Cards[0].Interval != 10 || read.Cards[0].DeckID != 2 {
+		t.Errorf("got cards %+v", read.Cards)
+	}
+	if read.Decks[2].Name != "Spanish::Greetings" || string(read.Media["cat.jpg"]) != "meow" {
+		t.Errorf("got decks %+v, media %q", read.Decks, read.Media)
+	}
+}
@@ -0,0 +1,335 @@
+package anki
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Write stores c as a legacy (schema 11) package, which every Anki version
+// since 2.1 imports. Parent decks missing from c.Decks are added, since Anki
+// expects every level of a nested deck name to exist.
+func Write(w io.Writer, c *Collection) error {
+	tmp, err := os.CreateTemp("", "lingolift-anki-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	if err := writeCollection(tmp.Name(), c); err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	if err := copyToZip(archive, legacyCollection, tmp.Name()); err != nil {
+		return err
+	}
+	if err := writeMedia(archive, c); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+const schema11 = `
+CREATE TABLE col (
+	id integer PRIMARY KEY, crt integer NOT NULL, mod integer NOT NULL, scm integer NOT NULL,
+	ver integer NOT NULL, dty integer NOT NULL, usn integer NOT NULL, ls integer NOT NULL,
+	conf text NOT NULL, models text NOT NULL, decks text NOT NULL, dconf text NOT NULL, tags text NOT NULL
+);
+CREATE TABLE notes (
+	id integer PRIMARY KEY, guid text NOT NULL, mid integer NOT NULL, mod integer NOT NULL,
+	usn integer NOT NULL, tags text NOT NULL, flds text NOT NULL, sfld integer NOT NULL,
+	csum integer NOT NULL, flags integer NOT NULL, data text NOT NULL
+);
+CREATE TABLE cards (
+	id integer PRIMARY KEY, nid integer NOT NULL, did integer NOT NULL, ord integer NOT NULL,
+	mod integer NOT NULL, usn integer NOT NULL, type integer NOT NULL, queue integer NOT NULL,
+	due integer NOT NULL, ivl integer NOT NULL, factor integer NOT NULL, reps integer NOT NULL,
+	lapses integer NOT NULL, left integer NOT NULL, odue integer NOT NULL, odid integer NOT NULL,
+	flags integer NOT NULL, data text NOT NULL
+);
+CREATE TABLE revlog (
+	id integer PRIMARY KEY, cid integer NOT NULL, usn integer NOT NULL, ease integer NOT NULL,
+	ivl integer NOT NULL, lastIvl integer NOT NULL, factor integer NOT NULL, time integer NOT NULL,
+	type integer NOT NULL
+);
+CREATE TABLE graves (usn integer NOT NULL, oid integer NOT NULL, type integer NOT NULL);
+CREATE INDEX ix_notes_usn ON notes (usn);
+CREATE INDEX ix_cards_usn ON cards (usn);
+CREATE INDEX ix_revlog_usn ON revlog (usn);
+CREATE INDEX ix_cards_nid ON cards (nid);
+CREATE INDEX ix_cards_sched ON cards (did, queue, due);
+CREATE INDEX ix_revlog_cid ON revlog (cid);
+CREATE INDEX ix_notes_csum ON notes (csum);
+`
+
+const (
+	defaultDeckID   = 1
+	defaultConfigID = 1
+	// left of a (re)learning card: one step left today and in total
+	learningLeft = 1001
+)
+
+func writeCollection(path string, c *Collection) (err error) {
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(schema11); err != nil {
+		return err
+	}
+
+	mod := time.Now().UnixMilli()
+	models, decks, dconf, conf, err := collectionJSON(c, mod)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')",
+		c.Created.Unix(), mod, mod, conf, models, decks, dconf); err != nil {
+		return err
+	}
+
+	for _, note := range c.Notes {
+		sortField := ""
+		if len(note.Fields) > 0 {
+			sortField = stripHTML(note.Fields[0])
+		}
+		tags := ""
+		if len(note.Tags) > 0 {
+			tags = " " + strings.Join(note.Tags, " ") + " "
+		}
+		if _, err = tx.Exec("INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')",
+			note.ID, note.GUID, note.NoteTypeID, note.Modified, tags,
+			strings.Join(note.Fields, fieldSeparator), sortField, checksum(sortField)); err != nil {
+			return err
+		}
+	}
+
+	for _, card := range c.Cards {
+		left := 0
+		if card.Type == CardTypeLearning || card.Type == CardTypeRelearning {
+			left = learningLeft
+		}
+		if _, err = tx.Exec("INSERT INTO cards VALUES (?, ?, ?, ?, ?, -1, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, ?)",
+			card.ID, card.NoteID, card.DeckID, card.Ordinal, card.Modified, card.Type, card.Queue, card.Due,
+			card.Interval, card.Factor, card.Reps, card.Lapses, left, card.Data); err != nil {
+			return err
+		}
+	}
+
+	for _, review := range c.Revlog {
+		if _, err = tx.Exec("INSERT INTO revlog VALUES (?, ?, -1, ?, ?, ?, ?, ?, ?)",
+			review.ID, review.CardID, review.Ease, review.Interval, review.LastInterval,
+			review.Factor, review.TimeMs, review.Type); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// collectionJSON builds the JSON columns of col: note types, decks, deck
+// options and collection config.
+func collectionJSON(c *Collection, mod int64) (models, decks, dconf, conf string, err error) {
+	modelMap := make(map[string]any, len(c.NoteTypes))
+	var firstModel int64
+	for _, noteType := range c.NoteTypes {
+		if firstModel == 0 || noteType.ID < firstModel {
+			firstModel = noteType.ID
+		}
+		modelMap[strconv.FormatInt(noteType.ID, 10)] = noteTypeJSON(noteType, mod)
+	}
+
+	deckMap := map[string]any{strconv.Itoa(defaultDeckID): deckJSON(Deck{ID: defaultDeckID, Name: "Default"}, mod)}
+	names := make(map[string]bool, len(c.Decks))
+	for _, deck := range c.Decks {
+		names[deck.Name] = true
+		deckMap[strconv.FormatInt(deck.ID, 10)] = deckJSON(deck, mod)
+	}
+	nextID := mod
+	for _, deck := range c.Decks {
+		parts := strings.Split(deck.Name, deckNameSeparator)
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], deckNameSeparator)
+			if names[parent] {
+				continue
+			}
+			names[parent] = true
+			for c.Decks[nextID].ID != 0 {
+				nextID++
+			}
+			deckMap[strconv.FormatInt(nextID, 10)] = deckJSON(Deck{ID: nextID, Name: parent}, mod)
+			nextID++
+		}
+	}
+
+	config := map[string]any{strconv.Itoa(defaultConfigID): map[string]any{
+		"id": defaultConfigID, "name": "Default", "mod": 0, "usn": 0,
+		"maxTaken": 60, "autoplay": true, "timer": 0, "replayq": true, "dyn": false,
+		"new": map[string]any{
+			"delays": []float64{1, 10}, "ints": []int{1, 4, 0}, "initialFactor": 2500,
+			"order": 1, "perDay": 20, "bury": false, "separate": true,
+		},
+		"rev": map[string]any{
+			"perDay": 200, "ease4": 1.3, "ivlFct": 1, "maxIvl": 36500, "hardFactor": 1.2, "bury": false,
+		},
+		"lapse": map[string]any{
+			"delays": []float64{10}, "mult": 0, "minInt": 1, "leechFails": 8, "leechAction": 1,
+		},
+	}}
+	collectionConfig := map[string]any{
+		"activeDecks": []int{defaultDeckID}, "curDeck": defaultDeckID, "curModel": firstModel,
+		"newSpread": 0, "collapseTime": 1200, "timeLim": 0, "estTimes": true, "dueCounts": true,
+		"sortType": "noteFld", "sortBackwards": false, "addToCur": true, "nextPos": len(c.Cards) + 1,
+		"schedVer": 2,
+	}
+
+	values := make([]string, 4)
+	for i, v := range []any{modelMap, deckMap, config, collectionConfig} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", "", "", "", err
+		}
+		values[i] = string(data)
+	}
+	return values[0], values[1], values[2], values[3], nil
+}
+
+var templateField = regexp.MustCompile(`{{[#^/]?(?:[^}:]*:)?([^}]+)}}`)
+
+func noteTypeJSON(noteType NoteType, mod int64) map[string]any {
+	kind := 0
+	if noteType.Cloze {
+		kind = 1
+	}
+	fields := make([]map[string]any, len(noteType.Fields))
+	for i, name := range noteType.Fields {
+		fields[i] = map[string]any{
+			"name": name, "ord": i, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{},
+		}
+	}
+	templates := make([]map[string]any, len(noteType.Templates))
+	// req lists the fields a template's front needs to produce a card
+	req := make([]any, len(noteType.Templates))
+	for i, template := range noteType.Templates {
+		templates[i] = map[string]any{
+			"name": template.Name, "ord": i, "qfmt": template.Front, "afmt": template.Back,
+			"bqfmt": "", "bafmt": "", "did": nil, "bfont": "", "bsize": 0,
+		}
+		needed := make([]int, 0)
+		for _, match := range templateField.FindAllStringSubmatch(template.Front, -1) {
+			if ord := slices.Index(noteType.Fields, strings.TrimSpace(match[1])); ord >= 0 && !slices.Contains(needed, ord) {
+				needed = append(needed, ord)
+			}
+		}
+		req[i] = []any{i, "any", needed}
+	}
+	return map[string]any{
+		"id": noteType.ID, "name": noteType.Name, "type": kind, "mod": mod / 1000, "usn": -1,
+		"sortf": 0, "did": defaultDeckID, "tmpls": templates, "flds": fields, "req": req,
+		"css":       ".card { font-family: arial; font-size: 20px; text-align: center; color: black; background-color: white; }\n",
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"latexsvg":  false, "tags": []string{}, "vers": []int{},
+	}
+}
+
+func deckJSON(deck Deck, mod int64) map[string]any {
+	return map[string]any{
+		"id": deck.ID, "name": deck.Name, "desc": deck.Description, "mod": mod / 1000, "usn": -1,
+		"lrnToday": []int{0, 0}, "revToday": []int{0, 0}, "newToday": []int{0, 0}, "timeToday": []int{0, 0},
+		"collapsed": false, "browserCollapsed": false, "dyn": 0, "conf": defaultConfigID,
+		"extendNew": 0, "extendRev": 0,
+	}
+}
+
+// writeMedia adds the media files as entries "0", "1", ... and the index
+// mapping them back to their names.
+func writeMedia(archive *zip.Writer, c *Collection) error {
+	names := make([]string, 0, len(c.Media)+len(c.MediaPaths))
+	for name := range c.Media {
+		names = append(names, name)
+	}
+	for name := range c.MediaPaths {
+		if _, ok := c.Media[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	index := make(map[string]string, len(names))
+	for i, name := range names {
+		entry := strconv.Itoa(i)
+		index[entry] = name
+		if data, ok := c.Media[name]; ok {
+			f, err := archive.Create(entry)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+		} else if err := copyToZip(archive, entry, c.MediaPaths[name]); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	f, err := archive.Create("media")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func copyToZip(archive *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func stripHTML(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(s, "")))
+}
+
+// checksum is Anki's duplicate check value: the first 32 bits of the SHA-1
+// of the sort field.
+func checksum(s string) int64 {
+	sum := sha1.Sum([]byte(s))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
@@ -0,0 +1,148 @@
+// Package answer checks answers typed during review against the expected
+// text. Comparison is forgiving about case, punctuation, diacritics and
+// full-width forms, and tolerates a few typos.
+package answer
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"lingolift-server/internal/srs"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize folds s for comparison: full-width and compatibility forms
+// become their plain equivalents (NFKC), letters are lower-cased and lose
+// their diacritics, punctuation is dropped and whitespace collapsed.
+func Normalize(s string) string {
+	return string(normalizeRunes(s))
+}
+
+func normalizeRunes(s string) []rune {
+	folded := make([]rune, 0, len(s))
+	space := false
+	for _, r := range norm.NFD.String(norm.NFKC.String(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining mark split off by NFD, e.g. the accent of é
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+		case unicode.IsSpace(r):
+			space = len(folded) > 0
+		default:
+			if space {
+				folded = append(folded, ' ')
+				space = false
+			}
+			folded = append(folded, unicode.ToLower(r))
+		}
+	}
+	// Recompose what NFD split but did not drop, e.g. Hangul syllables
+	return []rune(norm.NFC.String(string(folded)))
+}
+
+// MaxLength is the longest answer, in runes, that is checked.
+const MaxLength = 1000
+
+// maxCells bounds the work of comparing two texts, the product of their
+// lengths, so a long card back can't make a check expensive.
+const maxCells = 1 << 20
+
+// Distance is the Levenshtein edit distance between a and b in runes. Texts
+// too long to compare within maxCells count as entirely different.
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if a == b {
+		return 0
+	}
+	if (len(ra)+1)*(len(rb)+1) > maxCells {
+		return max(len(ra), len(rb))
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Tolerance is the number of typos accepted in an answer of n characters:
+// none for very short answers, then one per five characters.
+func Tolerance(n int) int {
+	if n < 4 {
+		return 0
+	}
+	return max(1, n/5)
+}
+
+// Result is the outcome of checking one typed answer.
+type Result struct {
+	Expected       string    `json:"expected"` // the accepted answer closest to the typed one
+	Exact          bool      `json:"exact"`    // equal after normalization
+	Correct        bool      `json:"correct"`  // exact or within the typo tolerance
+	Distance       int       `json:"distance"` // edits between the normalized answers
+	Diff           []Segment `json:"diff"`
+	SuggestedGrade srs.Grade `json:"suggestedGrade"`
+}
+
+// QuickAnswer is how fast an exact answer must be typed to count as easy.
+const QuickAnswer = 5 * time.Second
+
+// Check compares typed against each accepted answer and reports on the
+// closest one. A grade is suggested from the result: Easy for an exact
+// answer typed within QuickAnswer, Good for an exact answer, Hard for one
+// with tolerated typos, Again otherwise. timeTaken is 0 if unknown.
+func Check(typed string, accepted []string, timeTaken time.Duration) Result {
+	normalizedTyped := Normalize(typed)
+	var best Result
+	for i, expected := range accepted {
+		normalized := Normalize(expected)
+		distance := Distance(normalizedTyped, normalized)
+		if i == 0 || distance < best.Distance {
+			best = Result{Expected: expected, Distance: distance}
+			best.Exact = distance == 0
+			best.Correct = distance <= Tolerance(len([]rune(normalized)))
+		}
+	}
+	best.Diff = Diff(typed, best.Expected)
+
+	switch {
+	case best.Exact && timeTaken > 0 && timeTaken <= QuickAnswer:
+		best.SuggestedGrade = srs.Easy
+	case best.Exact:
+		best.SuggestedGrade = srs.Good
+	case best.Correct:
+		best.SuggestedGrade = srs.Hard
+	default:
+		best.SuggestedGrade = srs.Again
+	}
+	return best
+}
+
+// Alternatives splits a card's answer into its accepted variants, written
+// as "a; b" or "a | b". An answer without separators is its only variant.
+func Alternatives(back string) []string {
+	fields := strings.FieldsFunc(back, func(r rune) bool { return r == ';' || r == '|' || r == '\n' })
+	accepted := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			accepted = append(accepted, field)
+		}
+	}
+	if len(accepted) == 0 {
+		return []string{strings.TrimSpace(back)}
+	}
+	return accepted
+}
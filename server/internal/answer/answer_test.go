@@ -0,0 +1,64 @@
+package answer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"lingolift-server/internal/srs"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		typed    string
+		accepted []string
+		correct  bool
+		grade    srs.Grade
+	}{
+		{"Bonjour", []string{"bonjour"}, true, srs.Good},
+		{"cafe", []string{"café"}, true, srs.Good},
+		{"bonjor", []string{"bonjour"}, true, srs.Hard},
+		{"merci", []string{"bonjour"}, false, srs.Again},
+		{"salut", []string{"bonjour", "salut"}, true, srs.Good},
+		// A cloze card hiding several deletions is answered by all of them
+		{"Paris, France", []string{"Paris France"}, true, srs.Good},
+		{"Paris", []string{"Paris France"}, false, srs.Again},
+	}
+	for _, tt := range tests {
+		r := Check(tt.typed, tt.accepted, 0)
+		if r.Correct != tt.correct || r.SuggestedGrade != tt.grade {
+			t.Errorf("%q against %q: correct %v, grade %d; want %v, %d", tt.typed, tt.accepted, r.Correct, r.SuggestedGrade, tt.correct, tt.grade)
+		}
+	}
+	if r := Check("bonjour", []string{"bonjour"}, 2*time.Second); r.SuggestedGrade != srs.Easy {
+		t.Errorf("quick exact answer: grade %d, want %d", r.SuggestedGrade, srs.Easy)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff("helo", "hello")
+	want := []Segment{{OpEqual, "hel"}, {OpMissing, "l"}, {OpEqual, "o"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLongTextsAreBounded(t *testing.T) {
+	long := strings.Repeat("a", 2*MaxLength)
+	other := strings.Repeat("b", 2*MaxLength)
+	if d := Distance(long, long); d != 0 {
+		t.Errorf("distance of equal long texts %d, want 0", d)
+	}
+	if d := Distance(long, other); d != len(other) {
+		t.Errorf("distance of long texts %d, want %d", d, len(other))
+	}
+	got := Diff(long, other)
+	if len(got) != 2 || got[0].Op != OpExtra || got[1].Op != OpMissing {
+		t.Errorf("diff of long texts: got %d segments, want extra then missing", len(got))
+	}
+}
@@ -0,0 +1,91 @@
+package answer
+
+// Op tells what a diff segment is relative to the expected answer.
+type Op string
+
+const (
+	OpEqual   Op = "equal"
+	OpMissing Op = "missing" // in the expected answer but not typed
+	OpExtra   Op = "extra"   // typed but not in the expected answer
+)
+
+type Segment struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff returns a character-level diff turning typed into expected. Characters
+// match when they are equal after normalization, so case or accent slips
+// show as equal; the segments carry the original text of both sides, typed
+// for equal and extra segments and expected for missing ones. Texts too long
+// to compare within maxCells are shown as replaced as a whole.
+func Diff(typed, expected string) []Segment {
+	a, b := []rune(typed), []rune(expected)
+	if (len(a)+1)*(len(b)+1) > maxCells {
+		segments := make([]Segment, 0, 2)
+		if len(a) > 0 {
+			segments = append(segments, Segment{Op: OpExtra, Text: typed})
+		}
+		if len(b) > 0 {
+			segments = append(segments, Segment{Op: OpMissing, Text: expected})
+		}
+		return segments
+	}
+	fa, fb := foldEach(a), foldEach(b)
+
+	// lcs[i*w+j] is the length of the longest common subsequence of a[i:] and b[j:]
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if fa[i] == fb[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	segments := make([]Segment, 0)
+	add := func(op Op, r rune) {
+		if n := len(segments); n > 0 && segments[n-1].Op == op {
+			segments[n-1].Text += string(r)
+			return
+		}
+		segments = append(segments, Segment{Op: op, Text: string(r)})
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case fa[i] == fb[j]:
+			add(OpEqual, a[i])
+			i, j = i+1, j+1
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			add(OpExtra, a[i])
+			i++
+		default:
+			add(OpMissing, b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add(OpExtra, a[i])
+	}
+	for ; j < len(b); j++ {
+		add(OpMissing, b[j])
+	}
+	return segments
+}
+
+// foldEach normalizes every rune on its own, so runes keep their positions.
+// Runes that normalize to nothing (punctuation) fold to themselves.
+func foldEach(runes []rune) []string {
+	folded := make([]string, len(runes))
+	for i, r := range runes {
+		folded[i] = Normalize(string(r))
+		if folded[i] == "" {
+			folded[i] = string(r)
+		}
+	}
+	return folded
+}
@@ -27,10 +27,82 @@ func InitDB() {
 	}
 
 	// Auto Migrate
-	err = DB.AutoMigrate(&models.User{}, &models.APIKey{}, &models.Lesson{}, &models.Flashcard{})
+	err = DB.AutoMigrate(&models.User{}, &models.APIKey{}, &models.Lesson{}, &models.Flashcard{}, &models.IdempotencyRecord{}, &models.Session{}, &models.Device{}, &models.ReviewLog{}, &models.DailyStudyCounter{}, &models.StudyPreset{}, &models.Note{}, &models.Deck{}, &models.LessonRevision{}, &models.Upload{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	if err := migrateCardsToNotes(); err != nil {
+		log.Fatal("Failed to migrate cards to notes:", err)
+	}
+
+	if err := migrateUploadOwners(); err != nil {
+		log.Fatal("Failed to record upload owners:", err)
+	}
+
+	if err := createSearchIndexes(); err != nil {
+		log.Fatal("Failed to create search indexes:", err)
+	}
+
 	fmt.Println("Database connected and migrated successfully.")
 }
+
+// migrateCardsToNotes gives every card that predates notes a "basic" note
+// holding its front and back. The note reuses the card's ID.
+func migrateCardsToNotes() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			INSERT INTO notes (id, user_id, lesson_id, note_type, fields, created_at, last_updated, deleted_at)
+			SELECT flashcards.id, lessons.user_id, flashcards.lesson_id, 'basic',
+				json_build_object('Front', flashcards.front, 'Back', flashcards.back)::text,
+				flashcards.last_updated, flashcards.last_updated, flashcards.deleted_at
+			FROM flashcards
+			JOIN lessons ON lessons.id = flashcards.lesson_id
+			WHERE flashcards.note_id IS NULL OR flashcards.note_id = ''
+			ON CONFLICT (id) DO NOTHING
+		`).Error; err != nil {
+			return err
+		}
+		// Cards without a lesson got no note and are left alone
+		return tx.Exec(`
+			UPDATE flashcards SET note_id = id, template_ordinal = 0
+			WHERE (note_id IS NULL OR note_id = '') AND id IN (SELECT id FROM notes)
+		`).Error
+	})
+}
+
+// migrateUploadOwners records the owners of uploads that predate the uploads
+// table, from the lessons and revisions that refer to them.
+func migrateUploadOwners() error {
+	return DB.Exec(`
+		INSERT INTO uploads (name, user_id, created_at)
+		SELECT DISTINCT substr(media.url, length('/uploads/') + 1), media.user_id, 0
+		FROM (
+			SELECT audio_url AS url, user_id FROM lessons
+			UNION SELECT pdf_url, user_id FROM lessons
+			UNION SELECT audio_url, user_id FROM lesson_revisions
+			UNION SELECT pdf_url, user_id FROM lesson_revisions
+		) media
+		WHERE media.url LIKE '/uploads/%' AND media.user_id <> ''
+		ON CONFLICT DO NOTHING
+	`).Error
+}
+
+// createSearchIndexes indexes the documents the search handler matches
+// with the default "simple" configuration. Searches stemming another
+// language scan the user's rows instead.
+func createSearchIndexes() error {
+	if err := DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_lessons_search ON lessons USING gin ((
+			setweight(to_tsvector('simple'::regconfig, title), 'A') ||
+			setweight(to_tsvector('simple'::regconfig, description), 'B') ||
+			setweight(to_tsvector('simple'::regconfig, markdown_content), 'C')))
+	`).Error; err != nil {
+		return err
+	}
+	return DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_flashcards_search ON flashcards USING gin ((
+			setweight(to_tsvector('simple'::regconfig, front), 'A') ||
+			setweight(to_tsvector('simple'::regconfig, back), 'B')))
+	`).Error
+}
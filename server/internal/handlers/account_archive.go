@@ -0,0 +1,687 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Account archives are zip files holding a manifest, one JSON file per kind
+// of record and the media they reference under media/. The version is bumped
+// whenever a change to the models would make older servers misread an
+// archive; import accepts every version up to its own.
+const (
+	accountArchiveFormat  = "lingolift-account"
+	accountArchiveVersion = 2 // 2 added decks
+
+	maxAccountArchiveBytes = 4 << 30
+	maxArchiveEntryBytes   = 512 << 20
+	maxArchiveMediaBytes   = 4 << 30 // all media files together, once extracted
+	archiveBatchSize       = 500
+)
+
+const (
+	archiveManifest   = "manifest.json"
+	archiveUser       = "user.json"
+	archivePresets    = "presets.json"
+	archiveDecks      = "decks.json"
+	archiveLessons    = "lessons.json"
+	archiveNotes      = "notes.json"
+	archiveFlashcards = "flashcards.json"
+	archiveReviewLogs = "review_logs.json"
+	archiveMediaDir   = "media/"
+)
+
+type accountManifest struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	ExportedAt int64          `json:"exportedAt"`
+	Counts     map[string]int `json:"counts"`
+	Media      []string       `json:"media"` // file names under media/
+}
+
+// accountSettings is the part of a user restored by an import.
+type accountSettings struct {
+	Username            string    `json:"username"`
+	CreatedAt           int64     `json:"createdAt"`
+	SchedulingAlgorithm string    `json:"schedulingAlgorithm"`
+	DesiredRetention    float64   `json:"desiredRetention"`
+	FSRSParameters      []float64 `json:"fsrsParameters"`
+	NewCardsPerDay      int       `json:"newCardsPerDay"`
+	ReviewsPerDay       int       `json:"reviewsPerDay"`
+	Timezone            string    `json:"timezone"`
+	LeechThreshold      int       `json:"leechThreshold"`
+	LeechAction         string    `json:"leechAction"`
+}
+
+// ExportAccountHandler streams a backup of everything the user owns,
+// including lessons and cards in the trash, as an account archive.
+func ExportAccountHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var presets []models.StudyPreset
+	var decks []models.Deck
+	var lessons []models.Lesson
+	var lessonNotes []models.Note
+	var cards []models.Flashcard
+	var logs []models.ReviewLog
+	for _, query := range []*gorm.DB{
+		db.DB.Where("user_id = ?", userID).Order("created_at").Find(&presets),
+		db.DB.Where("user_id = ?", userID).Order("created_at").Find(&decks),
+		db.DB.Where("user_id = ?", userID).Order("created_at").Find(&lessons),
+		db.DB.Where("user_id = ?", userID).Order("created_at").Find(&lessonNotes),
+		db.DB.Where("lesson_id IN (?)", db.DB.Model(&models.Lesson{}).Select("id").Where("user_id = ?", userID)).Order("seq").Find(&cards),
+		db.DB.Where("user_id = ?", userID).Order("reviewed_at").Find(&logs),
+	} {
+		if query.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read account data"})
+			return
+		}
+	}
+
+	media := archiveMedia(lessons, lessonNotes, cards)
+	manifest := accountManifest{
+		Format:     accountArchiveFormat,
+		Version:    accountArchiveVersion,
+		ExportedAt: time.Now().UnixMilli(),
+		Counts: map[string]int{
+			"presets": len(presets), "decks": len(decks), "lessons": len(lessons), "notes": len(lessonNotes),
+			"flashcards": len(cards), "reviewLogs": len(logs), "media": len(media),
+		},
+		Media: media,
+	}
+	settings := accountSettings{
+		Username:            user.Username,
+		CreatedAt:           user.CreatedAt,
+		SchedulingAlgorithm: user.SchedulingAlgorithm,
+		DesiredRetention:    user.DesiredRetention,
+		FSRSParameters:      user.FSRSParameters,
+		NewCardsPerDay:      user.NewCardsPerDay,
+		ReviewsPerDay:       user.ReviewsPerDay,
+		Timezone:            user.Timezone,
+		LeechThreshold:      user.LeechThreshold,
+		LeechAction:         user.LeechAction,
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`,
+		attachmentName("lingolift-"+user.Username+"-"+time.Now().UTC().Format(time.DateOnly), ".zip")))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	for _, entry := range []struct {
+		name  string
+		value any
+	}{
+		{archiveManifest, manifest}, {archiveUser, settings}, {archivePresets, presets}, {archiveDecks, decks},
+		{archiveLessons, lessons}, {archiveNotes, lessonNotes}, {archiveFlashcards, cards}, {archiveReviewLogs, logs},
+	} {
+		w, err := archive.Create(entry.name)
+		if err == nil {
+			err = json.NewEncoder(w).Encode(entry.value)
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	for _, name := range media {
+		w, err := archive.Create(archiveMediaDir + name)
+		if err == nil {
+			err = copyFile(w, filepath.Join(uploadsDir, name))
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		c.Error(err)
+	}
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+var uploadReference = regexp.MustCompile(`/uploads/([^\s\]\)"'<>/\\]+)`)
+
+// uploadURLs lists the uploads text refers to.
+func uploadURLs(text string) []string {
+	urls := make([]string, 0)
+	for _, match := range uploadReference.FindAllStringSubmatch(text, -1) {
+		urls = append(urls, "/uploads/"+match[1])
+	}
+	return urls
+}
+
+// archiveMedia lists the uploaded files the lessons, notes and cards refer
+// to: lesson audio and PDFs and media embedded in text.
+func archiveMedia(lessons []models.Lesson, lessonNotes []models.Note, cards []models.Flashcard) []string {
+	seen := make(map[string]bool)
+	media := make([]string, 0)
+	add := func(text string) {
+		for _, match := range uploadReference.FindAllStringSubmatch(text, -1) {
+			if name := match[1]; !seen[name] && isUploadedMediaURL("/uploads/"+name) {
+				seen[name] = true
+				media = append(media, name)
+			}
+		}
+	}
+	for _, lesson := range lessons {
+		add(lesson.AudioURL)
+		add(lesson.PDFURL)
+		add(lesson.MarkdownContent)
+	}
+	for _, note := range lessonNotes {
+		for _, field := range note.Fields {
+			add(field)
+		}
+	}
+	for _, card := range cards {
+		add(card.Front)
+		add(card.Back)
+	}
+	return media
+}
+
+type accountImportReport struct {
+	Presets    int  `json:"presets"`
+	Decks      int  `json:"decks"`
+	Lessons    int  `json:"lessons"`
+	Notes      int  `json:"notes"`
+	Flashcards int  `json:"flashcards"`
+	ReviewLogs int  `json:"reviewLogs"`
+	Media      int  `json:"media"`
+	Remapped   int  `json:"remapped"` // records given a new ID because theirs was taken
+	Existing   int  `json:"existing"` // records already in this account, left as they are
+	Skipped    int  `json:"skipped"`  // records whose lesson or card is not in the archive
+	Settings   bool `json:"settings"`
+}
+
+// ImportAccountHandler restores an account archive (form field "file") into
+// the signed in account, which may be new or already hold data. Records keep
+// their IDs unless another account uses them, in which case they get new ones
+// and references to them are rewritten; records this account already has are
+// left untouched, so a restore can be repeated. settings=true also restores
+// the user's study settings, which are checked like UpdateSRSSettingsHandler
+// checks them.
+func ImportAccountHandler(c *gin.Context) {
+	userID := getUserID(c)
+	restoreSettings, err := strconv.ParseBool(c.DefaultQuery("settings", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "settings must be true or false"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAccountArchiveBytes)
+	header, err := c.FormFile("file")
+	if err != nil {
+		badBody(c, err, "An archive file is required")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a zip archive"})
+		return
+	}
+	restore, err := readAccountArchive(archive)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var settings models.User
+	if restoreSettings {
+		if err := restore.settings.input().apply(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid settings in archive: " + err.Error()})
+			return
+		}
+	}
+
+	restore.userID = userID
+	restore.now = time.Now().UnixMilli()
+	restore.report.Settings = restoreSettings
+	if err := restore.restoreMedia(); err != nil {
+		removeMedia(restore.restored)
+		if errors.Is(err, errArchiveMediaTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore media"})
+		}
+		return
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := restore.run(tx); err != nil {
+			return err
+		}
+		if !restoreSettings {
+			return nil
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Select(srsSettingsColumns).Updates(&settings).Error
+	})
+	if err != nil {
+		removeMedia(restore.restored)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore archive"})
+		return
+	}
+	c.JSON(http.StatusOK, restore.report)
+}
+
+// input is the settings as UpdateSRSSettingsHandler would receive them. An
+// empty algorithm and a zero retention stand for the defaults and are kept.
+func (s accountSettings) input() srsSettingsInput {
+	in := srsSettingsInput{
+		Parameters:     &s.FSRSParameters,
+		NewCardsPerDay: &s.NewCardsPerDay,
+		ReviewsPerDay:  &s.ReviewsPerDay,
+		Timezone:       &s.Timezone,
+		LeechThreshold: &s.LeechThreshold,
+		LeechAction:    &s.LeechAction,
+	}
+	if s.SchedulingAlgorithm != "" {
+		algorithm := srs.Algorithm(s.SchedulingAlgorithm)
+		in.Algorithm = &algorithm
+	}
+	if s.DesiredRetention != 0 {
+		in.DesiredRetention = &s.DesiredRetention
+	}
+	return in
+}
+
+type accountRestore struct {
+	userID string
+	now    int64
+
+	manifest accountManifest
+	settings accountSettings
+	presets  []models.StudyPreset
+	decks    []models.Deck
+	lessons  []models.Lesson
+	notes    []models.Note
+	cards    []models.Flashcard
+	logs     []models.ReviewLog
+	media    map[string]*zip.File
+	restored []string        // URLs of the media files extracted into uploads
+	owned    map[string]bool // archived media name -> whether this account gets it
+
+	// archive ID -> ID in this account, per table
+	presetIDs, deckIDs, lessonIDs, noteIDs, cardIDs map[string]string
+	report                                          accountImportReport
+}
+
+func readAccountArchive(archive *zip.Reader) (*accountRestore, error) {
+	entries := make(map[string]*zip.File, len(archive.File))
+	restore := &accountRestore{media: make(map[string]*zip.File)}
+	for _, f := range archive.File {
+		entries[f.Name] = f
+		if name, ok := strings.CutPrefix(f.Name, archiveMediaDir); ok {
+			restore.media[name] = f
+		}
+	}
+
+	if err := readArchiveJSON(entries, archiveManifest, &restore.manifest); err != nil {
+		return nil, err
+	}
+	switch {
+	case restore.manifest.Format != accountArchiveFormat:
+		return nil, errors.New("not a LingoLift account archive")
+	case restore.manifest.Version < 1 || restore.manifest.Version > accountArchiveVersion:
+		return nil, fmt.Errorf("archive version %d is not supported by this server (up to %d)", restore.manifest.Version, accountArchiveVersion)
+	}
+	for name, value := range map[string]any{
+		archiveUser: &restore.settings, archivePresets: &restore.presets, archiveLessons: &restore.lessons,
+		archiveNotes: &restore.notes, archiveFlashcards: &restore.cards, archiveReviewLogs: &restore.logs,
+	} {
+		if err := readArchiveJSON(entries, name, value); err != nil {
+			return nil, err
+		}
+	}
+	if restore.manifest.Version >= 2 {
+		if err := readArchiveJSON(entries, archiveDecks, &restore.decks); err != nil {
+			return nil, err
+		}
+	}
+	return restore, nil
+}
+
+func readArchiveJSON(entries map[string]*zip.File, name string, value any) error {
+	f, ok := entries[name]
+	if !ok {
+		return fmt.Errorf("archive is missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(io.LimitReader(rc, maxArchiveEntryBytes)).Decode(value); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+func (r *accountRestore) run(tx *gorm.DB) error {
+	for name, owned := range r.owned {
+		if owned {
+			if err := recordUpload(tx, r.userID, "/uploads/"+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	var err error
+	if r.presetIDs, err = r.mapIDs(tx, "SELECT id, user_id FROM study_presets WHERE id IN ?", recordIDs(r.presets, func(p models.StudyPreset) string { return p.ID })); err != nil {
+		return err
+	}
+	presets := make([]models.StudyPreset, 0, len(r.presets))
+	for _, preset := range r.presets {
+		if id, ok := r.claim(r.presetIDs, preset.ID); ok {
+			preset.ID = id
+			preset.UserID = r.userID
+			preset.LastUpdated = r.now
+			presets = append(presets, preset)
+		}
+	}
+
+	if r.deckIDs, err = r.mapIDs(tx, "SELECT id, user_id FROM decks WHERE id IN ?", recordIDs(r.decks, func(d models.Deck) string { return d.ID })); err != nil {
+		return err
+	}
+	decks := make([]models.Deck, 0, len(r.decks))
+	for _, deck := range r.decks {
+		if id, ok := r.claim(r.deckIDs, deck.ID); ok {
+			deck.ID = id
+			deck.UserID = r.userID
+			deck.LastUpdated = r.now
+			decks = append(decks, deck)
+		}
+	}
+	// Parents are mapped once every deck is claimed, as they may come later
+	for i := range decks {
+		decks[i].ParentID = r.deckIDs[decks[i].ParentID]
+	}
+
+	if r.lessonIDs, err = r.mapIDs(tx, "SELECT id, user_id FROM lessons WHERE id IN ?", recordIDs(r.lessons, func(l models.Lesson) string { return l.ID })); err != nil {
+		return err
+	}
+	lessons := make([]models.Lesson, 0, len(r.lessons))
+	for _, lesson := range r.lessons {
+		if id, ok := r.claim(r.lessonIDs, lesson.ID); ok {
+			lesson.ID = id
+			lesson.UserID = r.userID
+			lesson.PresetID = r.presetIDs[lesson.PresetID]
+			lesson.DeckID = r.deckIDs[lesson.DeckID]
+			if !r.ownsMedia(lesson.AudioURL) {
+				lesson.AudioURL = ""
+			}
+			if !r.ownsMedia(lesson.PDFURL) {
+				lesson.PDFURL = ""
+			}
+			lesson.LastUpdated = r.now
+			lesson.Flashcards = nil
+			lessons = append(lessons, lesson)
+		}
+	}
+
+	if r.noteIDs, err = r.mapIDs(tx, "SELECT id, user_id FROM notes WHERE id IN ?", recordIDs(r.notes, func(n models.Note) string { return n.ID })); err != nil {
+		return err
+	}
+	lessonNotes := make([]models.Note, 0, len(r.notes))
+	for _, note := range r.notes {
+		lessonID, ok := r.lessonIDs[note.LessonID]
+		if !ok {
+			r.report.Skipped++
+			continue
+		}
+		if id, ok := r.claim(r.noteIDs, note.ID); ok {
+			note.ID = id
+			note.UserID = r.userID
+			note.LessonID = lessonID
+			note.LastUpdated = r.now
+			note.Flashcards = nil
+			lessonNotes = append(lessonNotes, note)
+		}
+	}
+
+	if r.cardIDs, err = r.mapIDs(tx, `SELECT f.id, l.user_id FROM flashcards f LEFT JOIN lessons l ON l.id = f.lesson_id WHERE f.id IN ?`,
+		recordIDs(r.cards, func(card models.Flashcard) string { return card.ID })); err != nil {
+		return err
+	}
+	cards := make([]models.Flashcard, 0, len(r.cards))
+	for _, card := range r.cards {
+		lessonID, ok := r.lessonIDs[card.LessonID]
+		if !ok {
+			r.report.Skipped++
+			continue
+		}
+		if id, ok := r.claim(r.cardIDs, card.ID); ok {
+			card.ID = id
+			card.LessonID = lessonID
+			card.NoteID = r.noteIDs[card.NoteID]
+			card.LastUpdated = r.now
+			cards = append(cards, card)
+		}
+	}
+
+	logIDs, err := r.mapIDs(tx, "SELECT id, user_id FROM review_logs WHERE id IN ?", recordIDs(r.logs, func(l models.ReviewLog) string { return l.ID }))
+	if err != nil {
+		return err
+	}
+	logs := make([]models.ReviewLog, 0, len(r.logs))
+	for _, log := range r.logs {
+		cardID, ok := r.cardIDs[log.CardID]
+		if !ok {
+			r.report.Skipped++
+			continue
+		}
+		if id, ok := r.claim(logIDs, log.ID); ok {
+			log.ID = id
+			log.CardID = cardID
+			log.UserID = r.userID
+			log.DeviceID = "" // devices belong to the instance the archive came from
+			log.SyncedAt = r.now
+			logs = append(logs, log)
+		}
+	}
+
+	r.report.Presets, r.report.Decks, r.report.Lessons, r.report.Notes = len(presets), len(decks), len(lessons), len(lessonNotes)
+	r.report.Flashcards, r.report.ReviewLogs = len(cards), len(logs)
+	for _, create := range []func() error{
+		func() error { return tx.CreateInBatches(&presets, archiveBatchSize).Error },
+		func() error { return tx.CreateInBatches(&decks, archiveBatchSize).Error },
+		func() error { return tx.Omit("Flashcards").CreateInBatches(&lessons, archiveBatchSize).Error },
+		func() error { return tx.Omit("Flashcards").CreateInBatches(&lessonNotes, archiveBatchSize).Error },
+		func() error { return tx.CreateInBatches(&cards, archiveBatchSize).Error },
+		func() error { return tx.CreateInBatches(&logs, archiveBatchSize).Error },
+	} {
+		if err := create(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claim returns the ID a record is created under, and false if the account
+// already has it.
+func (r *accountRestore) claim(mapping map[string]string, id string) (string, bool) {
+	if mapping[id] == "" {
+		r.report.Existing++
+		mapping[id] = id // records referring to it still link to the existing one
+		return "", false
+	}
+	return mapping[id], true
+}
+
+func recordIDs[T any](records []T, id func(T) string) []string {
+	result := make([]string, len(records))
+	for i, record := range records {
+		result[i] = id(record)
+	}
+	return result
+}
+
+// mapIDs decides the ID of each archived record: its own if free, a new one
+// if another account uses it, and "" if this account already has it. query
+// selects the ID and owning user of existing records.
+func (r *accountRestore) mapIDs(tx *gorm.DB, query string, archived []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(archived))
+	for _, id := range archived {
+		if _, err := uuid.Parse(id); err != nil {
+			mapping[id] = uuid.New().String() // the columns only hold UUIDs
+			r.report.Remapped++
+		} else {
+			mapping[id] = id
+		}
+	}
+	for start := 0; start < len(archived); start += archiveBatchSize {
+		var existing []struct {
+			ID     string
+			UserID *string
+		}
+		if err := tx.Raw(query, archived[start:min(start+archiveBatchSize, len(archived))]).Scan(&existing).Error; err != nil {
+			return nil, err
+		}
+		for _, record := range existing {
+			if record.UserID != nil && *record.UserID == r.userID {
+				mapping[record.ID] = ""
+			} else {
+				mapping[record.ID] = uuid.New().String()
+				r.report.Remapped++
+			}
+		}
+	}
+	return mapping, nil
+}
+
+var errArchiveMediaTooLarge = errors.New("Archive media files are too large")
+
+// restoreMedia copies the archived media into uploads, before the records
+// are restored. Only files the manifest lists under a name uploads are
+// given are restored. A file that is already there is only taken over if
+// the archive holds the same content, so an archive can't claim another
+// account's upload by its name.
+func (r *accountRestore) restoreMedia() error {
+	r.owned = make(map[string]bool)
+	remaining := int64(maxArchiveMediaBytes)
+	for _, name := range r.manifest.Media {
+		f, ok := r.media[name]
+		if !ok || !uploadName.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(uploadsDir, name)
+		if _, err := os.Stat(path); err == nil {
+			same, err := sameContent(f, path)
+			if err != nil {
+				return err
+			}
+			r.owned[name] = same
+			continue
+		}
+		if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
+			return err
+		}
+		written, err := extractFile(f, path, min(remaining, maxArchiveEntryBytes))
+		if err != nil {
+			return err
+		}
+		remaining -= written
+		r.restored = append(r.restored, "/uploads/"+name)
+		r.owned[name] = true
+		r.report.Media++
+	}
+	return nil
+}
+
+// sameContent reports whether f holds the same bytes as the file at path.
+func sameContent(f *zip.File, path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil || uint64(info.Size()) != f.UncompressedSize64 {
+		return false, err
+	}
+	hash := func(open func() (io.ReadCloser, error)) ([]byte, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		h := sha256.New()
+		_, err = io.Copy(h, io.LimitReader(rc, maxArchiveEntryBytes))
+		return h.Sum(nil), err
+	}
+	archived, err := hash(f.Open)
+	if err != nil {
+		return false, err
+	}
+	existing, err := hash(func() (io.ReadCloser, error) { return os.Open(path) })
+	return bytes.Equal(archived, existing), err
+}
+
+// ownsMedia reports whether lessons restored from the archive may refer to
+// url: files the archive restored or holds the same content of, and
+// uploads this account already has.
+func (r *accountRestore) ownsMedia(url string) bool {
+	if name, ok := strings.CutPrefix(url, "/uploads/"); ok && r.owned[name] {
+		return true
+	}
+	return ownsMediaURL(r.userID, url)
+}
+
+// extractFile writes f to path, failing if it is larger than limit.
+func extractFile(f *zip.File, path string, limit int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, io.LimitReader(rc, limit+1))
+	if err == nil && written > limit {
+		err = errArchiveMediaTooLarge
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return written, err
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildArchive zips files, adding empty record files and a manifest for
+// version unless files holds them.
+func buildArchive(t *testing.T, version int, media []string, files map[string]string) *zip.Reader {
+	t.Helper()
+	all := map[string]string{
+		archiveUser: `{}`, archivePresets: `[]`, archiveDecks: `[]`, archiveLessons: `[]`,
+		archiveNotes: `[]`, archiveFlashcards: `[]`, archiveReviewLogs: `[]`,
+	}
+	manifest, _ := json.Marshal(accountManifest{Format: accountArchiveFormat, Version: version, Media: media})
+	all[archiveManifest] = string(manifest)
+	for name, content := range files {
+		all[name] = content
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range all {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestReadAccountArchive(t *testing.T) {
+	restore, err := readAccountArchive(buildArchive(t, accountArchiveVersion, nil, map[string]string{
+		archiveLessons:                  `[{"id":"l1","title":"Greetings"}]`,
+		archiveMediaDir + "a_audio.mp3": "audio",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restore.lessons) != 1 || restore.lessons[0].Title != "Greetings" || restore.media["a_audio.mp3"] == nil {
+		t.Errorf("got lessons %+v, media %v", restore.lessons, restore.media)
+	}
+
+	// Version 1 archives predate decks.json
+	v1 := buildArchive(t, 1, nil, nil)
+	if _, err := readAccountArchive(v1); err != nil {
+		t.Errorf("version 1 archive: %v", err)
+	}
+
+	for name, files := range map[string]map[string]string{
+		"other format":   {archiveManifest: `{"format":"anki","version":1}`},
+		"newer version":  {archiveManifest: `{"format":"lingolift-account","version":99}`},
+		"malformed JSON": {archiveLessons: `[{"id":`},
+	} {
+		if _, err := readAccountArchive(buildArchive(t, accountArchiveVersion, nil, files)); err == nil {
+			t.Errorf("%s: archive was accepted", name)
+		}
+	}
+
+	missing := buildArchive(t, accountArchiveVersion, nil, nil)
+	missing.File = slices.DeleteFunc(missing.File, func(f *zip.File) bool { return f.Name == archiveNotes })
+	if _, err := readAccountArchive(missing); err == nil || !strings.Contains(err.Error(), archiveNotes) {
+		t.Errorf("archive without %s: got error %v", archiveNotes, err)
+	}
+}
+
+func TestRestoreMedia(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const (
+		restored = "123e4567-e89b-12d3-a456-426614174000_audio.mp3"
+		same     = "123e4567-e89b-12d3-a456-426614174001_pdf.pdf"
+		taken    = "123e4567-e89b-12d3-a456-426614174002_image.png"
+		unlisted = "123e4567-e89b-12d3-a456-426614174003_audio.mp3"
+		missing  = "123e4567-e89b-12d3-a456-426614174004_audio.mp3"
+	)
+	if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(uploadsDir, same), []byte("pdf"), 0o644)
+	os.WriteFile(filepath.Join(uploadsDir, taken), []byte("someone else's image"), 0o644)
+
+	archive := buildArchive(t, accountArchiveVersion,
+		[]string{restored, same, taken, missing, "../escape_audio.mp3", "notes.txt"},
+		map[string]string{
+			archiveMediaDir + restored:              "audio",
+			archiveMediaDir + same:                  "pdf",
+			archiveMediaDir + taken:                 "image",
+			archiveMediaDir + unlisted:              "audio",
+			archiveMediaDir + "../escape_audio.mp3": "audio",
+			archiveMediaDir + "notes.txt":           "text",
+		})
+	restore, err := readAccountArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restore.restoreMedia(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(restore.restored, []string{"/uploads/" + restored}) || restore.report.Media != 1 {
+		t.Errorf("restored %q, reported %d", restore.restored, restore.report.Media)
+	}
+	if !restore.owned[restored] || !restore.owned[same] || restore.owned[taken] {
+		t.Errorf("got owned %v", restore.owned)
+	}
+	if content, _ := os.ReadFile(filepath.Join(uploadsDir, taken)); string(content) != "someone else's image" {
+		t.Errorf("existing upload was overwritten with %q", content)
+	}
+	for _, name := range []string{unlisted, "notes.txt", "../escape_audio.mp3"} {
+		if _, err := os.Stat(filepath.Join(uploadsDir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was extracted", name)
+		}
+	}
+}
+
+func TestExtractFileLimit(t *testing.T) {
+	dir := t.TempDir()
+	archive := buildArchive(t, accountArchiveVersion, nil, map[string]string{"media/big": strings.Repeat("x", 100)})
+	var big *zip.File
+	for _, f := range archive.File {
+		if f.Name == "media/big" {
+			big = f
+		}
+	}
+
+	path := filepath.Join(dir, "big")
+	if _, err := extractFile(big, path, 99); !errors.Is(err, errArchiveMediaTooLarge) {
+		t.Errorf("got error %v, want errArchiveMediaTooLarge", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial file was left behind")
+	}
+	if written, err := extractFile(big, path, 100); err != nil || written != 100 {
+		t.Errorf("got %d bytes, error %v", written, err)
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/anki"
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Note types are exported under fixed IDs, so decks exported at different
+// times share them in Anki instead of piling up copies.
+const ankiNoteTypeBaseID = 1_500_000_000_000
+
+// ExportLessonAnkiHandler exports one lesson as an Anki package.
+func ExportLessonAnkiHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lesson models.Lesson
+	if err := db.DB.Where("id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).First(&lesson).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	exportAnki(c, userID, []models.Lesson{lesson}, lesson.Title)
+}
+
+// ExportAnkiHandler exports all lessons of the user as one Anki package,
+// each lesson a deck.
+func ExportAnkiHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lessons []models.Lesson
+	if err := db.DB.Where("user_id = ? AND deleted_at = 0", userID).Order("created_at").Find(&lessons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lessons"})
+		return
+	}
+	exportAnki(c, userID, lessons, "LingoLift")
+}
+
+func exportAnki(c *gin.Context, userID string, lessons []models.Lesson, name string) {
+	lessonIDs := make([]string, len(lessons))
+	for i, lesson := range lessons {
+		lessonIDs[i] = lesson.ID
+	}
+	var lessonNotes []models.Note
+	var cards []models.Flashcard
+	var logs []models.ReviewLog
+	if err := db.DB.Where("user_id = ? AND lesson_id IN ? AND deleted_at = 0", userID, lessonIDs).Order("created_at").Find(&lessonNotes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+		return
+	}
+	if err := db.DB.Where("lesson_id IN ? AND deleted_at = 0", lessonIDs).Order("template_ordinal").Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cards"})
+		return
+	}
+	if err := db.DB.Where("user_id = ? AND card_id IN (?)", userID,
+		db.DB.Model(&models.Flashcard{}).Select("id").Where("lesson_id IN ? AND deleted_at = 0", lessonIDs)).
+		Order("reviewed_at").Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review history"})
+		return
+	}
+
+	collection := newAnkiExport(time.Now()).build(lessons, lessonNotes, cards, logs)
+
+	tmp, err := os.CreateTemp("", "lingolift-export-*.apkg")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create package"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	err = anki.Write(tmp, collection)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create package"})
+		return
+	}
+	c.FileAttachment(tmp.Name(), attachmentName(name, ".apkg"))
+}
+
+var unsafeFilename = regexp.MustCompile(`[^\p{L}\p{N}._-]+`)
+
+// attachmentName turns a title into a download file name.
+func attachmentName(title, ext string) string {
+	name := strings.Trim(unsafeFilename.ReplaceAllString(title, "_"), "_.")
+	if name == "" {
+		name = "export"
+	}
+	return name + ext
+}
+
+type ankiExport struct {
+	now        time.Time
+	collection *anki.Collection
+	// Anki identifies everything by unix millis; these keep them unique per table
+	deckIDs, noteIDs, cardIDs, reviewIDs map[int64]bool
+	newPosition                          int64
+}
+
+func newAnkiExport(now time.Time) *ankiExport {
+	return &ankiExport{
+		now: now,
+		collection: &anki.Collection{
+			Decks:      make(map[int64]anki.Deck),
+			NoteTypes:  make(map[int64]anki.NoteType),
+			MediaPaths: make(map[string]string),
+		},
+		deckIDs:   map[int64]bool{0: true, 1: true}, // 1 is Anki's default deck
+		noteIDs:   make(map[int64]bool),
+		cardIDs:   make(map[int64]bool),
+		reviewIDs: make(map[int64]bool),
+	}
+}
+
+func uniqueAnkiID(used map[int64]bool, id int64) int64 {
+	for used[id] {
+		id++
+	}
+	used[id] = true
+	return id
+}
+
+func (ex *ankiExport) build(lessons []models.Lesson, lessonNotes []models.Note, cards []models.Flashcard, logs []models.ReviewLog) *anki.Collection {
+	// Review due dates count days from the collection's creation
+	created := ex.now.UnixMilli()
+	for _, lesson := range lessons {
+		created = min(created, lesson.CreatedAt)
+	}
+	for _, card := range cards {
+		if card.NextReview > 0 {
+			created = min(created, card.NextReview)
+		}
+	}
+	ex.collection.Created = time.UnixMilli(created).UTC().Truncate(24 * time.Hour)
+
+	for i, noteType := range notes.Types {
+		ex.addNoteType(ankiNoteTypeBaseID+int64(i), noteType)
+	}
+	deckIDs := make(map[string]int64, len(lessons))
+	lessonTags := make(map[string][]string, len(lessons))
+	for _, lesson := range lessons {
+		deckIDs[lesson.ID] = ex.addDeck(lesson)
+		lessonTags[lesson.ID] = lesson.Tags
+	}
+
+	cardsByNote := make(map[string][]models.Flashcard)
+	for _, card := range cards {
+		cardsByNote[card.NoteID] = append(cardsByNote[card.NoteID], card)
+	}
+	logsByCard := make(map[string][]models.ReviewLog)
+	for _, log := range logs {
+		logsByCard[log.CardID] = append(logsByCard[log.CardID], log)
+	}
+
+	exported := make(map[string]bool, len(lessonNotes))
+	for _, note := range lessonNotes {
+		exported[note.ID] = true
+		ex.addNote(note, cardsByNote[note.ID], deckIDs, lessonTags[note.LessonID], logsByCard)
+	}
+	// Cards from before notes existed, or whose note is gone, go out as basic notes
+	for _, card := range cards {
+		if exported[card.NoteID] {
+			continue
+		}
+		note := models.Note{
+			ID:       card.ID,
+			LessonID: card.LessonID,
+			NoteType: notes.TypeBasic,
+			Fields:   map[string]string{"Front": card.Front, "Back": card.Back},
+		}
+		card.TemplateOrdinal = 0
+		ex.addNote(note, []models.Flashcard{card}, deckIDs, lessonTags[card.LessonID], logsByCard)
+	}
+	return ex.collection
+}
+
+func (ex *ankiExport) addNoteType(id int64, noteType notes.Type) {
+	ankiType := anki.NoteType{ID: id, Name: "LingoLift " + noteType.Name, Fields: noteType.Fields, Cloze: noteType.Cloze}
+	for _, template := range noteType.Templates {
+		back := template.Back
+		if !noteType.Cloze {
+			back = "{{FrontSide}}\n\n<hr id=answer>\n\n" + back
+		}
+		ankiType.Templates = append(ankiType.Templates, anki.Template{
+			Name:  template.Name,
+			Front: strings.ReplaceAll(template.Front, "\n", "<br>"),
+			Back:  strings.ReplaceAll(back, "\n", "<br>"),
+		})
+	}
+	ex.collection.NoteTypes[id] = ankiType
+}
+
+// addDeck adds a deck for lesson. Anki has no place for a lesson's audio, so
+// it is linked from the deck description.
+func (ex *ankiExport) addDeck(lesson models.Lesson) int64 {
+	deck := anki.Deck{
+		ID:          uniqueAnkiID(ex.deckIDs, lesson.CreatedAt),
+		Name:        lesson.Title,
+		Description: ex.fieldHTML(lesson.Description),
+	}
+	if name := ex.media(lesson.AudioURL); name != "" {
+		deck.Description = strings.TrimPrefix(deck.Description+"<br>[sound:"+name+"]", "<br>")
+	}
+	ex.collection.Decks[deck.ID] = deck
+	return deck.ID
+}
+
+func (ex *ankiExport) addNote(note models.Note, cards []models.Flashcard, deckIDs map[string]int64, lessonTags []string, logsByCard map[string][]models.ReviewLog) {
+	noteTypeIndex := 0
+	for i, noteType := range notes.Types {
+		if noteType.Name == note.NoteType {
+			noteTypeIndex = i
+		}
+	}
+	noteType := notes.Types[noteTypeIndex]
+
+	created := note.CreatedAt
+	if created == 0 {
+		created = ex.now.UnixMilli()
+	}
+	ankiNote := anki.Note{
+		ID:         uniqueAnkiID(ex.noteIDs, created),
+		GUID:       note.ID,
+		NoteTypeID: ankiNoteTypeBaseID + int64(noteTypeIndex),
+		Modified:   max(note.LastUpdated, created) / 1000,
+	}
+	for _, field := range noteType.Fields {
+		ankiNote.Fields = append(ankiNote.Fields, ex.fieldHTML(note.Fields[field]))
+	}
+	tags := make(map[string]bool)
+	for _, tag := range lessonTags {
+		addAnkiTag(&ankiNote, tags, tag)
+	}
+
+	for _, card := range cards {
+		for _, tag := range card.Tags {
+			addAnkiTag(&ankiNote, tags, tag)
+		}
+		ordinal := card.TemplateOrdinal
+		if noteType.Cloze {
+			ordinal-- // Anki numbers cloze cards from 0
+		}
+		ankiCard := ex.cardState(card, len(logsByCard[card.ID]))
+		ankiCard.ID = uniqueAnkiID(ex.cardIDs, ankiNote.ID)
+		ankiCard.NoteID = ankiNote.ID
+		ankiCard.DeckID = deckIDs[card.LessonID]
+		ankiCard.Ordinal = ordinal
+		ankiCard.Modified = card.LastUpdated / 1000
+		ex.collection.Cards = append(ex.collection.Cards, ankiCard)
+
+		for _, log := range logsByCard[card.ID] {
+			if log.Grade < 0 {
+				continue // only the resulting state is known, there is no answer to log
+			}
+			reviewType := 1
+			if log.PrevInterval == 0 {
+				reviewType = 0
+			}
+			ex.collection.Revlog = append(ex.collection.Revlog, anki.Review{
+				ID:           uniqueAnkiID(ex.reviewIDs, log.ReviewedAt),
+				CardID:       ankiCard.ID,
+				Ease:         log.Grade + 1,
+				Interval:     log.NextInterval,
+				LastInterval: log.PrevInterval,
+				Factor:       int(math.Round(log.NextEFactor * 1000)),
+				TimeMs:       min(log.TimeTakenMs, 60_000), // Anki's own cap
+				Type:         reviewType,
+			})
+		}
+	}
+	ex.collection.Notes = append(ex.collection.Notes, ankiNote)
+}
+
+// addAnkiTag adds tag to note once. Anki separates tags by spaces.
+func addAnkiTag(note *anki.Note, seen map[string]bool, tag string) {
+	tag = strings.Join(strings.Fields(tag), "_")
+	if tag != "" && !seen[tag] {
+		seen[tag] = true
+		note.Tags = append(note.Tags, tag)
+	}
+}
+
+// cardState converts the schedule of card into Anki's: review cards are due
+// on a day counted from the collection's creation, cards in (re)learning at
+// a time, new cards in the order they are exported.
+func (ex *ankiExport) cardState(card models.Flashcard, reviews int) anki.Card {
+	ankiCard := anki.Card{
+		Factor: int(math.Round(card.EFactor * 1000)),
+		Reps:   reviews,
+		Lapses: card.Lapses,
+	}
+	switch {
+	case card.Repetition == 0 && card.Interval == 0 && card.LastReview == 0:
+		ex.newPosition++
+		ankiCard.Type, ankiCard.Queue, ankiCard.Due = anki.CardTypeNew, anki.QueueNew, ex.newPosition
+		ankiCard.Factor = 0
+	case card.Interval == 0:
+		ankiCard.Type, ankiCard.Queue, ankiCard.Due = anki.CardTypeLearning, anki.QueueLearning, card.NextReview/1000
+		if card.Lapses > 0 {
+			ankiCard.Type = anki.CardTypeRelearning
+		}
+	default:
+		ankiCard.Type, ankiCard.Queue = anki.CardTypeReview, anki.QueueReview
+		ankiCard.Interval = card.Interval
+		ankiCard.Due = int64(time.UnixMilli(card.NextReview).Sub(ex.collection.Created) / (24 * time.Hour))
+	}
+
+	switch {
+	case card.Suspended:
+		ankiCard.Queue = anki.QueueSuspended
+	case card.BuriedUntil > ex.now.UnixMilli():
+		ankiCard.Queue = anki.QueueUserBuried
+	}
+	if card.Stability > 0 {
+		data, _ := json.Marshal(map[string]float64{"s": card.Stability, "d": card.Difficulty})
+		ankiCard.Data = string(data)
+	}
+	return ankiCard
+}
+
+var lingoliftMedia = regexp.MustCompile(`\[sound:(/uploads/[^\]]+)\]|!\[[^\]]*\]\((/uploads/[^)\s]+)\)`)
+
+// fieldHTML turns card text into an Anki field: HTML escaped, with line
+// breaks and references to uploaded media in Anki's syntax. It is the
+// inverse of the import's fieldText.
+func (ex *ankiExport) fieldHTML(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range lingoliftMedia.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:match[0]]))
+		last = match[1]
+		if match[2] >= 0 {
+			if name := ex.media(text[match[2]:match[3]]); name != "" {
+				b.WriteString("[sound:" + name + "]")
+			}
+		} else if name := ex.media(text[match[4]:match[5]]); name != "" {
+			b.WriteString(fmt.Sprintf(`<img src="%s">`, html.EscapeString(name)))
+		}
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return strings.ReplaceAll(b.String(), "\n", "<br>")
+}
+
+// media adds an uploaded file to the package and returns its name there, or
+// "" if url is not an uploaded file.
+func (ex *ankiExport) media(url string) string {
+	if url == "" || !isUploadedMediaURL(url) {
+		return ""
+	}
+	name := strings.TrimPrefix(url, "/uploads/")
+	ex.collection.MediaPaths[name] = filepath.Join(uploadsDir, name)
+	return name
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/anki"
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxAnkiPackageBytes = 1 << 30
+
+// ankiNamespace derives stable IDs for imported Anki objects, so importing
+// the same package twice skips what is already there. Notes are keyed on
+// their GUID, which identifies them across collections.
+var ankiNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("lingolift:anki"))
+
+type ankiImportReport struct {
+	Lessons    int            `json:"lessons"`
+	LessonIDs  []string       `json:"lessonIds"`
+	Notes      int            `json:"notes"`
+	Cards      int            `json:"cards"`
+	ReviewLogs int            `json:"reviewLogs"`
+	Media      int            `json:"media"`
+	Skipped    map[string]int `json:"skipped"`   // reason -> count
+	Converted  map[string]int `json:"converted"` // what was adapted to fit LingoLift -> count
+}
+
+// ImportAnkiHandler imports an Anki package (.apkg, form field "file"). Each
+// deck becomes a lesson and each note a note with its cards, keeping their
+// review state and history. Media used by the notes goes to uploads.
+func ImportAnkiHandler(c *gin.Context) {
+	userID := getUserID(c)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAnkiPackageBytes)
+	header, err := c.FormFile("file")
+	if err != nil {
+		badBody(c, err, "An .apkg file is required")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	defer file.Close()
+
+	collection, err := anki.Read(file, header.Size)
+	if errors.Is(err, anki.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Package is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a valid Anki package: " + err.Error()})
+		return
+	}
+
+	importer := newAnkiImporter(userID, collection)
+	if err := db.DB.Transaction(importer.run); err != nil {
+		removeMedia(importer.saved)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import package"})
+		return
+	}
+	c.JSON(http.StatusOK, importer.report)
+}
+
+type ankiImporter struct {
+	userID      string
+	collection  *anki.Collection
+	now         time.Time
+	report      ankiImportReport
+	mediaURLs   map[string]string // Anki file name -> uploaded URL, "" if unusable
+	saved       []string          // uploaded URLs, removed if the import fails
+	lessonIDs   map[int64]string  // deck -> lesson
+	cardIDs     map[int64]string  // Anki card -> imported card
+	cardsByNote map[int64][]anki.Card
+	lastReview  map[int64]int64 // Anki card -> unix millis of its last review
+}
+
+func newAnkiImporter(userID string, collection *anki.Collection) *ankiImporter {
+	im := &ankiImporter{
+		userID:      userID,
+		collection:  collection,
+		now:         time.Now(),
+		report:      ankiImportReport{LessonIDs: make([]string, 0), Skipped: make(map[string]int), Converted: make(map[string]int)},
+		mediaURLs:   make(map[string]string),
+		lessonIDs:   make(map[int64]string),
+		cardIDs:     make(map[int64]string),
+		cardsByNote: make(map[int64][]anki.Card),
+		lastReview:  make(map[int64]int64),
+	}
+	for _, card := range collection.Cards {
+		im.cardsByNote[card.NoteID] = append(im.cardsByNote[card.NoteID], card)
+	}
+	for _, review := range collection.Revlog {
+		if review.Ease > 0 {
+			im.lastReview[review.CardID] = max(im.lastReview[review.CardID], review.ID)
+		}
+	}
+	return im
+}
+
+func (im *ankiImporter) id(kind string, ankiID int64) string {
+	return uuid.NewSHA1(ankiNamespace, []byte(im.userID+"/"+kind+"/"+strconv.FormatInt(ankiID, 10))).String()
+}
+
+// noteIDs returns the ID to import ankiNote as and the IDs under which it
+// may already exist: its GUID if that is the ID of a LingoLift note it was
+// exported from, and the ID imports used before they were keyed on GUIDs.
+func (im *ankiImporter) noteIDs(ankiNote anki.Note) (string, []string) {
+	legacy := im.id("note", ankiNote.ID)
+	if ankiNote.GUID == "" {
+		return legacy, []string{legacy}
+	}
+	id := uuid.NewSHA1(ankiNamespace, []byte(im.userID+"/guid/"+ankiNote.GUID)).String()
+	known := []string{id, legacy}
+	if exported, err := uuid.Parse(ankiNote.GUID); err == nil {
+		known = append(known, exported.String())
+	}
+	return id, known
+}
+
+func (im *ankiImporter) run(tx *gorm.DB) error {
+	for _, note := range im.collection.Notes {
+		if err := im.importNote(tx, note); err != nil {
+			return err
+		}
+	}
+	return im.importRevlog(tx)
+}
+
+func (im *ankiImporter) importNote(tx *gorm.DB, ankiNote anki.Note) error {
+	cards := im.cardsByNote[ankiNote.ID]
+	noteType, ok := im.collection.NoteTypes[ankiNote.NoteTypeID]
+	switch {
+	case len(cards) == 0:
+		im.report.Skipped["note without cards"]++
+		return nil
+	case !ok:
+		im.report.Skipped["note of unknown note type"]++
+		return nil
+	}
+
+	noteID, known := im.noteIDs(ankiNote)
+	var existing int64
+	if err := tx.Model(&models.Note{}).Where("id IN ? AND user_id = ?", known, im.userID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		im.report.Skipped["already imported"]++
+		return nil
+	}
+
+	// The note lives in the deck of its first card
+	lessonID, err := im.lesson(tx, cards[0].DeckID)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]string, len(ankiNote.Fields))
+	for i, field := range ankiNote.Fields {
+		fields[i] = im.fieldText(field)
+	}
+	note := models.Note{
+		ID:          noteID,
+		UserID:      im.userID,
+		LessonID:    lessonID,
+		CreatedAt:   ankiNote.ID,
+		LastUpdated: im.now.UnixMilli(),
+	}
+	hasReverse := false
+	for _, card := range cards {
+		hasReverse = hasReverse || card.Ordinal == 1
+	}
+	switch {
+	case noteType.Cloze:
+		note.NoteType = notes.TypeCloze
+		note.Fields = map[string]string{"Text": fieldAt(fields, 0), "Extra": strings.Join(nonEmpty(fields[min(1, len(fields)):]), "\n")}
+	case hasReverse && len(noteType.Templates) == 2:
+		note.NoteType = notes.TypeBasicReversed
+		note.Fields = map[string]string{"Front": fieldAt(fields, 0), "Back": fieldAt(fields, 1)}
+	default:
+		note.NoteType = notes.TypeBasic
+		note.Fields = map[string]string{"Front": fieldAt(fields, 0), "Back": strings.Join(nonEmpty(fields[min(1, len(fields)):]), "\n")}
+	}
+	if !noteType.Cloze && len(nonEmpty(fields)) > 2 {
+		im.report.Converted["extra fields joined into the back of the card"]++
+	}
+
+	kind, _ := notes.Lookup(note.NoteType)
+	if kind.Validate(note.Fields) != nil {
+		im.report.Skipped["note with an empty front"]++
+		return nil
+	}
+	if err := tx.Omit("Flashcards").Create(&note).Error; err != nil {
+		return err
+	}
+	if err := renderNoteCards(tx, &note, kind, im.now); err != nil {
+		return err
+	}
+	im.report.Notes++
+
+	byOrdinal := make(map[int]*models.Flashcard, len(note.Flashcards))
+	for i := range note.Flashcards {
+		byOrdinal[note.Flashcards[i].TemplateOrdinal] = &note.Flashcards[i]
+	}
+	for _, ankiCard := range cards {
+		ordinal := ankiCard.Ordinal
+		if noteType.Cloze {
+			ordinal++ // Anki numbers cloze cards from 0, c1 is ordinal 0
+		}
+		card, ok := byOrdinal[ordinal]
+		if !ok {
+			im.report.Skipped["card of an unsupported template"]++
+			continue
+		}
+		im.applyCardState(card, ankiCard, ankiNote.Tags)
+		if err := tx.Save(card).Error; err != nil {
+			return err
+		}
+		im.cardIDs[ankiCard.ID] = card.ID
+		im.report.Cards++
+	}
+	return nil
+}
+
+// lesson returns the lesson for an Anki deck, creating it on first use.
+func (im *ankiImporter) lesson(tx *gorm.DB, deckID int64) (string, error) {
+	if id, ok := im.lessonIDs[deckID]; ok {
+		return id, nil
+	}
+	deck, ok := im.collection.Decks[deckID]
+	if !ok {
+		deck = anki.Deck{ID: deckID, Name: "Anki import"}
+	}
+
+	lesson := models.Lesson{
+		ID:          im.id("deck", deckID),
+		UserID:      im.userID,
+		Title:       deck.Name,
+		Description: "Imported from Anki",
+		CreatedAt:   im.now.UnixMilli(),
+		Tags:        []string{},
+		LastUpdated: im.now.UnixMilli(),
+	}
+	// Exports from LingoLift link the lesson audio from the deck description
+	if match := ankiSound.FindStringSubmatch(deck.Description); match != nil {
+		lesson.AudioURL = im.media(html.UnescapeString(match[1]))
+	}
+	if description := im.fieldText(ankiSound.ReplaceAllString(deck.Description, "")); description != "" {
+		lesson.Description = description
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Flashcards").Create(&lesson)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected > 0 {
+		im.report.Lessons++
+		im.report.LessonIDs = append(im.report.LessonIDs, lesson.ID)
+	}
+	im.lessonIDs[deckID] = lesson.ID
+	return lesson.ID, nil
+}
+
+// applyCardState converts the scheduling of an Anki card. Anki's ease is the
+// SM-2 easiness in permille; review cards continue from their interval, cards
+// in (re)learning are due when Anki would have shown them.
+func (im *ankiImporter) applyCardState(card *models.Flashcard, ankiCard anki.Card, tags []string) {
+	day := 24 * time.Hour
+	state := srs.InitialState(im.now)
+	if ankiCard.Factor > 0 {
+		state.EFactor = min(max(float64(ankiCard.Factor)/1000, srs.MinEFactor), srs.MaxEFactor)
+	}
+
+	switch ankiCard.Type {
+	case anki.CardTypeReview:
+		state.Interval = min(max(ankiCard.Interval, 1), srs.MaxInterval)
+		// SM-2 moves to multiplying intervals from the third repetition
+		state.Repetition = 1
+		if state.Interval >= 6 {
+			state.Repetition = 2
+		}
+		state.NextReview = im.collection.Created.Add(time.Duration(ankiCard.Due) * day).UnixMilli()
+	case anki.CardTypeLearning, anki.CardTypeRelearning:
+		if ankiCard.Queue == anki.QueueDayLearning {
+			state.NextReview = im.collection.Created.Add(time.Duration(ankiCard.Due) * day).UnixMilli()
+		} else if ankiCard.Due > 0 {
+			state.NextReview = ankiCard.Due * 1000
+		}
+	}
+	if ankiCard.Type != anki.CardTypeNew {
+		state.Lapses = ankiCard.Lapses
+		state.LastReview = im.lastReview[ankiCard.ID]
+		var memory struct {
+			Stability  float64 `json:"s"`
+			Difficulty float64 `json:"d"`
+		}
+		if json.Unmarshal([]byte(ankiCard.Data), &memory) == nil && memory.Stability > 0 {
+			state.Stability, state.Difficulty = memory.Stability, memory.Difficulty
+		}
+	}
+	if err := srs.Validate(state); err != nil {
+		state = srs.InitialState(im.now)
+		im.report.Converted["invalid scheduling reset to new"]++
+	}
+
+	applySRSState(card, state)
+	card.Suspended = ankiCard.Queue == anki.QueueSuspended
+	card.Tags = tags
+	card.LastUpdated = im.now.UnixMilli()
+}
+
+// importRevlog adds the review history of the imported cards. Manual
+// reschedules are not reviews and are left out.
+func (im *ankiImporter) importRevlog(tx *gorm.DB) error {
+	logs := make([]models.ReviewLog, 0)
+	prevFactor := make(map[int64]float64)
+	for _, review := range im.collection.Revlog {
+		cardID, ok := im.cardIDs[review.CardID]
+		if !ok {
+			continue
+		}
+		if review.Ease < 1 || review.Ease > 4 {
+			im.report.Skipped["manual reschedule in review history"]++
+			continue
+		}
+		prev, ok := prevFactor[review.CardID]
+		if !ok {
+			prev = srs.InitialEFactor
+		}
+		next := prev
+		if review.Factor > 0 {
+			next = float64(review.Factor) / 1000
+		}
+		prevFactor[review.CardID] = next
+		logs = append(logs, models.ReviewLog{
+			ID:           im.id("revlog", review.ID),
+			CardID:       cardID,
+			UserID:       im.userID,
+			Grade:        review.Ease - 1,
+			TimeTakenMs:  review.TimeMs,
+			PrevInterval: max(review.LastInterval, 0), // negative while learning, in seconds
+			NextInterval: max(review.Interval, 0),
+			PrevEFactor:  prev,
+			NextEFactor:  next,
+			ReviewedAt:   review.ID,
+			SyncedAt:     im.now.UnixMilli(),
+		})
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+	// Imported history doesn't count towards today's study limits
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&logs, 500)
+	im.report.ReviewLogs = int(result.RowsAffected)
+	return result.Error
+}
+
+var (
+	ankiSound     = regexp.MustCompile(`\[sound:([^\]]+)\]`)
+	ankiImage     = regexp.MustCompile(`(?i)<img[^>]*?\ssrc=["']?([^"'>\s]+)["']?[^>]*>`)
+	htmlLineBreak = regexp.MustCompile(`(?i)<br\s*/?>|</(div|p|li|tr|h[1-6])>`)
+	htmlTag       = regexp.MustCompile(`<[^>]*>`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// fieldText turns an Anki field, which is HTML, into the plain text cards
+// hold. Media references point to the uploaded copies afterwards: sounds
+// keep Anki's [sound:...] syntax and images become Markdown images.
+func (im *ankiImporter) fieldText(field string) string {
+	field = ankiSound.ReplaceAllStringFunc(field, func(ref string) string {
+		if url := im.media(html.UnescapeString(ankiSound.FindStringSubmatch(ref)[1])); url != "" {
+			return "[sound:" + url + "]"
+		}
+		return ""
+	})
+	field = ankiImage.ReplaceAllStringFunc(field, func(ref string) string {
+		if url := im.media(html.UnescapeString(ankiImage.FindStringSubmatch(ref)[1])); url != "" {
+			return "![](" + url + ")"
+		}
+		return ""
+	})
+	if htmlTag.MatchString(field) {
+		im.report.Converted["HTML formatting removed"]++
+	}
+	field = htmlLineBreak.ReplaceAllString(field, "\n")
+	field = htmlTag.ReplaceAllString(field, "")
+	field = strings.ReplaceAll(html.UnescapeString(field), "\u00a0", " ")
+	return strings.TrimSpace(blankLines.ReplaceAllString(field, "\n\n"))
+}
+
+var ankiMediaKinds = map[string]string{
+	".mp3": "audio", ".ogg": "audio", ".oga": "audio", ".opus": "audio", ".wav": "audio",
+	".m4a": "audio", ".aac": "audio", ".flac": "audio", ".webm": "audio",
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".gif": "image", ".webp": "image", ".svg": "image",
+}
+
+// media uploads an Anki media file once and returns its URL, or "" if the
+// file is missing or of a kind LingoLift doesn't handle.
+func (im *ankiImporter) media(name string) string {
+	if url, ok := im.mediaURLs[name]; ok {
+		return url
+	}
+	im.mediaURLs[name] = ""
+
+	data, ok := im.collection.Media[name]
+	if !ok {
+		im.report.Skipped["missing media file"]++
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	kind, ok := ankiMediaKinds[ext]
+	if !ok {
+		im.report.Skipped["unsupported media file"]++
+		return ""
+	}
+	url, err := saveMedia(im.userID, kind, ext, data)
+	if err != nil {
+		im.report.Skipped["media file that failed to save"]++
+		return ""
+	}
+	im.mediaURLs[name] = url
+	im.saved = append(im.saved, url)
+	im.report.Media++
+	return url
+}
+
+func fieldAt(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+func nonEmpty(values []string) []string {
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
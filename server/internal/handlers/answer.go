@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lingolift-server/internal/answer"
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckAnswerHandler checks an answer typed for a card and suggests a grade.
+// With autoGrade the card is reviewed with that grade right away, and the
+// rescheduled card is returned along with the result.
+func CheckAnswerHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		Answer      string `json:"answer"`
+		TimeTakenMs int64  `json:"timeTakenMs"`
+		AutoGrade   bool   `json:"autoGrade"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return
+	}
+	if utf8.RuneCountInString(req.Answer) > answer.MaxLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "answer is too long"})
+		return
+	}
+
+	var card models.Flashcard
+	if err := db.DB.Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+		Where("flashcards.id = ? AND lessons.user_id = ? AND flashcards.deleted_at = 0", c.Param("id"), userID).
+		First(&card).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+
+	result := answer.Check(req.Answer, acceptedAnswers(card), time.Duration(req.TimeTakenMs)*time.Millisecond)
+	if !req.AutoGrade {
+		c.JSON(http.StatusOK, gin.H{"result": result})
+		return
+	}
+	if !reviewCard(c, &card, result.SuggestedGrade, req.TimeTakenMs) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result, "card": card})
+}
+
+// acceptedAnswers are the answers to card: the hidden text of a cloze card,
+// the variants listed on the back of any other card. A cloze card hiding
+// several deletions is only answered by all of them, in order.
+func acceptedAnswers(card models.Flashcard) []string {
+	if card.NoteID != "" {
+		var note models.Note
+		db.DB.Where("id = ?", card.NoteID).Limit(1).Find(&note)
+		if note.NoteType == notes.TypeCloze {
+			if hidden := notes.ClozeAnswers(note.Fields["Text"], card.TemplateOrdinal); len(hidden) > 0 {
+				return []string{strings.Join(hidden, " ")}
+			}
+		}
+	}
+	return answer.Alternatives(card.Back)
+}
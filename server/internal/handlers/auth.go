@@ -21,7 +21,7 @@ func RegisterHandler(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badBody(c, err, err.Error())
 		return
 	}
 
@@ -71,10 +71,10 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	// Set session/cookie (simplified for now, just return success)
-	// In a real app, we'd set a secure cookie here.
-	// For this iteration, we'll rely on the client to login immediately or we can set it here.
-	setAuthCookie(c, user.ID)
+	if err := setAuthCookie(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "User created", "user": user})
 }
@@ -86,7 +86,7 @@ func LoginHandler(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badBody(c, err, err.Error())
 		return
 	}
 
@@ -101,11 +101,17 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	setAuthCookie(c, user.ID)
+	if err := setAuthCookie(c, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Logged in", "user": user})
 }
 
 func LogoutHandler(c *gin.Context) {
+	if token, err := c.Cookie("auth_token"); err == nil && token != "" {
+		db.DB.Where("token = ?", token).Delete(&models.Session{})
+	}
 	c.SetCookie("auth_token", "", -1, "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
 }
@@ -118,7 +124,7 @@ func GetProfileHandler(c *gin.Context) {
 	}
 
 	var user models.User
-	if err := db.DB.Preload("APIKeys").First(&user, "id = ?", userID).Error; err != nil {
+	if err := db.DB.Preload("APIKeys").Preload("Devices", "revoked_at = 0").First(&user, "id = ?", userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -186,8 +192,21 @@ func generateAPIKey() string {
 	return hex.EncodeToString(bytes)
 }
 
-func setAuthCookie(c *gin.Context, userID string) {
-	// Simple cookie for now. In production, use a signed session or JWT.
+func setAuthCookie(c *gin.Context, userID string) error {
+	// The cookie carries a random session token so sessions can be revoked
 	// MaxAge: 30 days
-	c.SetCookie("auth_token", userID, 3600*24*30, "/", "", false, true)
+	const maxAge = 3600 * 24 * 30
+	now := time.Now()
+	session := models.Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Token:     generateAPIKey(),
+		CreatedAt: now.UnixMilli(),
+		ExpiresAt: now.Add(maxAge * time.Second).UnixMilli(),
+	}
+	if err := db.DB.Create(&session).Error; err != nil {
+		return err
+	}
+	c.SetCookie("auth_token", session.Token, maxAge, "/", "", false, true)
+	return nil
 }
@@ -6,16 +6,18 @@ import (
 
 	"lingolift-server/internal/db"
 	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 func CreateCardHandler(c *gin.Context) {
 	userID := getUserID(c)
 	var card models.Flashcard
 	if err := c.ShouldBindJSON(&card); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badBody(c, err, err.Error())
 		return
 	}
 
@@ -34,16 +36,20 @@ func CreateCardHandler(c *gin.Context) {
 		return
 	}
 
-	now := time.Now().UnixMilli()
-	card.LastUpdated = now
+	now := time.Now()
+	card.LastUpdated = now.UnixMilli()
 	if card.Interval == 0 {
-		card.Interval = 0
-		card.Repetition = 0
-		card.EFactor = 2.5
-		card.NextReview = now
+		applySRSState(&card, srs.InitialState(now))
 	}
 
-	if err := db.DB.Create(&card).Error; err != nil {
+	note := basicNoteForCard(&card, userID)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Flashcards").Create(&note).Error; err != nil {
+			return err
+		}
+		return tx.Create(&card).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create card"})
 		return
 	}
@@ -62,7 +68,11 @@ func DeleteCardHandler(c *gin.Context) {
 		WHERE id = ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?)
 	`, now, now, id, userID)
 
-	if result.Error != nil {
+	err := result.Error
+	if err == nil {
+		err = deleteCardNotes(db.DB, userID, []string{id}, now)
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete card"})
 		return
 	}
@@ -83,16 +93,131 @@ func UpdateCardHandler(c *gin.Context) {
 
 	now := time.Now().UnixMilli()
 
-	// Ensure card belongs to user's lesson
-	result := db.DB.Exec(`
-		UPDATE flashcards 
-		SET front = ?, back = ?, last_updated = ? 
-		WHERE id = ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?)
-	`, req.Front, req.Back, now, id, userID)
+	var updated int64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Ensure card belongs to user's lesson
+		result := tx.Exec(`
+			UPDATE flashcards 
+			SET front = ?, back = ?, last_updated = ? 
+			WHERE id = ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?)
+		`, req.Front, req.Back, now, id, userID)
+		updated = result.RowsAffected
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		var card models.Flashcard
+		if err := tx.First(&card, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return syncBasicNote(tx, card, now)
+	})
 
-	if result.Error != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update card"})
 		return
 	}
+	if updated == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Card updated"})
 }
+
+// ReviewCardHandler grades a card and lets the server compute its next schedule.
+func ReviewCardHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	var req struct {
+		Grade       *srs.Grade `json:"grade" binding:"required"`
+		TimeTakenMs int64      `json:"timeTakenMs"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Grade.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "grade must be 0 (again), 1 (hard), 2 (good) or 3 (easy)"})
+		return
+	}
+
+	var card models.Flashcard
+	if err := db.DB.Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+		Where("flashcards.id = ? AND lessons.user_id = ? AND flashcards.deleted_at = 0", id, userID).
+		First(&card).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+
+	if !reviewCard(c, &card, *req.Grade, req.TimeTakenMs) {
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// reviewCard schedules card after a review graded g and records the review.
+// It writes an error response and returns false if that fails.
+func reviewCard(c *gin.Context, card *models.Flashcard, g srs.Grade, timeTakenMs int64) bool {
+	userID := getUserID(c)
+	scheduler, err := schedulerForLesson(userID, card.LessonID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load scheduling settings"})
+		return false
+	}
+
+	now := time.Now()
+	prev := cardSRSState(*card)
+	next := scheduler.Schedule(prev, g, now)
+	applySRSState(card, next)
+	applyLeechRule(card, prev.Lapses, userLeechSettings(userID))
+	card.LastUpdated = now.UnixMilli()
+
+	reviewLog := newReviewLog(*card, userID, c.GetString("deviceID"), int(g), prev, next, now.UnixMilli())
+	reviewLog.TimeTakenMs = timeTakenMs
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(card).Error; err != nil {
+			return err
+		}
+		return appendReviewLog(tx, &reviewLog)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save review"})
+		return false
+	}
+	return true
+}
+
+func cardSRSState(card models.Flashcard) srs.State {
+	return srs.State{
+		Interval:   card.Interval,
+		Repetition: card.Repetition,
+		EFactor:    card.EFactor,
+		NextReview: card.NextReview,
+		Stability:  card.Stability,
+		Difficulty: card.Difficulty,
+		Lapses:     card.Lapses,
+		LastReview: card.LastReview,
+		Step:       card.LearningStep,
+	}
+}
+
+func applySRSState(card *models.Flashcard, state srs.State) {
+	card.Interval = state.Interval
+	card.Repetition = state.Repetition
+	card.EFactor = state.EFactor
+	card.NextReview = state.NextReview
+	card.Stability = state.Stability
+	card.Difficulty = state.Difficulty
+	card.Lapses = state.Lapses
+	card.LastReview = state.LastReview
+	card.LearningStep = state.Step
+}
+
+func progressSRSState(progress models.CardProgress) srs.State {
+	return srs.State{
+		Interval:   progress.Interval,
+		Repetition: progress.Repetition,
+		EFactor:    progress.EFactor,
+		NextReview: progress.NextReview,
+		Stability:  progress.Stability,
+		Difficulty: progress.Difficulty,
+		Lapses:     progress.Lapses,
+		LastReview: progress.LastReview,
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// LeechTag marks cards that keep being forgotten
+	LeechTag = "leech"
+
+	LeechActionTag     = "tag"
+	LeechActionSuspend = "suspend"
+)
+
+func SuspendCardHandler(c *gin.Context) {
+	updateCardState(c, func(card *models.Flashcard) {
+		card.Suspended = true
+	})
+}
+
+// UnsuspendCardHandler puts a card back into the review queue, whether it was
+// suspended or buried.
+func UnsuspendCardHandler(c *gin.Context) {
+	updateCardState(c, func(card *models.Flashcard) {
+		card.Suspended = false
+		card.BuriedUntil = 0
+	})
+}
+
+// BuryCardHandler hides a card from the review queue until `until` (unix
+// millis), by default the start of the next day in the user's timezone.
+func BuryCardHandler(c *gin.Context) {
+	var req struct {
+		Until int64 `json:"until"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			badBody(c, err, err.Error())
+			return
+		}
+	}
+
+	now := time.Now().In(userLocation(db.DB, getUserID(c)))
+	if req.Until == 0 {
+		req.Until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).UnixMilli()
+	} else if req.Until <= now.UnixMilli() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"})
+		return
+	}
+
+	updateCardState(c, func(card *models.Flashcard) {
+		card.BuriedUntil = req.Until
+	})
+}
+
+// updateCardState applies change to the user's card and bumps LastUpdated so
+// the new state reaches other devices on their next sync.
+func updateCardState(c *gin.Context, change func(card *models.Flashcard)) {
+	userID := getUserID(c)
+	var card models.Flashcard
+	if err := db.DB.Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+		Where("flashcards.id = ? AND lessons.user_id = ? AND flashcards.deleted_at = 0", c.Param("id"), userID).
+		First(&card).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+
+	change(&card)
+	card.LastUpdated = time.Now().UnixMilli()
+	if err := db.DB.Save(&card).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update card"})
+		return
+	}
+	c.JSON(http.StatusOK, card)
+}
+
+// GetLeechesHandler lists the user's live cards tagged as leeches, the most
+// forgotten first.
+func GetLeechesHandler(c *gin.Context) {
+	userID := getUserID(c)
+	cards := make([]models.Flashcard, 0)
+	if err := userCardsQuery(userID).
+		Where("CAST(flashcards.tags AS jsonb) @> ?", `["`+LeechTag+`"]`).
+		Order("flashcards.lapses DESC, flashcards.id ASC").
+		Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch leeches"})
+		return
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// applyLeechRule tags card as a leech when a lapse brings it to the user's
+// threshold, and suspends it if the user asked for that.
+func applyLeechRule(card *models.Flashcard, prevLapses int, user models.User) {
+	if user.LeechThreshold <= 0 || card.Lapses <= prevLapses || card.Lapses < user.LeechThreshold {
+		return
+	}
+	if !slices.Contains(card.Tags, LeechTag) {
+		card.Tags = append(card.Tags, LeechTag)
+	}
+	if user.LeechAction == LeechActionSuspend {
+		card.Suspended = true
+	}
+}
+
+// userLeechSettings loads just the leech options of a user; the zero value
+// disables detection.
+func userLeechSettings(userID string) models.User {
+	var user models.User
+	db.DB.Select("leech_threshold", "leech_action").Where("id = ?", userID).Limit(1).Find(&user)
+	return user
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var paragraphBreak = regexp.MustCompile(`\n[ \t]*\n`)
+
+// CreateLessonClozeHandler turns a selection of the lesson's markdown into a
+// cloze note. The selection is given as offsets into markdownContent counted
+// in UTF-16 code units, as string indices are in JavaScript and Dart, and each
+// deletion names text to blank out, in the order it appears in the selection. Deletions sharing an index are hidden on the same
+// card; by default every deletion gets its own card.
+func CreateLessonClozeHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		Start     int `json:"start"`
+		End       int `json:"end"`
+		Deletions []struct {
+			Text  string `json:"text"`
+			Hint  string `json:"hint"`
+			Index int    `json:"index"`
+		} `json:"deletions" binding:"required,min=1"`
+		Extra string `json:"extra"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return
+	}
+
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+
+	markdown := utf16.Encode([]rune(lesson.MarkdownContent))
+	if req.Start < 0 || req.End > len(markdown) || req.Start >= req.End {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Selection is outside the lesson's markdown"})
+		return
+	}
+	if splitsSurrogatePair(markdown, req.Start) || splitsSurrogatePair(markdown, req.End) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Selection cannot start or end inside a character"})
+		return
+	}
+	selection := string(utf16.Decode(markdown[req.Start:req.End]))
+
+	var text strings.Builder
+	rest := selection
+	for i, deletion := range req.Deletions {
+		if deletion.Text == "" || !notes.ValidClozeText(deletion.Text) || !notes.ValidClozeText(deletion.Hint) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Deletion text and hints cannot be empty or contain '::', '{' or '}'"})
+			return
+		}
+		at := strings.Index(rest, deletion.Text)
+		if at < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Deletion not found in the selection: " + deletion.Text})
+			return
+		}
+		index := deletion.Index
+		if index <= 0 {
+			index = i + 1
+		}
+		text.WriteString(rest[:at])
+		text.WriteString(notes.Cloze(index, deletion.Text, deletion.Hint))
+		rest = rest[at+len(deletion.Text):]
+	}
+	text.WriteString(rest)
+
+	fields := map[string]string{"Text": strings.TrimSpace(text.String())}
+	if req.Extra != "" {
+		fields["Extra"] = req.Extra
+	}
+	noteType, _ := notes.Lookup(notes.TypeCloze)
+	if !validateNoteFields(c, noteType, fields) {
+		return
+	}
+
+	paragraph := len(paragraphBreak.FindAllStringIndex(string(utf16.Decode(markdown[:req.Start])), -1))
+	now := time.Now()
+	note := models.Note{
+		ID:              uuid.New().String(),
+		UserID:          userID,
+		LessonID:        lesson.ID,
+		NoteType:        noteType.Name,
+		Fields:          fields,
+		SourceParagraph: &paragraph,
+		CreatedAt:       now.UnixMilli(),
+		LastUpdated:     now.UnixMilli(),
+	}
+	if err := createNote(&note, noteType, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cloze note"})
+		return
+	}
+	c.JSON(http.StatusCreated, note)
+}
+
+// splitsSurrogatePair reports whether offset i falls between the two halves
+// of a character encoded as a UTF-16 surrogate pair.
+func splitsSurrogatePair(units []uint16, i int) bool {
+	return i > 0 && i < len(units) && units[i-1] >= 0xD800 && units[i-1] < 0xDC00 && units[i] >= 0xDC00 && units[i] < 0xE000
+}
@@ -0,0 +1,544 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCSVBytes        = 50 << 20
+	maxCSVRows         = 50000
+	maxCSVErrors       = 100 // listed in the report; further errors are only counted
+	maxCSVPreview      = 200
+	csvInsertBatch     = 500
+	csvSniffBytes      = 64 << 10
+	csvDuplicateSkip   = "skip"
+	csvDuplicateUpdate = "update"
+	csvDuplicateAllow  = "allow"
+)
+
+// csvColumns are the columns of a card export, in order. Import recognises
+// the front, back and tags headers, so an export imports back as is.
+var csvColumns = []string{
+	"id", "front", "back", "tags", "suspended", "interval", "repetition", "efactor",
+	"nextReview", "stability", "difficulty", "lapses", "lastReview",
+}
+
+type csvRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type csvPreviewRow struct {
+	Line   int      `json:"line"`
+	Front  string   `json:"front"`
+	Back   string   `json:"back"`
+	Tags   []string `json:"tags"`
+	Action string   `json:"action"` // create, update or skip
+}
+
+type csvImportReport struct {
+	DryRun  bool            `json:"dryRun"`
+	Rows    int             `json:"rows"`
+	Created int             `json:"created"`
+	Updated int             `json:"updated"`
+	Skipped int             `json:"skipped"` // duplicates
+	Failed  int             `json:"failed"`
+	Errors  []csvRowError   `json:"errors"`
+	Preview []csvPreviewRow `json:"preview,omitempty"` // first rows of a dry run
+}
+
+// ImportCSVHandler adds cards to a lesson from a CSV or TSV file, sent as
+// form field "file" or as the request body. The file is read as it streams in.
+//
+// Query parameters:
+//   - delimiter: ",", ";", "|" or "tab"; guessed from the file if absent
+//   - header: whether the first row names the columns; guessed if absent
+//   - front, back, tags: column numbers (from 1) or header names; by default
+//     the columns named front, back and tags, or else the first two columns
+//   - duplicates: what to do with a row whose front is already in the lesson
+//     or earlier in the file: skip (default), update its back and tags, or allow
+//   - dryRun: report what would happen without changing anything
+//
+// Rows with errors are reported by line number and skipped; the others are
+// imported. The whole file is read before anything is written, so no
+// transaction is held open while it uploads.
+func ImportCSVHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+
+	duplicates := c.DefaultQuery("duplicates", csvDuplicateSkip)
+	if duplicates != csvDuplicateSkip && duplicates != csvDuplicateUpdate && duplicates != csvDuplicateAllow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duplicates must be 'skip', 'update' or 'allow'"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCSVBytes)
+	body, filename, err := csvUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input := bufio.NewReaderSize(body, csvSniffBytes)
+
+	delimiter, err := csvDelimiter(c.Query("delimiter"), filename, input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reader := csv.NewReader(input)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = delimiter == '\t' // TSV files rarely quote fields
+
+	importer := &csvImporter{
+		userID:     userID,
+		lessonID:   lesson.ID,
+		duplicates: duplicates,
+		now:        time.Now(),
+		reader:     reader,
+		report:     csvImportReport{DryRun: dryRun, Errors: make([]csvRowError, 0)},
+		seen:       make(map[string]int),
+	}
+	if err := importer.readHeader(c.Query("header"), c.Query("front"), c.Query("back"), c.Query("tags")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := importer.loadExisting(db.DB); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load lesson cards"})
+		return
+	}
+
+	err = importer.read()
+	if err == nil && !importer.report.DryRun {
+		err = db.DB.Transaction(importer.apply)
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large"})
+	case errors.Is(err, errCSVTooManyRows):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import cards"})
+	default:
+		c.JSON(http.StatusOK, importer.report)
+	}
+}
+
+// csvUpload returns the uploaded file without buffering it: the "file" part
+// of a multipart form, or else the request body.
+func csvUpload(c *gin.Context) (io.Reader, string, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		return c.Request.Body, "", nil
+	}
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, "", errors.New("File is required")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() == "file" {
+			return part, part.FileName(), nil
+		}
+	}
+}
+
+// csvDelimiter resolves the delimiter parameter. Without one, .tsv files and
+// files whose first line has tabs but no commas are tab separated.
+func csvDelimiter(param, filename string, input *bufio.Reader) (rune, error) {
+	switch param {
+	case "tab", `\t`, "\t":
+		return '\t', nil
+	case ",", ";", "|":
+		return rune(param[0]), nil
+	case "":
+	default:
+		return 0, errors.New("delimiter must be ',', ';', '|' or 'tab'")
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".tsv", ".tab":
+		return '\t', nil
+	case ".csv":
+		return ',', nil
+	}
+	peek, _ := input.Peek(csvSniffBytes)
+	if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+		peek = peek[:i]
+	}
+	if bytes.IndexByte(peek, '\t') >= 0 && bytes.IndexByte(peek, ',') < 0 {
+		return '\t', nil
+	}
+	return ',', nil
+}
+
+var errCSVTooManyRows = fmt.Errorf("file has more than %d rows", maxCSVRows)
+
+type csvImporter struct {
+	userID, lessonID string
+	duplicates       string
+	now              time.Time
+	reader           *csv.Reader
+	report           csvImportReport
+
+	front, back, tags int      // column indexes, tags -1 for none
+	pending           []string // first record, when it is not a header
+	pendingLine       int
+
+	existing map[string]models.Flashcard // duplicate key -> card in the lesson
+	basic    map[string]bool             // note IDs of basic notes, whose cards can be updated
+	seen     map[string]int              // duplicate key -> line earlier in the file
+
+	// Staged by read and written by apply
+	notes   []models.Note
+	cards   []models.Flashcard
+	updates []models.Flashcard
+}
+
+// readHeader reads the first record and resolves the column mapping.
+func (im *csvImporter) readHeader(header, front, back, tags string) error {
+	im.front, im.back, im.tags = 0, 1, -1
+	record, err := im.reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("line 1: %w", err)
+	}
+	if len(record) > 0 {
+		record[0] = strings.TrimPrefix(record[0], "\ufeff")
+	}
+
+	names := make(map[string]int, len(record))
+	for i, name := range record {
+		names[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasFront := names["front"]
+	_, hasBack := names["back"]
+	switch header {
+	case "true", "1":
+	case "false", "0":
+		names = nil
+	case "":
+		if !hasFront || !hasBack {
+			names = nil
+		}
+	default:
+		return errors.New("header must be true or false")
+	}
+	if names == nil {
+		im.pending = record
+		im.pendingLine, _ = im.reader.FieldPos(0)
+	} else {
+		if hasFront {
+			im.front = names["front"]
+		}
+		if hasBack {
+			im.back = names["back"]
+		}
+		if i, ok := names["tags"]; ok {
+			im.tags = i
+		}
+	}
+
+	for _, column := range []struct {
+		param string
+		index *int
+		name  string
+	}{{front, &im.front, "front"}, {back, &im.back, "back"}, {tags, &im.tags, "tags"}} {
+		if column.param == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(column.param); err == nil && n >= 1 {
+			*column.index = n - 1
+		} else if i, ok := names[strings.ToLower(strings.TrimSpace(column.param))]; ok {
+			*column.index = i
+		} else {
+			return fmt.Errorf("%s column %q not found", column.name, column.param)
+		}
+	}
+	return nil
+}
+
+// csvDuplicateKey identifies fronts that count as the same card: equal up to
+// case, Unicode normalization and spacing.
+func csvDuplicateKey(front string) string {
+	return strings.ToLower(norm.NFC.String(strings.Join(strings.Fields(front), " ")))
+}
+
+func (im *csvImporter) loadExisting(tx *gorm.DB) error {
+	var cards []models.Flashcard
+	if err := tx.Where("lesson_id = ? AND deleted_at = 0", im.lessonID).Order("seq").Find(&cards).Error; err != nil {
+		return err
+	}
+	var basicIDs []string
+	if err := tx.Model(&models.Note{}).
+		Where("lesson_id = ? AND note_type = ? AND deleted_at = 0", im.lessonID, notes.TypeBasic).
+		Pluck("id", &basicIDs).Error; err != nil {
+		return err
+	}
+	im.existing = make(map[string]models.Flashcard, len(cards))
+	for _, card := range cards {
+		if _, ok := im.existing[csvDuplicateKey(card.Front)]; !ok {
+			im.existing[csvDuplicateKey(card.Front)] = card
+		}
+	}
+	im.basic = make(map[string]bool, len(basicIDs))
+	for _, id := range basicIDs {
+		im.basic[id] = true
+	}
+	return nil
+}
+
+// read checks the remaining records and stages the cards to create and
+// update, which a dry run only reports.
+func (im *csvImporter) read() error {
+	if im.pending != nil {
+		if err := im.row(im.pending, im.pendingLine); err != nil {
+			return err
+		}
+	}
+	for {
+		record, err := im.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			im.fail(parseErr.StartLine, parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := im.reader.FieldPos(0)
+		if err := im.row(record, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply writes the staged cards.
+func (im *csvImporter) apply(tx *gorm.DB) error {
+	for _, card := range im.updates {
+		if err := tx.Model(&card).Select("back", "tags", "last_updated").Updates(card).Error; err != nil {
+			return err
+		}
+		if err := syncBasicNote(tx, card, im.now.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	if len(im.cards) == 0 {
+		return nil
+	}
+	if err := tx.Omit("Flashcards").CreateInBatches(&im.notes, csvInsertBatch).Error; err != nil {
+		return err
+	}
+	return tx.CreateInBatches(&im.cards, csvInsertBatch).Error
+}
+
+func (im *csvImporter) fail(line int, message string) {
+	im.report.Rows++
+	im.report.Failed++
+	if len(im.report.Errors) < maxCSVErrors {
+		im.report.Errors = append(im.report.Errors, csvRowError{Line: line, Error: message})
+	}
+}
+
+func (im *csvImporter) row(record []string, line int) error {
+	if im.report.Rows >= maxCSVRows {
+		return errCSVTooManyRows
+	}
+	if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+		return nil // blank line
+	}
+	column := func(i int) (string, bool) {
+		if i < len(record) {
+			return strings.TrimSpace(record[i]), true
+		}
+		return "", false
+	}
+	front, ok := column(im.front)
+	if !ok {
+		im.fail(line, fmt.Sprintf("missing front column %d", im.front+1))
+		return nil
+	}
+	back, ok := column(im.back)
+	if !ok {
+		im.fail(line, fmt.Sprintf("missing back column %d", im.back+1))
+		return nil
+	}
+	switch {
+	case front == "":
+		im.fail(line, "front is empty")
+		return nil
+	case !utf8.ValidString(front) || !utf8.ValidString(back):
+		im.fail(line, "text is not valid UTF-8")
+		return nil
+	}
+	tags := make([]string, 0)
+	if field, ok := column(im.tags); ok {
+		tags = splitTags(field)
+	}
+
+	action := "create"
+	key := csvDuplicateKey(front)
+	existing, inLesson := im.existing[key]
+	if earlier, inFile := im.seen[key]; inFile && im.duplicates != csvDuplicateAllow {
+		im.fail(line, fmt.Sprintf("duplicate of line %d", earlier))
+		return nil
+	}
+	if inLesson && im.duplicates == csvDuplicateSkip {
+		action = "skip"
+	} else if inLesson && im.duplicates == csvDuplicateUpdate {
+		if !im.basic[existing.NoteID] {
+			im.fail(line, "duplicate of a card generated from a note, update the note instead")
+			return nil
+		}
+		action = "update"
+	}
+	im.seen[key] = line
+	im.report.Rows++
+
+	if im.report.DryRun && len(im.report.Preview) < maxCSVPreview {
+		im.report.Preview = append(im.report.Preview, csvPreviewRow{Line: line, Front: front, Back: back, Tags: tags, Action: action})
+	}
+	switch action {
+	case "skip":
+		im.report.Skipped++
+	case "update":
+		im.report.Updated++
+		if im.report.DryRun {
+			return nil
+		}
+		existing.Back = back
+		existing.Tags = tags
+		existing.LastUpdated = im.now.UnixMilli()
+		im.updates = append(im.updates, existing)
+	case "create":
+		im.report.Created++
+		if im.report.DryRun {
+			return nil
+		}
+		card := models.Flashcard{
+			ID:            uuid.New().String(),
+			LessonID:      im.lessonID,
+			Front:         front,
+			Back:          back,
+			IsUserCreated: true,
+			LastUpdated:   im.now.UnixMilli(),
+			Tags:          tags,
+		}
+		applySRSState(&card, srs.InitialState(im.now))
+		im.notes = append(im.notes, basicNoteForCard(&card, im.userID))
+		im.cards = append(im.cards, card)
+	}
+	return nil
+}
+
+// splitTags splits a tags cell on commas and semicolons.
+func splitTags(field string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.FieldsFunc(field, func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ExportCSVHandler streams the cards of a lesson as CSV, or TSV with
+// delimiter=tab, including their scheduling state (times in unix millis).
+func ExportCSVHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	delimiter, ext, contentType := ',', ".csv", "text/csv; charset=utf-8"
+	switch c.DefaultQuery("delimiter", ",") {
+	case ",":
+	case ";":
+		delimiter = ';'
+	case "tab", `\t`, "\t":
+		delimiter, ext, contentType = '\t', ".tsv", "text/tab-separated-values; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delimiter must be ',', ';' or 'tab'"})
+		return
+	}
+
+	rows, err := db.DB.Model(&models.Flashcard{}).Where("lesson_id = ? AND deleted_at = 0", lesson.ID).Order("seq").Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cards"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, attachmentName(lesson.Title, ext)))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Comma = delimiter
+	w.Write(csvColumns)
+	for rows.Next() {
+		var card models.Flashcard
+		if err := db.DB.ScanRows(rows, &card); err != nil {
+			c.Error(err)
+			return
+		}
+		w.Write([]string{
+			card.ID, card.Front, card.Back, strings.Join(card.Tags, ", "),
+			strconv.FormatBool(card.Suspended),
+			strconv.Itoa(card.Interval),
+			strconv.Itoa(card.Repetition),
+			strconv.FormatFloat(card.EFactor, 'f', -1, 64),
+			strconv.FormatInt(card.NextReview, 10),
+			strconv.FormatFloat(card.Stability, 'f', -1, 64),
+			strconv.FormatFloat(card.Difficulty, 'f', -1, 64),
+			strconv.Itoa(card.Lapses),
+			strconv.FormatInt(card.LastReview, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		c.Error(err)
+	}
+}
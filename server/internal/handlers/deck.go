@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// deckNode is a deck in the tree returned by GetDecksHandler.
+type deckNode struct {
+	models.Deck
+	LessonIDs []string    `json:"lessonIds"` // lessons filed in the deck, in order
+	Children  []*deckNode `json:"children"`
+}
+
+// GetDecksHandler returns the user's deck tree with the lessons filed in
+// each deck, and the lessons that are in no deck.
+func GetDecksHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var decks []models.Deck
+	if err := db.DB.Where("user_id = ? AND deleted_at = 0", userID).Order("position, name, id").Find(&decks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch decks"})
+		return
+	}
+	var lessons []models.Lesson
+	if err := db.DB.Select("id, deck_id").Where("user_id = ? AND deleted_at = 0", userID).
+		Order("position, created_at, id").Find(&lessons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch decks"})
+		return
+	}
+
+	nodes := make(map[string]*deckNode, len(decks))
+	for _, deck := range decks {
+		nodes[deck.ID] = &deckNode{Deck: deck, LessonIDs: []string{}, Children: []*deckNode{}}
+	}
+	roots := make([]*deckNode, 0)
+	for _, deck := range decks {
+		if parent, ok := nodes[deck.ParentID]; ok {
+			parent.Children = append(parent.Children, nodes[deck.ID])
+		} else {
+			roots = append(roots, nodes[deck.ID])
+		}
+	}
+	unfiled := make([]string, 0)
+	for _, lesson := range lessons {
+		if node, ok := nodes[lesson.DeckID]; ok {
+			node.LessonIDs = append(node.LessonIDs, lesson.ID)
+		} else {
+			unfiled = append(unfiled, lesson.ID)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"decks": roots, "lessonIds": unfiled})
+}
+
+var (
+	errParentDeckNotFound = errors.New("Parent deck not found")
+	errDeckCycle          = errors.New("A deck can't be moved into itself or its descendants")
+)
+
+type deckPlacement struct {
+	ParentID string `json:"parentId"`
+	Position *int   `json:"position"` // index among the siblings, the end if absent
+}
+
+// CreateDeckHandler creates a deck, top-level or inside parentId.
+func CreateDeckHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		Name string `json:"name"`
+		deckPlacement
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	deck := models.Deck{ID: uuid.New().String(), UserID: userID, Name: name, CreatedAt: now, LastUpdated: now}
+	err := placeDeck(&deck, req.deckPlacement, now, func(tx *gorm.DB) error {
+		return tx.Create(&deck).Error
+	})
+	if errors.Is(err, errParentDeckNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create deck"})
+		return
+	}
+	c.JSON(http.StatusCreated, deck)
+}
+
+// UpdateDeckHandler renames a deck.
+func UpdateDeckHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	var deck models.Deck
+	if err := db.DB.First(&deck, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	deck.Name = name
+	deck.LastUpdated = time.Now().UnixMilli()
+	if err := db.DB.Save(&deck).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update deck"})
+		return
+	}
+	c.JSON(http.StatusOK, deck)
+}
+
+// MoveDeckHandler moves a deck, with everything in it, to another parent
+// or position. A deck can't be moved into itself or one of its descendants.
+func MoveDeckHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req deckPlacement
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	var deck models.Deck
+	if err := db.DB.First(&deck, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	now := time.Now().UnixMilli()
+	err := placeDeck(&deck, req, now, func(tx *gorm.DB) error {
+		return tx.Model(&deck).Select("parent_id", "position", "last_updated").Updates(&deck).Error
+	})
+	if errors.Is(err, errParentDeckNotFound) || errors.Is(err, errDeckCycle) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move deck"})
+		return
+	}
+	c.JSON(http.StatusOK, deck)
+}
+
+// placeDeck puts deck under placement.ParentID at placement.Position,
+// renumbering its new siblings, and calls save to store the deck itself.
+func placeDeck(deck *models.Deck, placement deckPlacement, now int64, save func(tx *gorm.DB) error) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if placement.ParentID != "" {
+			ancestors, err := deckAncestors(tx, deck.UserID, placement.ParentID)
+			if err != nil {
+				return err
+			}
+			if ancestors == nil {
+				return errParentDeckNotFound
+			}
+			if slices.Contains(ancestors, deck.ID) {
+				return errDeckCycle
+			}
+		}
+		var siblings []models.Deck
+		if err := tx.Select("id, position").
+			Where("user_id = ? AND parent_id = ? AND deleted_at = 0 AND id <> ?", deck.UserID, placement.ParentID, deck.ID).
+			Order("position, name, id").Find(&siblings).Error; err != nil {
+			return err
+		}
+		order, positions := make([]string, len(siblings)), make(map[string]int, len(siblings))
+		for i, sibling := range siblings {
+			order[i], positions[sibling.ID] = sibling.ID, sibling.Position
+		}
+		order = insertAt(order, deck.ID, placement.Position)
+
+		deck.ParentID = placement.ParentID
+		deck.Position = slices.Index(order, deck.ID)
+		deck.LastUpdated = now
+		if err := save(tx); err != nil {
+			return err
+		}
+		positions[deck.ID] = deck.Position
+		return renumber(tx, &models.Deck{}, order, positions, now)
+	})
+}
+
+// DeleteDeckHandler deletes a deck and the decks inside it. Their lessons
+// are kept and move up to the deleted deck's parent.
+func DeleteDeckHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var deck models.Deck
+	if err := db.DB.First(&deck, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteDeck(tx, deck, time.Now().UnixMilli())
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deck"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deck deleted"})
+}
+
+// deleteDeck soft-deletes deck and its descendants, appending their
+// lessons to those of the deck's parent.
+func deleteDeck(tx *gorm.DB, deck models.Deck, now int64) error {
+	subtree, err := deckSubtree(tx, deck.UserID, deck.ID)
+	if err != nil || subtree == nil {
+		return err
+	}
+	if err := tx.Model(&models.Deck{}).Where("id IN ?", subtree).
+		Updates(map[string]any{"deleted_at": now, "last_updated": now}).Error; err != nil {
+		return err
+	}
+
+	var last struct{ Position *int }
+	if err := tx.Model(&models.Lesson{}).Select("MAX(position) AS position").
+		Where("user_id = ? AND deck_id = ? AND deleted_at = 0", deck.UserID, deck.ParentID).
+		Scan(&last).Error; err != nil {
+		return err
+	}
+	next := 0
+	if last.Position != nil {
+		next = *last.Position + 1
+	}
+	var lessons []models.Lesson
+	if err := tx.Select("id").Where("user_id = ? AND deck_id IN ?", deck.UserID, subtree).
+		Order("position, created_at, id").Find(&lessons).Error; err != nil {
+		return err
+	}
+	for i, lesson := range lessons {
+		if err := tx.Model(&models.Lesson{}).Where("id = ?", lesson.ID).
+			Updates(map[string]any{"deck_id": deck.ParentID, "position": next + i, "last_updated": now}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveLessonHandler files a lesson in a deck (none if deckId is empty) at
+// a position among its lessons.
+func MoveLessonHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		DeckID   string `json:"deckId"`
+		Position *int   `json:"position"` // index among the deck's lessons, the end if absent
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	if req.DeckID != "" && !ownsDeck(db.DB, userID, req.DeckID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Deck not found"})
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var siblings []models.Lesson
+		if err := tx.Select("id, position").
+			Where("user_id = ? AND deck_id = ? AND deleted_at = 0 AND id <> ?", userID, req.DeckID, lesson.ID).
+			Order("position, created_at, id").Find(&siblings).Error; err != nil {
+			return err
+		}
+		order, positions := make([]string, len(siblings)), make(map[string]int, len(siblings))
+		for i, sibling := range siblings {
+			order[i], positions[sibling.ID] = sibling.ID, sibling.Position
+		}
+		order = insertAt(order, lesson.ID, req.Position)
+
+		lesson.DeckID = req.DeckID
+		lesson.Position = slices.Index(order, lesson.ID)
+		lesson.LastUpdated = now
+		if err := tx.Model(&lesson).Select("deck_id", "position", "last_updated").Updates(&lesson).Error; err != nil {
+			return err
+		}
+		positions[lesson.ID] = lesson.Position
+		return renumber(tx, &models.Lesson{}, order, positions, now)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move lesson"})
+		return
+	}
+	c.JSON(http.StatusOK, lesson)
+}
+
+// insertAt returns ids with id inserted at index, or at the end if index
+// is nil or out of range.
+func insertAt(ids []string, id string, index *int) []string {
+	if index == nil || *index < 0 || *index > len(ids) {
+		return append(ids, id)
+	}
+	return slices.Insert(ids, *index, id)
+}
+
+// renumber sets the position of the records of model to their index in
+// order, updating only those whose position in positions differs.
+func renumber(tx *gorm.DB, model any, order []string, positions map[string]int, now int64) error {
+	for i, id := range order {
+		if positions[id] == i {
+			continue
+		}
+		if err := tx.Model(model).Where("id = ?", id).
+			Updates(map[string]any{"position": i, "last_updated": now}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ownsDeck(tx *gorm.DB, userID, deckID string) bool {
+	var count int64
+	tx.Model(&models.Deck{}).Where("id = ? AND user_id = ? AND deleted_at = 0", deckID, userID).Count(&count)
+	return count > 0
+}
+
+// userDeckParents maps the IDs of the user's live decks to their parents.
+func userDeckParents(tx *gorm.DB, userID string) (map[string]string, error) {
+	var decks []models.Deck
+	if err := tx.Select("id, parent_id").Where("user_id = ? AND deleted_at = 0", userID).Find(&decks).Error; err != nil {
+		return nil, err
+	}
+	parents := make(map[string]string, len(decks))
+	for _, deck := range decks {
+		parents[deck.ID] = deck.ParentID
+	}
+	return parents, nil
+}
+
+// deckAncestors returns deckID followed by its ancestors, nil if the user
+// has no such live deck.
+func deckAncestors(tx *gorm.DB, userID, deckID string) ([]string, error) {
+	parents, err := userDeckParents(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := parents[deckID]; !ok {
+		return nil, nil
+	}
+	var ancestors []string
+	for id := deckID; !slices.Contains(ancestors, id); id = parents[id] {
+		if _, ok := parents[id]; !ok {
+			break
+		}
+		ancestors = append(ancestors, id)
+	}
+	return ancestors, nil
+}
+
+// deckSubtree returns deckID and the IDs of its descendants, nil if the
+// user has no such live deck.
+func deckSubtree(tx *gorm.DB, userID, deckID string) ([]string, error) {
+	parents, err := userDeckParents(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := parents[deckID]; !ok {
+		return nil, nil
+	}
+	children := make(map[string][]string, len(parents))
+	for id, parent := range parents {
+		children[parent] = append(children[parent], id)
+	}
+	subtree := []string{deckID}
+	for i := 0; i < len(subtree); i++ {
+		subtree = append(subtree, children[subtree[i]]...)
+	}
+	return subtree, nil
+}
+
+// deckScope reads the deckId query parameter used to restrict the review
+// queue and statistics to a deck and its descendants. It returns nil
+// without the parameter, and writes a 404 response and returns false if the
+// deck doesn't exist.
+func deckScope(c *gin.Context, userID string) ([]string, bool) {
+	deckID := c.Query("deckId")
+	if deckID == "" {
+		return nil, true
+	}
+	subtree, err := deckSubtree(db.DB, userID, deckID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch decks"})
+		return nil, false
+	}
+	if subtree == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return nil, false
+	}
+	return subtree, true
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Devices that have not synced for this long no longer hold back tombstone purging
+	staleDeviceAge = 90 * 24 * time.Hour
+	// Deleted lessons stay restorable from the trash for at least this long
+	trashRetention = 30 * 24 * time.Hour
+	// Tombstones of a user are purged at most this often
+	purgeInterval = time.Hour
+)
+
+// lastPurge holds when the tombstones of each user were last purged.
+var lastPurge sync.Map
+
+// schedulePurge purges the user's tombstones in the background unless that
+// already happened within purgeInterval.
+func schedulePurge(userID string) {
+	now := time.Now()
+	if last, ok := lastPurge.Load(userID); ok && now.Sub(last.(time.Time)) < purgeInterval {
+		return
+	}
+	if last, loaded := lastPurge.Swap(userID, now); loaded && now.Sub(last.(time.Time)) < purgeInterval {
+		return
+	}
+	go purgeTombstones(userID)
+}
+
+func GetDevicesHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var devices []models.Device
+	if err := db.DB.Where("user_id = ? AND revoked_at = 0", userID).Order("last_seen_at DESC").Find(&devices).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// RevokeDeviceHandler signs a device out remotely: its web sessions are
+// dropped and further requests carrying its ID are refused. Its API key is
+// deleted too, unless other devices that are still signed in use the same key;
+// the key then stays valid for them and the response carries a warning.
+func RevokeDeviceHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+
+	var device models.Device
+	if err := db.DB.First(&device, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	var sharedWith []string
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&device).Update("revoked_at", time.Now().UnixMilli()).Error; err != nil {
+			return err
+		}
+		if device.APIKeyID != "" {
+			if err := tx.Model(&models.Device{}).
+				Where("api_key_id = ? AND user_id = ? AND id <> ? AND revoked_at = 0", device.APIKeyID, userID, device.ID).
+				Pluck("name", &sharedWith).Error; err != nil {
+				return err
+			}
+		}
+		if device.APIKeyID != "" && len(sharedWith) == 0 {
+			if err := tx.Where("id = ? AND user_id = ?", device.APIKeyID, userID).Delete(&models.APIKey{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("device_id = ? AND user_id = ?", device.ID, userID).Delete(&models.Session{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	if len(sharedWith) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Device signed out",
+			"warning":    "The device's API key is also used by other devices and was not deleted; delete it from the API keys to sign them all out",
+			"sharedWith": sharedWith,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Device signed out"})
+}
+
+// registerDevice creates or refreshes the device record for a sync request.
+// fullResync records whether the app handles full resyncs, which purging
+// tombstones depends on. It returns nil if the client did not identify itself.
+func registerDevice(c *gin.Context, userID string, info *models.DeviceInfo, fullResync bool) *models.Device {
+	if info == nil {
+		info = &models.DeviceInfo{ID: c.GetHeader("X-Device-ID")}
+	}
+	if _, err := uuid.Parse(info.ID); err != nil {
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	var device models.Device
+	if err := db.DB.First(&device, "id = ?", info.ID).Error; err == nil {
+		if device.UserID != userID {
+			debugf("Device %s belongs to another user, not registering", info.ID)
+			return nil
+		}
+	} else {
+		device = models.Device{
+			ID:        info.ID,
+			UserID:    userID,
+			CreatedAt: now,
+		}
+	}
+
+	if info.Name != "" {
+		device.Name = info.Name
+	}
+	if info.Platform != "" {
+		device.Platform = info.Platform
+	}
+	if info.AppVersion != "" {
+		device.AppVersion = info.AppVersion
+	}
+	if apiKeyID := c.GetString("apiKeyID"); apiKeyID != "" {
+		device.APIKeyID = apiKeyID
+	}
+	// The auth middleware only lets a revoked device through with a newly issued credential
+	device.RevokedAt = 0
+	device.LastSeenAt = now
+	device.FullResync = fullResync
+
+	if err := db.DB.Save(&device).Error; err != nil {
+		log.Printf("Failed to register device: %v", err)
+		return nil
+	}
+	if sessionID := c.GetString("sessionID"); sessionID != "" {
+		db.DB.Model(&models.Session{}).Where("id = ?", sessionID).Update("device_id", device.ID)
+	}
+	return &device
+}
+
+// purgeTombstones hard-deletes soft-deleted cards, lessons and decks that
+// every active device has already synced past, along with the review logs
+// and notes of the purged cards and the revisions of the purged lessons.
+// Media of the purged lessons that nothing else uses is removed afterwards.
+// Lessons are also kept for the trash retention period so they can still be
+// restored. Nothing is purged while an active client
+// could miss the deletions: one that syncs without identifying as a device,
+// or an app that can't do the full resync it is asked for after a purge.
+func purgeTombstones(userID string) {
+	now := time.Now()
+	activeSince := now.Add(-staleDeviceAge).UnixMilli()
+	var user models.User
+	if err := db.DB.Select("unregistered_sync_at").First(&user, "id = ?", userID).Error; err != nil ||
+		user.UnregisteredSyncAt >= activeSince {
+		return
+	}
+	active := db.DB.Model(&models.Device{}).Where("user_id = ? AND revoked_at = 0 AND last_seen_at >= ?", userID, activeSince)
+	var outdated int64
+	if err := active.Session(&gorm.Session{}).Where("full_resync = ?", false).Count(&outdated).Error; err != nil || outdated > 0 {
+		return
+	}
+	var cursor int64
+	err := active.Session(&gorm.Session{}).Select("COALESCE(MIN(last_sync_cursor), 0)").Scan(&cursor).Error
+	if err != nil || cursor == 0 {
+		return
+	}
+
+	lessonCutoff := min(cursor, now.Add(-trashRetention).UnixMilli())
+
+	released := make([]string, 0)
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		purgedLessons := tx.Model(&models.Lesson{}).Select("id").
+			Where("user_id = ? AND deleted_at > 0 AND deleted_at < ?", userID, lessonCutoff)
+		var lessons []models.Lesson
+		if err := tx.Select("audio_url, pdf_url, markdown_content").
+			Where("user_id = ? AND deleted_at > 0 AND deleted_at < ?", userID, lessonCutoff).Find(&lessons).Error; err != nil {
+			return err
+		}
+		var revisions []models.LessonRevision
+		if err := tx.Select("audio_url, pdf_url, markdown_content").Where("lesson_id IN (?)", purgedLessons).Find(&revisions).Error; err != nil {
+			return err
+		}
+		for _, lesson := range lessons {
+			released = append(released, lesson.AudioURL, lesson.PDFURL)
+			released = append(released, uploadURLs(lesson.MarkdownContent)...)
+		}
+		for _, revision := range revisions {
+			released = append(released, revision.AudioURL, revision.PDFURL)
+			released = append(released, uploadURLs(revision.MarkdownContent)...)
+		}
+		if err := tx.Exec(`
+			DELETE FROM review_logs
+			WHERE user_id = ? AND card_id IN (
+				SELECT id FROM flashcards
+				WHERE lesson_id IN (?) OR (deleted_at > 0 AND deleted_at < ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?))
+			)
+		`, userID, purgedLessons, cursor, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("lesson_id IN (?)", purgedLessons).Delete(&models.Flashcard{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`
+			DELETE FROM flashcards
+			WHERE deleted_at > 0 AND deleted_at < ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?)
+		`, cursor, userID).Error; err != nil {
+			return err
+		}
+		// Notes of purged lessons, and deleted notes none of whose cards are left
+		if err := tx.Exec(`
+			DELETE FROM notes
+			WHERE user_id = ? AND (lesson_id IN (?) OR (
+				deleted_at > 0 AND deleted_at < ? AND NOT EXISTS (SELECT 1 FROM flashcards WHERE flashcards.note_id = notes.id)))
+		`, userID, purgedLessons, cursor).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("lesson_id IN (?)", purgedLessons).Delete(&models.LessonRevision{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND deleted_at > 0 AND deleted_at < ?", userID, lessonCutoff).Delete(&models.Lesson{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND deleted_at > 0 AND deleted_at < ?", userID, cursor).Delete(&models.Deck{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ? AND tombstones_purged_before < ?", userID, cursor).
+			Update("tombstones_purged_before", cursor).Error
+	})
+	if err != nil {
+		log.Printf("Failed to purge tombstones for user %s: %v", userID, err)
+		return
+	}
+
+	unused := make([]string, 0, len(released))
+	for _, url := range released {
+		if url != "" && !slices.Contains(unused, url) && !mediaInUse(url) {
+			unused = append(unused, url)
+		}
+	}
+	removeMedia(unused)
+}
@@ -3,16 +3,17 @@ package handlers
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"lingolift-server/internal/db"
 	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 func CreateLessonHandler(c *gin.Context) {
@@ -20,7 +21,7 @@ func CreateLessonHandler(c *gin.Context) {
 	// Parse Multipart Form
 	// 32 MB max memory
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+		badBody(c, err, "Failed to parse multipart form")
 		return
 	}
 
@@ -48,16 +49,12 @@ func CreateLessonHandler(c *gin.Context) {
 	if err == nil {
 		ext := filepath.Ext(audioFile.Filename)
 		filename := fmt.Sprintf("%s_audio%s", lessonID, ext)
-		savePath := filepath.Join("uploads", filename)
-
-		// Ensure uploads directory exists
-		os.MkdirAll("uploads", os.ModePerm)
-
-		if err := c.SaveUploadedFile(audioFile, savePath); err != nil {
+		url, err := saveUpload(c, userID, audioFile, filename)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save audio file"})
 			return
 		}
-		lesson.AudioURL = "/uploads/" + filename
+		lesson.AudioURL = url
 	}
 
 	// Handle PDF Upload
@@ -65,16 +62,12 @@ func CreateLessonHandler(c *gin.Context) {
 	if err == nil {
 		ext := filepath.Ext(pdfFile.Filename)
 		filename := fmt.Sprintf("%s_pdf%s", lessonID, ext)
-		savePath := filepath.Join("uploads", filename)
-
-		// Ensure uploads directory exists
-		os.MkdirAll("uploads", os.ModePerm)
-
-		if err := c.SaveUploadedFile(pdfFile, savePath); err != nil {
+		url, err := saveUpload(c, userID, pdfFile, filename)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save PDF file"})
 			return
 		}
-		lesson.PDFURL = "/uploads/" + filename
+		lesson.PDFURL = url
 	}
 
 	// Handle Markdown Content (Text)
@@ -102,84 +95,99 @@ func CreateLessonHandler(c *gin.Context) {
 	c.JSON(http.StatusCreated, lesson)
 }
 
+// UpdateLessonHandler replaces lesson fields from a multipart form. New
+// clients should use PatchLessonHandler and the media sub-resources. Like
+// them it honours If-Match, checked again with the lesson locked.
 func UpdateLessonHandler(c *gin.Context) {
 	userID := getUserID(c)
 	id := c.Param("id")
+	// Checked before uploads are stored, and again by editLesson
 	var lesson models.Lesson
-	if err := db.DB.First(&lesson, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", id, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
 		return
 	}
+	if !lessonMatches(c, lesson) {
+		writeStaleLesson(c, lesson)
+		return
+	}
 
 	// Parse Multipart Form
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+		badBody(c, err, "Failed to parse multipart form")
 		return
 	}
 
-	title := c.PostForm("title")
-	description := c.PostForm("description")
-
-	if title != "" {
-		lesson.Title = title
+	// Scheduling override is only touched when the field is sent ("" resets to the user's default)
+	algorithm, setAlgorithm := c.GetPostForm("schedulingAlgorithm")
+	if setAlgorithm && algorithm != "" && !srs.Algorithm(algorithm).Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schedulingAlgorithm must be 'sm2' or 'fsrs'"})
+		return
 	}
-	lesson.Description = description // Allow clearing description
-
-	// Handle Audio Upload
-	audioFile, err := c.FormFile("audio")
-	if err == nil {
-		ext := filepath.Ext(audioFile.Filename)
-		filename := fmt.Sprintf("%s_audio_%d%s", id, time.Now().Unix(), ext) // Append timestamp to avoid cache issues
-		savePath := filepath.Join("uploads", filename)
-
-		os.MkdirAll("uploads", os.ModePerm)
-
-		if err := c.SaveUploadedFile(audioFile, savePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save audio file"})
+	presetID, setPreset := c.GetPostForm("presetId")
+	if setPreset && presetID != "" {
+		var count int64
+		db.DB.Model(&models.StudyPreset{}).Where("id = ? AND user_id = ?", presetID, userID).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Preset not found"})
 			return
 		}
-		lesson.AudioURL = "/uploads/" + filename
 	}
 
-	// Handle PDF Upload
-	pdfFile, err := c.FormFile("pdf")
-	if err == nil {
-		ext := filepath.Ext(pdfFile.Filename)
-		filename := fmt.Sprintf("%s_pdf_%d%s", id, time.Now().Unix(), ext)
-		savePath := filepath.Join("uploads", filename)
-
-		os.MkdirAll("uploads", os.ModePerm)
-
-		if err := c.SaveUploadedFile(pdfFile, savePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save PDF file"})
+	// Handle Audio and PDF Uploads
+	uploaded := make([]string, 0, 2)
+	media := make(map[string]string)
+	for _, kind := range []string{"audio", "pdf"} {
+		file, err := c.FormFile(kind)
+		if err != nil {
+			continue
+		}
+		filename := fmt.Sprintf("%s_%s%s", uuid.New().String(), kind, filepath.Ext(file.Filename))
+		url, err := saveUpload(c, userID, file, filename)
+		if err != nil {
+			removeMedia(uploaded)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save " + kind + " file"})
 			return
 		}
-		lesson.PDFURL = "/uploads/" + filename
+		uploaded = append(uploaded, url)
+		media[kind] = url
 	}
 
-	// Handle Markdown Content
+	title := c.PostForm("title")
+	description := c.PostForm("description")
 	markdownContent := c.PostForm("markdown")
-	lesson.MarkdownContent = markdownContent
-
-	// Handle Tags
 	tagsStr := c.PostForm("tags")
-	if tagsStr != "" {
-		lesson.Tags = strings.Split(tagsStr, ",")
-		for i := range lesson.Tags {
-			lesson.Tags[i] = strings.TrimSpace(lesson.Tags[i])
+
+	if !editLesson(c, func(tx *gorm.DB, lesson *models.Lesson) error {
+		if title != "" {
+			lesson.Title = title
 		}
-	} else {
-		lesson.Tags = []string{}
-	}
+		lesson.Description = description // Allow clearing description
+		for kind, url := range media {
+			setLessonMedia(lesson, kind, url)
+		}
+		lesson.MarkdownContent = markdownContent
 
-	lesson.LastUpdated = time.Now().UnixMilli()
+		// Handle Tags
+		if tagsStr != "" {
+			lesson.Tags = strings.Split(tagsStr, ",")
+			for i := range lesson.Tags {
+				lesson.Tags[i] = strings.TrimSpace(lesson.Tags[i])
+			}
+		} else {
+			lesson.Tags = []string{}
+		}
 
-	if err := db.DB.Save(&lesson).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lesson"})
-		return
+		if setAlgorithm {
+			lesson.SchedulingAlgorithm = algorithm
+		}
+		if setPreset {
+			lesson.PresetID = presetID
+		}
+		return nil
+	}) {
+		removeMedia(uploaded)
 	}
-
-	c.JSON(http.StatusOK, lesson)
 }
 
 func DeleteLessonHandler(c *gin.Context) {
@@ -193,26 +201,6 @@ func DeleteLessonHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Lesson deleted"})
 }
 
-func GetLessonsHandler(c *gin.Context) {
-	userID := getUserID(c)
-	var lessons []models.Lesson
-	if err := db.DB.Preload("Flashcards", "deleted_at = 0").Where("user_id = ? AND deleted_at = 0", userID).Find(&lessons).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lessons"})
-		return
-	}
-	c.JSON(http.StatusOK, lessons)
-}
-
-func GetDeletedLessonsHandler(c *gin.Context) {
-	userID := getUserID(c)
-	var lessons []models.Lesson
-	if err := db.DB.Preload("Flashcards", "deleted_at = 0").Where("user_id = ? AND deleted_at > 0", userID).Find(&lessons).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted lessons"})
-		return
-	}
-	c.JSON(http.StatusOK, lessons)
-}
-
 func RestoreLessonHandler(c *gin.Context) {
 	userID := getUserID(c)
 	id := c.Param("id")
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errLessonNotFound = errors.New("Lesson not found")
+	errStaleLesson    = errors.New("Lesson was changed since it was loaded")
+)
+
+// lessonInputError is an edit that can't be applied, answered with 400.
+type lessonInputError string
+
+func (e lessonInputError) Error() string { return string(e) }
+
+// lessonETag is the entity tag of the current version of a lesson. Clients
+// can also build it from lastUpdated, which changes with every edit.
+func lessonETag(lesson models.Lesson) string {
+	return `"` + strconv.FormatInt(lesson.LastUpdated, 10) + `"`
+}
+
+// lessonMatches reports whether the If-Match header, if any, names the
+// current version of lesson. Weak tags count too, since the version is
+// identified by lastUpdated either way.
+func lessonMatches(c *gin.Context, lesson models.Lesson) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	current := lessonETag(lesson)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+func writeStaleLesson(c *gin.Context, lesson models.Lesson) {
+	c.Header("ETag", lessonETag(lesson))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": errStaleLesson.Error(), "lastUpdated": lesson.LastUpdated})
+}
+
+// editLesson applies edit to the user's live lesson :id and saves it with a
+// revision. The lesson is locked while edit runs, so an If-Match check
+// can't race another edit. It writes the response, the lesson with its new
+// ETag on success, and returns whether the edit was saved.
+func editLesson(c *gin.Context, edit func(tx *gorm.DB, lesson *models.Lesson) error) bool {
+	return editLessonAs(c, revisionEdit, edit)
+}
+
+// editLessonAs is editLesson recording the revision with the given source.
+func editLessonAs(c *gin.Context, source string, edit func(tx *gorm.DB, lesson *models.Lesson) error) bool {
+	userID := getUserID(c)
+	var lesson, before models.Lesson
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+			return errLessonNotFound
+		}
+		if !lessonMatches(c, lesson) {
+			return errStaleLesson
+		}
+		before = lesson
+		if err := edit(tx, &lesson); err != nil {
+			return err
+		}
+		// The version must change even for two edits within a millisecond
+		lesson.LastUpdated = max(time.Now().UnixMilli(), before.LastUpdated+1)
+		if err := tx.Omit("Flashcards").Save(&lesson).Error; err != nil {
+			return err
+		}
+		return recordLessonRevision(tx, before, lesson, source)
+	})
+
+	var inputErr lessonInputError
+	switch {
+	case errors.Is(err, errLessonNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errStaleLesson):
+		writeStaleLesson(c, lesson)
+	case errors.As(err, &inputErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lesson"})
+	default:
+		pruneLessonRevisions(lesson.ID)
+		c.Header("ETag", lessonETag(lesson))
+		c.JSON(http.StatusOK, lesson)
+		return true
+	}
+	return false
+}
+
+// PatchLessonHandler updates lesson metadata with a JSON Merge Patch (RFC
+// 7396): fields in the body replace the lesson's, null resets a field to
+// empty and fields left out are kept. Patchable fields are title,
+// description, markdownContent, tags, schedulingAlgorithm and presetId.
+// Media is replaced through /lessons/:id/audio and /lessons/:id/pdf.
+//
+// With If-Match set to the lesson's ETag, the patch is rejected with 412 if
+// the lesson was changed in the meantime.
+func PatchLessonHandler(c *gin.Context) {
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/merge-patch+json"})
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		badBody(c, err, "Body must be a JSON object")
+		return
+	}
+	userID := getUserID(c)
+
+	editLesson(c, func(tx *gorm.DB, lesson *models.Lesson) error {
+		for field, value := range patch {
+			if err := patchLessonField(tx, userID, lesson, field, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func patchLessonField(tx *gorm.DB, userID string, lesson *models.Lesson, field string, value json.RawMessage) error {
+	null := string(value) == "null"
+	var s string
+	if !null && field != "tags" {
+		if err := json.Unmarshal(value, &s); err != nil {
+			return lessonInputError(field + " must be a string or null")
+		}
+	}
+
+	switch field {
+	case "title":
+		if s = strings.TrimSpace(s); s == "" {
+			return lessonInputError("Title is required")
+		}
+		lesson.Title = s
+	case "description":
+		lesson.Description = s
+	case "markdownContent":
+		lesson.MarkdownContent = s
+	case "tags":
+		var tags []string
+		if !null {
+			if err := json.Unmarshal(value, &tags); err != nil {
+				return lessonInputError("tags must be an array of strings or null")
+			}
+		}
+		lesson.Tags = compactTags(tags)
+	case "schedulingAlgorithm":
+		if s != "" && !srs.Algorithm(s).Valid() {
+			return lessonInputError("schedulingAlgorithm must be 'sm2' or 'fsrs'")
+		}
+		lesson.SchedulingAlgorithm = s
+	case "presetId":
+		if s != "" {
+			var count int64
+			if err := tx.Model(&models.StudyPreset{}).Where("id = ? AND user_id = ?", s, userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return lessonInputError("Preset not found")
+			}
+		}
+		lesson.PresetID = s
+	case "audioUrl", "pdfUrl":
+		return lessonInputError(fmt.Sprintf("%s can't be patched, upload to /lessons/:id/%s instead", field, strings.TrimSuffix(field, "Url")))
+	case "deckId", "position":
+		return lessonInputError(field + " can't be patched, use /lessons/:id/move instead")
+	default:
+		return lessonInputError(field + " can't be patched")
+	}
+	return nil
+}
+
+// PutLessonAudioHandler replaces the audio of a lesson with the uploaded
+// "file". The previous file is kept while a revision refers to it.
+func PutLessonAudioHandler(c *gin.Context) { putLessonMedia(c, "audio") }
+
+// DeleteLessonAudioHandler removes the audio from a lesson.
+func DeleteLessonAudioHandler(c *gin.Context) { deleteLessonMedia(c, "audio") }
+
+// PutLessonPDFHandler replaces the PDF of a lesson with the uploaded "file".
+func PutLessonPDFHandler(c *gin.Context) { putLessonMedia(c, "pdf") }
+
+// DeleteLessonPDFHandler removes the PDF from a lesson.
+func DeleteLessonPDFHandler(c *gin.Context) { deleteLessonMedia(c, "pdf") }
+
+func setLessonMedia(lesson *models.Lesson, kind, url string) {
+	if kind == "audio" {
+		lesson.AudioURL = url
+	} else {
+		lesson.PDFURL = url
+	}
+}
+
+func putLessonMedia(c *gin.Context, kind string) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	// Checked before the upload is stored, and again with the lesson locked
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", id, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	if !lessonMatches(c, lesson) {
+		writeStaleLesson(c, lesson)
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		badBody(c, err, "File is required")
+		return
+	}
+	filename := fmt.Sprintf("%s_%s%s", uuid.New().String(), kind, filepath.Ext(file.Filename))
+	url, err := saveUpload(c, userID, file, filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	if !editLesson(c, func(tx *gorm.DB, lesson *models.Lesson) error {
+		setLessonMedia(lesson, kind, url)
+		return nil
+	}) {
+		removeMedia([]string{url})
+	}
+}
+
+func deleteLessonMedia(c *gin.Context, kind string) {
+	editLesson(c, func(tx *gorm.DB, lesson *models.Lesson) error {
+		setLessonMedia(lesson, kind, "")
+		return nil
+	})
+}
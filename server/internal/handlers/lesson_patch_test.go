@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPatchLessonField(t *testing.T) {
+	original := models.Lesson{
+		Title:               "Greetings",
+		Description:         "Saying hello",
+		MarkdownContent:     "# Hola",
+		Tags:                []string{"es"},
+		SchedulingAlgorithm: "fsrs",
+	}
+	apply := func(patch string) (models.Lesson, error) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(patch), &fields); err != nil {
+			t.Fatal(err)
+		}
+		lesson := original
+		for field, value := range fields {
+			if err := patchLessonField(nil, "user-1", &lesson, field, value); err != nil {
+				return lesson, err
+			}
+		}
+		return lesson, nil
+	}
+
+	lesson, err := apply(`{"title":"  Farewells ","description":null,"tags":["a"," b ","a",""]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lesson.Title != "Farewells" || lesson.Description != "" || !slices.Equal(lesson.Tags, []string{"a", "b"}) {
+		t.Errorf("got title %q, description %q, tags %q", lesson.Title, lesson.Description, lesson.Tags)
+	}
+	// Fields left out of the patch are kept
+	if lesson.MarkdownContent != original.MarkdownContent || lesson.SchedulingAlgorithm != original.SchedulingAlgorithm {
+		t.Errorf("untouched fields changed: markdown %q, algorithm %q", lesson.MarkdownContent, lesson.SchedulingAlgorithm)
+	}
+
+	// null resets to the default
+	if lesson, err := apply(`{"tags":null,"schedulingAlgorithm":null}`); err != nil || len(lesson.Tags) != 0 || lesson.SchedulingAlgorithm != "" {
+		t.Errorf("got tags %q, algorithm %q, error %v", lesson.Tags, lesson.SchedulingAlgorithm, err)
+	}
+
+	for _, patch := range []string{
+		`{"title":null}`,
+		`{"title":"   "}`,
+		`{"description":5}`,
+		`{"tags":"a,b"}`,
+		`{"schedulingAlgorithm":"leitner"}`,
+		`{"audioUrl":"/uploads/x_audio.mp3"}`,
+		`{"deckId":"d"}`,
+		`{"id":"other"}`,
+	} {
+		var inputErr lessonInputError
+		if _, err := apply(patch); !errors.As(err, &inputErr) {
+			t.Errorf("%s: got error %v, want a lessonInputError", patch, err)
+		}
+	}
+}
+
+func TestLessonMatches(t *testing.T) {
+	lesson := models.Lesson{LastUpdated: 1700000000000}
+	tests := []struct {
+		ifMatch string
+		matches bool
+	}{
+		{"", true},
+		{`"1700000000000"`, true},
+		{`W/"1700000000000"`, true},
+		{`"1", "1700000000000"`, true},
+		{"*", true},
+		{`"1699999999999"`, false},
+		{"1700000000000", false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPatch, "/lessons/1", nil)
+		if tt.ifMatch != "" {
+			c.Request.Header.Set("If-Match", tt.ifMatch)
+		}
+		if got := lessonMatches(c, lesson); got != tt.matches {
+			t.Errorf("If-Match %s: got %v, want %v", tt.ifMatch, got, tt.matches)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	writeStaleLesson(c, lesson)
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != `"1700000000000"` {
+		t.Errorf("stale lesson got %d with ETag %s, want 412 with the current ETag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestPatchLessonHandlerRejectsBadBodies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"text/plain", `{"title":"a"}`, http.StatusUnsupportedMediaType},
+		{"application/merge-patch+json", `["title"]`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"title":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPatch, "/lessons/1", strings.NewReader(tt.body))
+		c.Request.Header.Set("Content-Type", tt.contentType)
+		PatchLessonHandler(c)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.contentType, tt.body, w.Code, tt.status)
+		}
+	}
+}
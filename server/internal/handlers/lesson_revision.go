@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/textdiff"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// Revisions kept per lesson. Older ones are pruned, and with them the
+	// media files nothing else references.
+	maxLessonRevisions = 100
+	// Unchanged lines shown around each change of a diff
+	revisionDiffContext = 3
+)
+
+// What a revision was recorded for
+const (
+	revisionOriginal = "original" // the content before the first recorded edit
+	revisionEdit     = "edit"
+	revisionSync     = "sync"
+	revisionTags     = "tags" // tag rename, merge or delete
+	revisionRestore  = "restore"
+)
+
+func lessonRevisionOf(lesson models.Lesson) models.LessonRevision {
+	tags := lesson.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return models.LessonRevision{
+		LessonID:        lesson.ID,
+		UserID:          lesson.UserID,
+		Title:           lesson.Title,
+		Description:     lesson.Description,
+		MarkdownContent: lesson.MarkdownContent,
+		Tags:            tags,
+		AudioURL:        lesson.AudioURL,
+		PDFURL:          lesson.PDFURL,
+	}
+}
+
+func sameLessonContent(a, b models.LessonRevision) bool {
+	return a.Title == b.Title && a.Description == b.Description && a.MarkdownContent == b.MarkdownContent &&
+		slices.Equal(a.Tags, b.Tags) && a.AudioURL == b.AudioURL && a.PDFURL == b.PDFURL
+}
+
+// recordLessonRevision stores the content of a lesson after an edit as a
+// new revision. A lesson without revisions first gets one of its content
+// before the edit, so every edit can be diffed and undone. Edits that leave
+// the content as it was, e.g. filing the lesson in a deck, record nothing.
+func recordLessonRevision(tx *gorm.DB, before, after models.Lesson, source string) error {
+	next := lessonRevisionOf(after)
+	var latest models.LessonRevision
+	if err := tx.Where("lesson_id = ?", after.ID).Order("number DESC").Limit(1).Find(&latest).Error; err != nil {
+		return err
+	}
+	if latest.ID == "" {
+		latest = lessonRevisionOf(before)
+		if sameLessonContent(latest, next) {
+			return nil
+		}
+		latest.ID = uuid.New().String()
+		latest.Number = 1
+		latest.Source = revisionOriginal
+		latest.CreatedAt = max(before.LastUpdated, before.CreatedAt)
+		if err := tx.Create(&latest).Error; err != nil {
+			return err
+		}
+	} else if sameLessonContent(latest, next) {
+		return nil
+	}
+
+	next.ID = uuid.New().String()
+	next.Number = latest.Number + 1
+	next.Source = source
+	next.CreatedAt = after.LastUpdated
+	return tx.Create(&next).Error
+}
+
+// pruneLessonRevisions deletes the revisions of a lesson beyond the newest
+// maxLessonRevisions, then the audio and PDF files only they referenced.
+// It runs after the edit is committed, so a rolled back edit can't lose
+// media.
+func pruneLessonRevisions(lessonID string) {
+	var pruned []models.LessonRevision
+	if err := db.DB.Select("id, audio_url, pdf_url").Where("lesson_id = ?", lessonID).
+		Order("number DESC").Offset(maxLessonRevisions).Find(&pruned).Error; err != nil || len(pruned) == 0 {
+		return
+	}
+	if err := db.DB.Where("id IN ?", recordIDs(pruned, func(r models.LessonRevision) string { return r.ID })).
+		Delete(&models.LessonRevision{}).Error; err != nil {
+		log.Printf("Failed to prune revisions of lesson %s: %v", lessonID, err)
+		return
+	}
+	released := make([]string, 0)
+	for _, revision := range pruned {
+		for _, url := range []string{revision.AudioURL, revision.PDFURL} {
+			if url != "" && !slices.Contains(released, url) {
+				released = append(released, url)
+			}
+		}
+	}
+	unused := make([]string, 0, len(released))
+	for _, url := range released {
+		if !mediaInUse(url) {
+			unused = append(unused, url)
+		}
+	}
+	removeMedia(unused)
+}
+
+// mediaInUse reports whether any lesson, revision, note or card references
+// an uploaded file, assuming it does if that can't be checked.
+func mediaInUse(url string) bool {
+	pattern := "%" + escapeLike(url) + "%"
+	var inUse bool
+	err := db.DB.Raw(`
+		SELECT EXISTS (SELECT 1 FROM lessons WHERE audio_url = ? OR pdf_url = ? OR markdown_content LIKE ?)
+			OR EXISTS (SELECT 1 FROM lesson_revisions WHERE audio_url = ? OR pdf_url = ? OR markdown_content LIKE ?)
+			OR EXISTS (SELECT 1 FROM notes WHERE fields LIKE ?)
+			OR EXISTS (SELECT 1 FROM flashcards WHERE front LIKE ? OR back LIKE ?)
+	`, url, url, pattern, url, url, pattern, pattern, pattern, pattern).Scan(&inUse).Error
+	return err != nil || inUse
+}
+
+// fieldChange is the old and new value of a changed field.
+type fieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// revisionDiff is how a revision differs from the one before it.
+type revisionDiff struct {
+	Title           *fieldChange    `json:"title,omitempty"`
+	Description     []textdiff.Line `json:"description,omitempty"`
+	MarkdownContent []textdiff.Line `json:"markdownContent,omitempty"`
+	TagsAdded       []string        `json:"tagsAdded,omitempty"`
+	TagsRemoved     []string        `json:"tagsRemoved,omitempty"`
+	AudioURL        *fieldChange    `json:"audioUrl,omitempty"`
+	PDFURL          *fieldChange    `json:"pdfUrl,omitempty"`
+}
+
+func diffLessonRevisions(from, to models.LessonRevision) revisionDiff {
+	var diff revisionDiff
+	change := func(a, b string) *fieldChange {
+		if a == b {
+			return nil
+		}
+		return &fieldChange{From: a, To: b}
+	}
+	diff.Title = change(from.Title, to.Title)
+	diff.AudioURL = change(from.AudioURL, to.AudioURL)
+	diff.PDFURL = change(from.PDFURL, to.PDFURL)
+	if from.Description != to.Description {
+		diff.Description = textdiff.Context(textdiff.Lines(from.Description, to.Description), revisionDiffContext)
+	}
+	if from.MarkdownContent != to.MarkdownContent {
+		diff.MarkdownContent = textdiff.Context(textdiff.Lines(from.MarkdownContent, to.MarkdownContent), revisionDiffContext)
+	}
+	for _, tag := range to.Tags {
+		if !slices.Contains(from.Tags, tag) {
+			diff.TagsAdded = append(diff.TagsAdded, tag)
+		}
+	}
+	for _, tag := range from.Tags {
+		if !slices.Contains(to.Tags, tag) {
+			diff.TagsRemoved = append(diff.TagsRemoved, tag)
+		}
+	}
+	return diff
+}
+
+// changes names the fields the diff touches.
+func (d revisionDiff) changes() []string {
+	changes := make([]string, 0)
+	for _, field := range []struct {
+		name    string
+		changed bool
+	}{
+		{"title", d.Title != nil},
+		{"description", d.Description != nil},
+		{"markdownContent", d.MarkdownContent != nil},
+		{"tags", d.TagsAdded != nil || d.TagsRemoved != nil},
+		{"audioUrl", d.AudioURL != nil},
+		{"pdfUrl", d.PDFURL != nil},
+	} {
+		if field.changed {
+			changes = append(changes, field.name)
+		}
+	}
+	return changes
+}
+
+type lessonRevisionSummary struct {
+	ID        string   `json:"id"`
+	Number    int      `json:"number"`
+	Source    string   `json:"source"`
+	CreatedAt int64    `json:"createdAt"`
+	Title     string   `json:"title"`
+	Changes   []string `json:"changes"`       // fields changed since the previous revision
+	Inserted  int      `json:"linesInserted"` // markdown lines
+	Deleted   int      `json:"linesDeleted"`
+}
+
+// GetLessonRevisionsHandler lists the revisions of a lesson, newest first,
+// with the fields each one changed.
+func GetLessonRevisionsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	var count int64
+	db.DB.Model(&models.Lesson{}).Where("id = ? AND user_id = ?", id, userID).Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+
+	var revisions []models.LessonRevision
+	if err := db.DB.Where("lesson_id = ? AND user_id = ?", id, userID).Order("number ASC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+	summaries := make([]lessonRevisionSummary, len(revisions))
+	for i, revision := range revisions {
+		summary := lessonRevisionSummary{
+			ID:        revision.ID,
+			Number:    revision.Number,
+			Source:    revision.Source,
+			CreatedAt: revision.CreatedAt,
+			Title:     revision.Title,
+			Changes:   []string{},
+		}
+		if i > 0 {
+			diff := diffLessonRevisions(revisions[i-1], revision)
+			summary.Changes = diff.changes()
+			summary.Inserted, summary.Deleted = textdiff.Stats(diff.MarkdownContent)
+		}
+		summaries[len(revisions)-1-i] = summary
+	}
+	c.JSON(http.StatusOK, summaries)
+}
+
+// GetLessonRevisionHandler returns a revision with its diff against the
+// previous one (none for the oldest kept revision).
+func GetLessonRevisionHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var revision models.LessonRevision
+	if err := db.DB.First(&revision, "id = ? AND lesson_id = ? AND user_id = ?", c.Param("revisionId"), c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	var previous models.LessonRevision
+	if err := db.DB.Where("lesson_id = ? AND number < ?", revision.LessonID, revision.Number).
+		Order("number DESC").Limit(1).Find(&previous).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+		return
+	}
+
+	response := gin.H{"revision": revision}
+	if previous.ID != "" {
+		diff := diffLessonRevisions(previous, revision)
+		response["previousId"] = previous.ID
+		response["changes"] = diff.changes()
+		response["diff"] = diff
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreLessonRevisionHandler puts the content of a revision back into
+// its lesson. The restore is itself recorded as a revision, so it can be
+// undone too. Media of the revision that is no longer on disk is left as is.
+// With If-Match set to the lesson's ETag, the restore is rejected with 412 if
+// the lesson was changed in the meantime.
+func RestoreLessonRevisionHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	var revision models.LessonRevision
+	if err := db.DB.First(&revision, "id = ? AND lesson_id = ?", c.Param("revisionId"), lesson.ID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+
+	editLessonAs(c, revisionRestore, func(tx *gorm.DB, lesson *models.Lesson) error {
+		lesson.Title = revision.Title
+		lesson.Description = revision.Description
+		lesson.MarkdownContent = revision.MarkdownContent
+		lesson.Tags = revision.Tags
+		if isUploadedMediaURL(revision.AudioURL) {
+			lesson.AudioURL = revision.AudioURL
+		}
+		if isUploadedMediaURL(revision.PDFURL) {
+			lesson.PDFURL = revision.PDFURL
+		}
+		return nil
+	})
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// Listings are paginated with a cursor, the sort value and ID of the last
+// item of a page, returned in this header. It is absent on the last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+// sortColumn is a column listings can be sorted by.
+type sortColumn struct {
+	column  string
+	numeric bool // int64 values, strings otherwise
+}
+
+var lessonSorts = map[string]sortColumn{
+	"createdAt":   {"lessons.created_at", true},
+	"lastUpdated": {"lessons.last_updated", true},
+	"title":       {"lessons.title", false},
+	"position":    {"lessons.position", true},
+}
+
+var cardSorts = map[string]sortColumn{
+	"nextReview":  {"flashcards.next_review", true},
+	"lastReview":  {"flashcards.last_review", true},
+	"lastUpdated": {"flashcards.last_updated", true},
+	"interval":    {"flashcards.interval", true},
+	"lapses":      {"flashcards.lapses", true},
+	"front":       {"flashcards.front", false},
+}
+
+type pageCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// page is the sort order and position of a listing. Items with equal sort
+// values are ordered by ID, so the order is total and a cursor never skips
+// or repeats an item.
+type page struct {
+	name   string // key of the sort column
+	sort   sortColumn
+	table  string
+	desc   bool
+	limit  int // 0 for no limit
+	cursor *pageCursor
+}
+
+// parsePage reads the sort, limit and cursor query parameters. sort is a
+// key of sorts, prefixed with "-" for descending order. Without a limit
+// or cursor, limit is used, 0 meaning everything.
+func parsePage(c *gin.Context, table string, sorts map[string]sortColumn, defaultSort string, limit int) (page, error) {
+	p := page{table: table, limit: limit}
+	name := c.DefaultQuery("sort", defaultSort)
+	name, p.desc = strings.CutPrefix(name, "-")
+	sort, ok := sorts[name]
+	if !ok {
+		names := make([]string, 0, len(sorts))
+		for name := range sorts {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return p, fmt.Errorf("sort must be one of %s, prefixed with '-' for descending order", strings.Join(names, ", "))
+	}
+	p.name, p.sort = name, sort
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, errors.New("limit must be a positive integer")
+		}
+		p.limit = min(n, maxPageLimit)
+	}
+	if v := c.Query("cursor"); v != "" {
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		var cursor pageCursor
+		if err == nil {
+			err = json.Unmarshal(data, &cursor)
+		}
+		if err == nil && sort.numeric {
+			_, err = strconv.ParseInt(cursor.Value, 10, 64)
+		}
+		if err == nil {
+			_, err = uuid.Parse(cursor.ID)
+		}
+		if err != nil {
+			return p, errors.New("invalid cursor")
+		}
+		p.cursor = &cursor
+		if p.limit == 0 {
+			p.limit = defaultPageLimit
+		}
+	}
+	return p, nil
+}
+
+// apply orders q and restricts it to the items after the cursor. One item
+// more than the limit is fetched to tell whether there is a next page.
+func (p page) apply(q *gorm.DB) *gorm.DB {
+	direction, compare := "ASC", ">"
+	if p.desc {
+		direction, compare = "DESC", "<"
+	}
+	id := p.table + ".id"
+	if p.cursor != nil {
+		var value any = p.cursor.Value
+		if p.sort.numeric {
+			value, _ = strconv.ParseInt(p.cursor.Value, 10, 64)
+		}
+		q = q.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", p.sort.column, compare, p.sort.column, id, compare),
+			value, value, p.cursor.ID)
+	}
+	q = q.Order(fmt.Sprintf("%s %s, %s %s", p.sort.column, direction, id, direction))
+	if p.limit > 0 {
+		q = q.Limit(p.limit + 1)
+	}
+	return q
+}
+
+// paginate trims items fetched with p.apply to the page and sets the cursor
+// of the next page. key returns an item's sort value and ID.
+func paginate[T any](c *gin.Context, p page, items []T, key func(T) (any, string)) []T {
+	if p.limit == 0 || len(items) <= p.limit {
+		return items
+	}
+	items = items[:p.limit]
+	value, id := key(items[len(items)-1])
+	data, _ := json.Marshal(pageCursor{Value: fmt.Sprint(value), ID: id})
+	c.Header(nextCursorHeader, base64.RawURLEncoding.EncodeToString(data))
+	return items
+}
+
+// lessonListItem is a lesson in a listing, with its cards or their counts
+// depending on the cards parameter.
+type lessonListItem struct {
+	models.Lesson
+	Flashcards *[]models.Flashcard `json:"flashcards,omitempty"`
+	CardCount  *int64              `json:"cardCount,omitempty"`
+	DueCount   *int64              `json:"dueCount,omitempty"`
+	NewCount   *int64              `json:"newCount,omitempty"`
+}
+
+// GetLessonsHandler lists the user's lessons.
+//
+// Query parameters:
+//   - sort: "createdAt" (default), "lastUpdated", "title" or "position" (in
+//     the deck), "-" prefixed for descending order
+//   - limit, cursor: page size (up to 200) and the X-Next-Cursor of the
+//     previous page; everything is returned if neither is given
+//   - deckId: lessons filed directly in this deck, "none" for those in none
+//   - tag: lessons with this tag, repeat for lessons with all of them
+//   - createdAfter, createdBefore: unix millis, from inclusive, to exclusive
+//   - hasAudio: "true" or "false"
+//   - hasDueCards: "true" or "false", whether cards are due for review now
+//   - cards: "all" (default) to include live cards, "none" to leave them
+//     out, "count" for cardCount, dueCount and newCount instead
+func GetLessonsHandler(c *gin.Context) {
+	listLessons(c, false)
+}
+
+// GetDeletedLessonsHandler lists the lessons in the trash, with the
+// parameters of GetLessonsHandler.
+func GetDeletedLessonsHandler(c *gin.Context) {
+	listLessons(c, true)
+}
+
+func listLessons(c *gin.Context, deleted bool) {
+	userID := getUserID(c)
+	now := time.Now().UnixMilli()
+	p, err := parsePage(c, "lessons", lessonSorts, "createdAt", 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cards := c.DefaultQuery("cards", "all")
+	if cards != "all" && cards != "none" && cards != "count" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cards must be 'all', 'none' or 'count'"})
+		return
+	}
+
+	q := db.DB.Model(&models.Lesson{}).Where("lessons.user_id = ?", userID)
+	if deleted {
+		q = q.Where("lessons.deleted_at > 0")
+	} else {
+		q = q.Where("lessons.deleted_at = 0")
+	}
+	switch deckID := c.Query("deckId"); deckID {
+	case "":
+	case "none":
+		q = q.Where("lessons.deck_id = ''")
+	default:
+		q = q.Where("lessons.deck_id = ?", deckID)
+	}
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		tagJSON, _ := json.Marshal(tags)
+		q = q.Where("CAST(lessons.tags AS jsonb) @> ?", string(tagJSON))
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"createdAfter", "lessons.created_at >= ?"},
+		{"createdBefore", "lessons.created_at < ?"},
+	} {
+		if v := c.Query(bound.param); v != "" {
+			ms, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": bound.param + " must be unix milliseconds"})
+				return
+			}
+			q = q.Where(bound.condition, ms)
+		}
+	}
+	if v := c.Query("hasAudio"); v != "" {
+		hasAudio, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hasAudio must be 'true' or 'false'"})
+			return
+		}
+		if hasAudio {
+			q = q.Where("lessons.audio_url <> ''")
+		} else {
+			q = q.Where("lessons.audio_url = ''")
+		}
+	}
+	if v := c.Query("hasDueCards"); v != "" {
+		hasDue, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hasDueCards must be 'true' or 'false'"})
+			return
+		}
+		due := filterCardStatus(db.DB.Model(&models.Flashcard{}).Select("1").
+			Where("flashcards.lesson_id = lessons.id AND flashcards.deleted_at = 0"), searchDue, now)
+		if hasDue {
+			q = q.Where("EXISTS (?)", due)
+		} else {
+			q = q.Where("NOT EXISTS (?)", due)
+		}
+	}
+	if cards == "all" {
+		q = q.Preload("Flashcards", "deleted_at = 0")
+	}
+
+	var lessons []models.Lesson
+	if err := p.apply(q).Find(&lessons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lessons"})
+		return
+	}
+	lessons = paginate(c, p, lessons, func(l models.Lesson) (any, string) {
+		switch p.name {
+		case "lastUpdated":
+			return l.LastUpdated, l.ID
+		case "title":
+			return l.Title, l.ID
+		case "position":
+			return l.Position, l.ID
+		}
+		return l.CreatedAt, l.ID
+	})
+
+	items := make([]lessonListItem, len(lessons))
+	ids := make([]string, len(lessons))
+	for i, lesson := range lessons {
+		items[i] = lessonListItem{Lesson: lesson}
+		if cards == "all" {
+			flashcards := lesson.Flashcards
+			if flashcards == nil {
+				flashcards = []models.Flashcard{}
+			}
+			items[i].Flashcards = &flashcards
+		}
+		ids[i] = lesson.ID
+	}
+	if cards == "count" && len(ids) > 0 {
+		var counts []struct {
+			LessonID  string
+			CardCount int64
+			DueCount  int64
+			NewCount  int64
+		}
+		if err := db.DB.Model(&models.Flashcard{}).
+			Select("lesson_id, COUNT(*) AS card_count, "+
+				"COUNT(*) FILTER (WHERE "+dueCardCondition+") AS due_count, "+
+				"COUNT(*) FILTER (WHERE "+newCardCondition+") AS new_count", now, now).
+			Where("lesson_id IN ? AND deleted_at = 0", ids).
+			Group("lesson_id").
+			Scan(&counts).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count cards"})
+			return
+		}
+		byLesson := make(map[string]int, len(counts))
+		for i, count := range counts {
+			byLesson[count.LessonID] = i
+		}
+		for i := range items {
+			var total, due, fresh int64
+			if j, ok := byLesson[items[i].ID]; ok {
+				total, due, fresh = counts[j].CardCount, counts[j].DueCount, counts[j].NewCount
+			}
+			items[i].CardCount, items[i].DueCount, items[i].NewCount = &total, &due, &fresh
+		}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// GetLessonCardsHandler lists the cards of a lesson, a page at a time.
+//
+// Query parameters:
+//   - sort: "nextReview" (default), "lastReview", "lastUpdated", "interval",
+//     "lapses" or "front", "-" prefixed for descending order
+//   - limit, cursor: page size (50 by default, up to 200) and the
+//     X-Next-Cursor of the previous page
+//   - status: cards that are "due", "new", "scheduled" or "suspended"
+//   - tag: cards with this tag, repeat for cards with all of them
+//   - deleted: "true" for deleted cards only, "all" for both; live by default
+func GetLessonCardsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	var lesson models.Lesson
+	if err := db.DB.Select("id").First(&lesson, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+	p, err := parsePage(c, "flashcards", cardSorts, "nextReview", defaultPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && !slices.Contains([]string{searchDue, searchNew, searchScheduled, searchSuspended}, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be 'due', 'new', 'scheduled' or 'suspended'"})
+		return
+	}
+
+	q := db.DB.Model(&models.Flashcard{}).Where("flashcards.lesson_id = ?", id)
+	switch c.DefaultQuery("deleted", "false") {
+	case "false":
+		q = q.Where("flashcards.deleted_at = 0")
+	case "true":
+		q = q.Where("flashcards.deleted_at > 0")
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deleted must be 'false', 'true' or 'all'"})
+		return
+	}
+	q = filterCardStatus(q, status, time.Now().UnixMilli())
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		tagJSON, _ := json.Marshal(tags)
+		q = q.Where("CAST(flashcards.tags AS jsonb) @> ?", string(tagJSON))
+	}
+
+	var cards []models.Flashcard
+	if err := p.apply(q).Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cards"})
+		return
+	}
+	cards = paginate(c, p, cards, func(card models.Flashcard) (any, string) {
+		switch p.name {
+		case "lastReview":
+			return card.LastReview, card.ID
+		case "lastUpdated":
+			return card.LastUpdated, card.ID
+		case "interval":
+			return card.Interval, card.ID
+		case "lapses":
+			return card.Lapses, card.ID
+		case "front":
+			return card.Front, card.ID
+		}
+		return card.NextReview, card.ID
+	})
+	if cards == nil {
+		cards = []models.Flashcard{}
+	}
+	c.JSON(http.StatusOK, cards)
+}
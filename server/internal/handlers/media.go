@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const uploadsDir = "uploads"
+
+// uploadName matches the names uploads are stored under: the ID of the file
+// or its lesson, the kind of media, for lesson media the upload time, and
+// the extension.
+var uploadName = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}_(audio|pdf|image)(_[0-9]+)?(\.[A-Za-z0-9]+)?$`)
+
+// UploadMediaHandler stores a standalone media file and returns its URL.
+// Offline clients upload media once they are back online and then attach it
+// to a lesson by reference through the sync protocol (modifiedLessons).
+func UploadMediaHandler(c *gin.Context) {
+	userID := getUserID(c)
+	kind := c.PostForm("kind")
+	if kind != "audio" && kind != "pdf" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be 'audio' or 'pdf'"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		badBody(c, err, "File is required")
+		return
+	}
+
+	ext := filepath.Ext(file.Filename)
+	filename := fmt.Sprintf("%s_%s%s", uuid.New().String(), kind, ext)
+	url, err := saveUpload(c, userID, file, filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"url": url})
+}
+
+// saveUpload stores an uploaded file for userID under filename in the
+// uploads directory and returns its URL.
+func saveUpload(c *gin.Context, userID string, file *multipart.FileHeader, filename string) (string, error) {
+	savePath := filepath.Join(uploadsDir, filename)
+	os.MkdirAll(uploadsDir, os.ModePerm)
+	if err := c.SaveUploadedFile(file, savePath); err != nil {
+		return "", err
+	}
+	url := "/uploads/" + filename
+	if err := recordUpload(db.DB, userID, url); err != nil {
+		os.Remove(savePath)
+		return "", err
+	}
+	return url, nil
+}
+
+// recordUpload records that the uploaded file at url was stored for userID.
+func recordUpload(tx *gorm.DB, userID, url string) error {
+	name := strings.TrimPrefix(url, "/uploads/")
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Upload{Name: name, UserID: userID, CreatedAt: time.Now().UnixMilli()}).Error
+}
+
+// ownsMediaURL reports whether url references an uploaded file that was
+// stored for userID. Empty URLs are valid and mean "no media".
+func ownsMediaURL(userID, url string) bool {
+	if url == "" {
+		return true
+	}
+	if !isUploadedMediaURL(url) {
+		return false
+	}
+	var count int64
+	db.DB.Model(&models.Upload{}).Where("name = ? AND user_id = ?", strings.TrimPrefix(url, "/uploads/"), userID).Count(&count)
+	return count > 0
+}
+
+// isUploadedMediaURL reports whether url references a file that exists in the
+// uploads directory. Empty URLs are valid and mean "no media".
+func isUploadedMediaURL(url string) bool {
+	if url == "" {
+		return true
+	}
+	name, ok := strings.CutPrefix(url, "/uploads/")
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(uploadsDir, name))
+	return err == nil && !info.IsDir()
+}
+
+// saveMedia stores data in the uploads directory for userID the way
+// UploadMediaHandler does and returns its URL.
+func saveMedia(userID, kind, ext string, data []byte) (string, error) {
+	filename := fmt.Sprintf("%s_%s%s", uuid.New().String(), kind, ext)
+	if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	path := filepath.Join(uploadsDir, filename)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := recordUpload(db.DB, userID, "/uploads/"+filename); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return "/uploads/" + filename, nil
+}
+
+// removeMedia deletes uploaded files, and the record of who they were stored
+// for, once nothing refers to them, e.g. when the change they were saved for
+// was rolled back.
+func removeMedia(urls []string) {
+	for _, url := range urls {
+		name, ok := strings.CutPrefix(url, "/uploads/")
+		if !ok || !isUploadedMediaURL(url) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(uploadsDir, name)); err != nil {
+			log.Printf("Failed to remove media %s: %v", url, err)
+			continue
+		}
+		db.DB.Where("name = ?", name).Delete(&models.Upload{})
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/notes"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func GetNoteTypesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, notes.Types)
+}
+
+// CreateNoteHandler creates a note in a lesson along with one card per
+// template of its type.
+func CreateNoteHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		ID       string            `json:"id"`
+		LessonID string            `json:"lessonId" binding:"required"`
+		NoteType string            `json:"noteType"`
+		Fields   map[string]string `json:"fields" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return
+	}
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	} else if _, err := uuid.Parse(req.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a UUID"})
+		return
+	}
+	if req.NoteType == "" {
+		req.NoteType = notes.TypeBasic
+	}
+	noteType, ok := notes.Lookup(req.NoteType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown note type"})
+		return
+	}
+	if !validateNoteFields(c, noteType, req.Fields) {
+		return
+	}
+
+	var lesson models.Lesson
+	if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", req.LessonID, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lesson not found"})
+		return
+	}
+
+	now := time.Now()
+	note := models.Note{
+		ID:          req.ID,
+		UserID:      userID,
+		LessonID:    lesson.ID,
+		NoteType:    noteType.Name,
+		Fields:      req.Fields,
+		CreatedAt:   now.UnixMilli(),
+		LastUpdated: now.UnixMilli(),
+	}
+	if err := createNote(&note, noteType, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+		return
+	}
+	c.JSON(http.StatusCreated, note)
+}
+
+func GetNoteHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var note models.Note
+	if err := db.DB.Preload("Flashcards", "deleted_at = 0").
+		First(&note, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
+
+// UpdateNoteHandler replaces the fields of a note and re-renders its cards.
+// Cards keep their schedule; templates that now render nothing lose their
+// card and those that render something again get a new one.
+func UpdateNoteHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req struct {
+		Fields map[string]string `json:"fields" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return
+	}
+
+	var note models.Note
+	if err := db.DB.First(&note, "id = ? AND user_id = ? AND deleted_at = 0", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	noteType, ok := notes.Lookup(note.NoteType)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Note has an unknown type"})
+		return
+	}
+	if !validateNoteFields(c, noteType, req.Fields) {
+		return
+	}
+
+	now := time.Now()
+	note.Fields = req.Fields
+	note.LastUpdated = now.UnixMilli()
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Flashcards").Save(&note).Error; err != nil {
+			return err
+		}
+		return renderNoteCards(tx, &note, noteType, now)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
+		return
+	}
+	c.JSON(http.StatusOK, note)
+}
+
+// DeleteNoteHandler deletes a note together with all of its cards.
+func DeleteNoteHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	now := time.Now().UnixMilli()
+
+	var deleted int64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Note{}).
+			Where("id = ? AND user_id = ? AND deleted_at = 0", id, userID).
+			Updates(map[string]interface{}{"deleted_at": now, "last_updated": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Model(&models.Flashcard{}).
+			Where("note_id = ? AND deleted_at = 0", id).
+			Updates(map[string]interface{}{"deleted_at": now, "last_updated": now}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Note deleted"})
+}
+
+func validateNoteFields(c *gin.Context, noteType notes.Type, fields map[string]string) bool {
+	switch err := noteType.Validate(fields); {
+	case errors.Is(err, notes.ErrUnknownField):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown field for this note type", "fields": noteType.Fields})
+		return false
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The note would produce no cards"})
+		return false
+	}
+	return true
+}
+
+// createNote stores a new note and the cards it renders to.
+func createNote(note *models.Note, noteType notes.Type, now time.Time) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Flashcards").Create(note).Error; err != nil {
+			return err
+		}
+		return renderNoteCards(tx, note, noteType, now)
+	})
+}
+
+// renderNoteCards brings the live cards of note in line with what its
+// templates render, and loads them into note.Flashcards. Templates whose card
+// the user deleted stay without one. A card removed here because its
+// template stopped rendering comes back, schedule and all, once it renders
+// again.
+func renderNoteCards(tx *gorm.DB, note *models.Note, noteType notes.Type, now time.Time) error {
+	var existing []models.Flashcard
+	if err := tx.Where("note_id = ?", note.ID).Order("deleted_at DESC").Find(&existing).Error; err != nil {
+		return err
+	}
+	// Live cards win over tombstones of the same template, then the latest tombstone
+	byOrdinal := make(map[int]models.Flashcard, len(existing))
+	for _, card := range existing {
+		if current, ok := byOrdinal[card.TemplateOrdinal]; !ok || current.DeletedAt > 0 && card.DeletedAt == 0 {
+			byOrdinal[card.TemplateOrdinal] = card
+		}
+	}
+
+	note.Flashcards = make([]models.Flashcard, 0, len(noteType.Templates))
+	for _, rendered := range noteType.Render(note.Fields) {
+		card, ok := byOrdinal[rendered.Ordinal]
+		delete(byOrdinal, rendered.Ordinal)
+		if slices.Contains(note.ExcludedOrdinals, rendered.Ordinal) {
+			continue
+		}
+		if ok && card.DeletedAt > 0 {
+			card.DeletedAt = 0
+		} else if !ok {
+			card = models.Flashcard{
+				ID:              uuid.New().String(),
+				LessonID:        note.LessonID,
+				NoteID:          note.ID,
+				TemplateOrdinal: rendered.Ordinal,
+				IsUserCreated:   true,
+			}
+			applySRSState(&card, srs.InitialState(now))
+		} else if card.Front == rendered.Front && card.Back == rendered.Back {
+			note.Flashcards = append(note.Flashcards, card)
+			continue
+		}
+		card.Front = rendered.Front
+		card.Back = rendered.Back
+		card.LastUpdated = now.UnixMilli()
+		if err := tx.Save(&card).Error; err != nil {
+			return err
+		}
+		note.Flashcards = append(note.Flashcards, card)
+	}
+
+	// Templates that no longer produce a card
+	for _, card := range byOrdinal {
+		if card.DeletedAt > 0 {
+			continue
+		}
+		if err := tx.Model(&card).Updates(map[string]interface{}{"deleted_at": now.UnixMilli(), "last_updated": now.UnixMilli()}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// basicNoteForCard is the note of a card created directly rather than from a
+// note. It shares the card's ID.
+func basicNoteForCard(card *models.Flashcard, userID string) models.Note {
+	card.NoteID = card.ID
+	card.TemplateOrdinal = 0
+	return models.Note{
+		ID:          card.ID,
+		UserID:      userID,
+		LessonID:    card.LessonID,
+		NoteType:    notes.TypeBasic,
+		Fields:      map[string]string{"Front": card.Front, "Back": card.Back},
+		CreatedAt:   card.LastUpdated,
+		LastUpdated: card.LastUpdated,
+	}
+}
+
+// syncBasicNote copies an edit made directly to a card's front and back into
+// its note, when the note is a basic one that only renders that card.
+func syncBasicNote(tx *gorm.DB, card models.Flashcard, now int64) error {
+	if card.NoteID == "" {
+		return nil
+	}
+	return tx.Model(&models.Note{}).
+		Where("id = ? AND note_type = ?", card.NoteID, notes.TypeBasic).
+		Updates(models.Note{Fields: map[string]string{"Front": card.Front, "Back": card.Back}, LastUpdated: now}).Error
+}
+
+// deleteCardNotes updates the notes of the given deleted cards. Basic notes
+// have no other card left and are deleted. Other notes record the template
+// of the card, so editing the note doesn't bring the card back.
+func deleteCardNotes(tx *gorm.DB, userID string, cardIDs []string, now int64) error {
+	if err := tx.Exec(`
+		UPDATE notes SET deleted_at = ?, last_updated = ?
+		WHERE user_id = ? AND note_type = ? AND deleted_at = 0
+			AND id IN (SELECT note_id FROM flashcards WHERE id IN ? AND deleted_at > 0)
+	`, now, now, userID, notes.TypeBasic, cardIDs).Error; err != nil {
+		return err
+	}
+
+	var cards []models.Flashcard
+	if err := tx.Select("flashcards.note_id, flashcards.template_ordinal").
+		Joins("JOIN notes ON notes.id = flashcards.note_id").
+		Where("flashcards.id IN ? AND flashcards.deleted_at > 0 AND notes.user_id = ? AND notes.note_type <> ?", cardIDs, userID, notes.TypeBasic).
+		Find(&cards).Error; err != nil {
+		return err
+	}
+	for _, card := range cards {
+		var note models.Note
+		if err := tx.First(&note, "id = ?", card.NoteID).Error; err != nil {
+			return err
+		}
+		if slices.Contains(note.ExcludedOrdinals, card.TemplateOrdinal) {
+			continue
+		}
+		note.ExcludedOrdinals = append(note.ExcludedOrdinals, card.TemplateOrdinal)
+		if err := tx.Model(&note).Select("excluded_ordinals", "last_updated").
+			Updates(&models.Note{ExcludedOrdinals: note.ExcludedOrdinals, LastUpdated: now}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetCardReviewsHandler returns the grading history of a card, oldest first.
+func GetCardReviewsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+
+	var count int64
+	db.DB.Model(&models.Flashcard{}).
+		Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+		Where("flashcards.id = ? AND lessons.user_id = ?", id, userID).
+		Count(&count)
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+
+	var logs []models.ReviewLog
+	if err := db.DB.Where("card_id = ? AND user_id = ?", id, userID).Order("reviewed_at ASC").Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review history"})
+		return
+	}
+	c.JSON(http.StatusOK, logs)
+}
+
+// appendReviewLog stores a grading event and counts it towards the daily
+// limits of the day it happened. Logs are append-only and keyed by ID, so a
+// log that was already received (e.g. a retried sync) is ignored.
+func appendReviewLog(tx *gorm.DB, entry *models.ReviewLog) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	entry.SyncedAt = time.Now().UnixMilli()
+
+	// A card is new to the user if this is the earliest review we know of and
+	// it had no interval yet (cards reviewed before logs existed have one)
+	var earlier int64
+	if err := tx.Model(&models.ReviewLog{}).
+		Where("card_id = ? AND reviewed_at < ? AND id <> ?", entry.CardID, entry.ReviewedAt, entry.ID).
+		Count(&earlier).Error; err != nil {
+		return err
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(entry)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	newCount, reviewCount := 0, 1
+	if earlier == 0 && entry.PrevInterval == 0 {
+		newCount, reviewCount = 1, 0
+	}
+	day := time.UnixMilli(entry.ReviewedAt).In(userLocation(tx, entry.UserID)).Format(time.DateOnly)
+	return tx.Exec(`
+		INSERT INTO daily_study_counters (user_id, day, new_count, review_count)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, day) DO UPDATE SET
+			new_count = daily_study_counters.new_count + EXCLUDED.new_count,
+			review_count = daily_study_counters.review_count + EXCLUDED.review_count
+	`, entry.UserID, day, newCount, reviewCount).Error
+}
+
+// userLocation is the user's configured timezone, UTC if unset or unknown.
+func userLocation(tx *gorm.DB, userID string) *time.Location {
+	var user models.User
+	if err := tx.Select("timezone").First(&user, "id = ?", userID).Error; err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// newReviewLog describes the transition of card from prev to next.
+func newReviewLog(card models.Flashcard, userID, deviceID string, grade int, prev, next srs.State, reviewedAt int64) models.ReviewLog {
+	return models.ReviewLog{
+		CardID:       card.ID,
+		UserID:       userID,
+		DeviceID:     deviceID,
+		Grade:        grade,
+		PrevInterval: prev.Interval,
+		NextInterval: next.Interval,
+		PrevEFactor:  prev.EFactor,
+		NextEFactor:  next.EFactor,
+		ReviewedAt:   reviewedAt,
+	}
+}
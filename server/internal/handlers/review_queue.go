@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQueueLimit = 50
+	maxQueueLimit     = 500
+)
+
+// QueueCard is a flashcard in the review queue.
+type QueueCard struct {
+	models.Flashcard
+	IsNew bool `json:"isNew"`
+}
+
+// GetReviewQueueHandler returns the next cards to study across all lessons,
+// or those of a deck and its descendants with deckId. Due reviews come
+// most-overdue first (relative to their interval), new cards in lesson
+// order, both capped by what is left of the user's daily limits and
+// interleaved so new cards are spread evenly through the session.
+func GetReviewQueueHandler(c *gin.Context) {
+	userID := getUserID(c)
+	deckIDs, ok := deckScope(c, userID)
+	if !ok {
+		return
+	}
+	limit := defaultQueueLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, maxQueueLimit)
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	localNow := now.In(userLocation(db.DB, userID))
+	today := localNow.Format(time.DateOnly)
+	var counter models.DailyStudyCounter
+	db.DB.Where("user_id = ? AND day = ?", userID, today).Limit(1).Find(&counter)
+
+	newRemaining := max(0, user.NewCardsPerDay-counter.NewCount)
+	reviewRemaining := max(0, user.ReviewsPerDay-counter.ReviewCount)
+
+	var dueTotal int64
+	if err := dueReviewsQuery(userID, now, deckIDs).Count(&dueTotal).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build review queue"})
+		return
+	}
+
+	reviews := make([]models.Flashcard, 0)
+	if reviewRemaining > 0 {
+		// Relative overdueness: days overdue divided by the interval the card was scheduled with
+		err := dueReviewsQuery(userID, now, deckIDs).
+			Order(gorm.Expr("(? - flashcards.next_review) / (CASE WHEN flashcards.interval > 0 THEN flashcards.interval ELSE 1 END) DESC", now.UnixMilli())).
+			Limit(min(limit, reviewRemaining)).
+			Find(&reviews).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build review queue"})
+			return
+		}
+	}
+
+	newCards := make([]models.Flashcard, 0)
+	if newRemaining > 0 {
+		startOfDay := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), 0, 0, 0, 0, localNow.Location())
+		query, err := newCardsQuery(userID, now, startOfDay.UnixMilli(), deckIDs)
+		if err == nil {
+			err = query.Limit(min(limit, newRemaining)).Find(&newCards).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build review queue"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"day":   today,
+		"cards": interleaveQueue(reviews, newCards, limit),
+		"counts": gin.H{
+			"newToday":        counter.NewCount,
+			"reviewsToday":    counter.ReviewCount,
+			"newRemaining":    newRemaining,
+			"reviewRemaining": reviewRemaining,
+			"dueReviews":      dueTotal,
+		},
+	})
+}
+
+// A card that has never been graded
+const newCardCondition = "flashcards.last_review = 0 AND flashcards.repetition = 0 AND flashcards.lapses = 0"
+
+// userCardsQuery selects the live cards of a user's live lessons.
+func userCardsQuery(userID string) *gorm.DB {
+	return db.DB.Model(&models.Flashcard{}).
+		Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+		Where("lessons.user_id = ? AND lessons.deleted_at = 0 AND flashcards.deleted_at = 0", userID)
+}
+
+// queueCardsQuery narrows userCardsQuery to cards that are neither
+// suspended nor buried at now, in the given decks unless deckIDs is nil.
+func queueCardsQuery(userID string, now time.Time, deckIDs []string) *gorm.DB {
+	query := userCardsQuery(userID).
+		Where("NOT flashcards.suspended AND flashcards.buried_until <= ?", now.UnixMilli())
+	if deckIDs != nil {
+		query = query.Where("lessons.deck_id IN ?", deckIDs)
+	}
+	return query
+}
+
+// newCardsQuery selects new cards in lesson order, and in the order they
+// were added within a lesson, leaving out those beyond
+// what is left today of the new-card limit of their lesson's study preset.
+func newCardsQuery(userID string, now time.Time, startOfDay int64, deckIDs []string) (*gorm.DB, error) {
+	var presets []models.StudyPreset
+	if err := db.DB.Where("user_id = ? AND new_cards_per_day >= 0", userID).Find(&presets).Error; err != nil {
+		return nil, err
+	}
+	introduced, err := presetNewCardsIntroduced(userID, startOfDay)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := queueCardsQuery(userID, now, deckIDs).
+		Where(newCardCondition).
+		Select("flashcards.*, lessons.preset_id, lessons.created_at AS lesson_created_at, " +
+			"ROW_NUMBER() OVER (PARTITION BY lessons.preset_id ORDER BY lessons.created_at, flashcards.seq) AS preset_rank")
+	query := db.DB.Table("(?) AS candidates", candidates).Order("lesson_created_at ASC, seq ASC")
+	for _, preset := range presets {
+		remaining := max(0, preset.NewCardsPerDay-introduced[preset.ID])
+		query = query.Where("NOT (preset_id = ? AND preset_rank > ?)", preset.ID, remaining)
+	}
+	return query, nil
+}
+
+func dueReviewsQuery(userID string, now time.Time, deckIDs []string) *gorm.DB {
+	return queueCardsQuery(userID, now, deckIDs).
+		Where("NOT ("+newCardCondition+")").
+		Where("flashcards.next_review <= ?", now.UnixMilli())
+}
+
+// interleaveQueue merges reviews and new cards into at most limit cards. New
+// cards get a share of the session proportional to their availability (and
+// any slots reviews can't fill) and are spaced evenly between reviews.
+func interleaveQueue(reviews, newCards []models.Flashcard, limit int) []QueueCard {
+	newTake := 0
+	if total := len(reviews) + len(newCards); total > 0 {
+		newTake = max(limit-len(reviews), limit*len(newCards)/total)
+		newTake = min(newTake, len(newCards), limit)
+	}
+	reviewTake := min(len(reviews), limit-newTake)
+
+	queue := make([]QueueCard, 0, reviewTake+newTake)
+	r, n := 0, 0
+	for r < reviewTake || n < newTake {
+		// Emit a new card whenever new cards are behind their even share
+		if n < newTake && (r >= reviewTake || n*(reviewTake+newTake) <= (r+n)*newTake) {
+			queue = append(queue, QueueCard{Flashcard: newCards[n], IsNew: true})
+			n++
+		} else {
+			queue = append(queue, QueueCard{Flashcard: reviews[r]})
+			r++
+		}
+	}
+	return queue
+}
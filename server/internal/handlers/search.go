@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/search"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	snippetRunes       = 160
+)
+
+// Due states cards can be filtered by
+const (
+	searchDue       = "due"       // due for review now
+	searchNew       = "new"       // never studied
+	searchScheduled = "scheduled" // studied, due later
+	searchSuspended = "suspended"
+)
+
+type searchResult struct {
+	Type       string   `json:"type"` // "lesson" or "card"
+	ID         string   `json:"id"`
+	LessonID   string   `json:"lessonId"`
+	Title      string   `json:"title,omitempty"`
+	Front      string   `json:"front,omitempty"`
+	Back       string   `json:"back,omitempty"`
+	Tags       []string `json:"tags" gorm:"serializer:json"`
+	NextReview int64    `json:"nextReview,omitempty"`
+	DeletedAt  int64    `json:"deletedAt"`
+	Snippet    string   `json:"snippet"` // HTML escaped, matches in <mark>
+	Rank       float64  `json:"rank"`
+
+	Headline string `json:"-"` // highlighted text from the database to cut the snippet from
+}
+
+type searchFilters struct {
+	userID   string
+	kind     string // "lessons", "cards" or empty for both
+	tag      string
+	lessonID string
+	due      string
+	deleted  string // "false", "true" or "all"
+	config   string
+	limit    int
+	now      time.Time
+}
+
+// SearchHandler searches the user's lessons (title, description and
+// content) and cards (front and back), best matches first.
+//
+// Query parameters:
+//   - q: the search, in web search syntax: "quoted phrases", or, -excluded
+//   - lang: language code used to stem words, e.g. "es"; languages without
+//     stemming, ja, zh and ko among them, and no lang only lower-case words
+//   - type: "lessons" or "cards" to search only those
+//   - tag, lessonId: restrict to a tag or lesson
+//   - due: cards that are "due", "new", "scheduled" or "suspended"
+//   - deleted: "true" for the trash only, "all" for both; live items by default
+//   - limit: number of results, 20 by default
+func SearchHandler(c *gin.Context) {
+	query := search.Parse(c.Query("q"))
+	if query.Empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	filters := searchFilters{
+		userID:   getUserID(c),
+		kind:     c.Query("type"),
+		tag:      c.Query("tag"),
+		lessonID: c.Query("lessonId"),
+		due:      c.Query("due"),
+		deleted:  c.DefaultQuery("deleted", "false"),
+		config:   search.Config(c.Query("lang")),
+		limit:    defaultSearchLimit,
+		now:      time.Now(),
+	}
+	switch {
+	case filters.kind != "" && filters.kind != "lessons" && filters.kind != "cards":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'lessons' or 'cards'"})
+		return
+	case filters.due != "" && !slices.Contains([]string{searchDue, searchNew, searchScheduled, searchSuspended}, filters.due):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "due must be 'due', 'new', 'scheduled' or 'suspended'"})
+		return
+	case filters.deleted != "false" && filters.deleted != "true" && filters.deleted != "all":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deleted must be 'false', 'true' or 'all'"})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		filters.limit = min(n, maxSearchLimit)
+	}
+
+	results, err := searchPostgres(query, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	slices.SortStableFunc(results, func(a, b searchResult) int {
+		if a.Rank > b.Rank {
+			return -1
+		}
+		if a.Rank < b.Rank {
+			return 1
+		}
+		return 0
+	})
+	results = results[:min(len(results), filters.limit)]
+	for i := range results {
+		if results[i].Snippet == "" {
+			results[i].Snippet = search.Snippet(results[i].Headline, query.CJK, snippetRunes)
+		}
+		if results[i].Tags == nil {
+			results[i].Tags = []string{}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (f searchFilters) lessons() bool { return f.kind != "cards" && f.due == "" }
+func (f searchFilters) cards() bool   { return f.kind != "lessons" }
+
+// filterLessons applies the filters that lessons and cards share.
+func (f searchFilters) filterLessons(q *gorm.DB) *gorm.DB {
+	q = q.Where("lessons.user_id = ?", f.userID)
+	if f.lessonID != "" {
+		q = q.Where("lessons.id = ?", f.lessonID)
+	}
+	return q
+}
+
+func (f searchFilters) filterCards(q *gorm.DB) *gorm.DB {
+	q = f.filterLessons(q.Joins("JOIN lessons ON lessons.id = flashcards.lesson_id"))
+	switch f.deleted {
+	case "false":
+		q = q.Where("flashcards.deleted_at = 0 AND lessons.deleted_at = 0")
+	case "true":
+		q = q.Where("(flashcards.deleted_at > 0 OR lessons.deleted_at > 0)")
+	}
+	return filterCardStatus(q, f.due, f.now.UnixMilli())
+}
+
+// dueCardCondition selects cards due for review, with the current time as
+// both arguments.
+const dueCardCondition = "NOT flashcards.suspended AND flashcards.buried_until <= ? AND NOT (" + newCardCondition + ") AND flashcards.next_review <= ?"
+
+// filterCardStatus restricts q to cards in one of the due states, all cards
+// if status is empty.
+func filterCardStatus(q *gorm.DB, status string, now int64) *gorm.DB {
+	switch status {
+	case searchDue:
+		return q.Where(dueCardCondition, now, now)
+	case searchNew:
+		return q.Where(newCardCondition)
+	case searchScheduled:
+		return q.Where("NOT ("+newCardCondition+") AND flashcards.next_review > ?", now)
+	case searchSuspended:
+		return q.Where("flashcards.suspended")
+	}
+	return q
+}
+
+func (f searchFilters) deletedLessons(q *gorm.DB) *gorm.DB {
+	switch f.deleted {
+	case "false":
+		return q.Where("lessons.deleted_at = 0")
+	case "true":
+		return q.Where("lessons.deleted_at > 0")
+	}
+	return q
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchPostgres ranks with Postgres full-text search. Words are stemmed
+// with the chosen configuration and weighted by field; CJK runs are matched
+// as substrings and add a fixed amount to the rank.
+func searchPostgres(query search.Query, f searchFilters) ([]searchResult, error) {
+	config := "'" + f.config + "'::regconfig" // from search.Configs, safe to inline
+	tsquery := "websearch_to_tsquery(" + config + ", ?)"
+	headlineOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=6", search.StartMark, search.StopMark)
+	var tagJSON []byte
+	if f.tag != "" {
+		tagJSON, _ = json.Marshal([]string{f.tag})
+	}
+
+	// match narrows q to rows matching the query and selects rank and headline
+	match := func(q *gorm.DB, doc, text, primary, columns string) *gorm.DB {
+		rank, args := "0", []any{}
+		headline := text
+		if query.Text != "" {
+			q = q.Where(doc+" @@ "+tsquery, query.Text)
+			rank = "ts_rank(" + doc + ", " + tsquery + ", 32)"
+			headline = "ts_headline(" + config + ", " + text + ", " + tsquery + ", ?)"
+			args = append(args, query.Text)
+		}
+		for _, run := range query.CJK {
+			pattern := "%" + escapeLike(run) + "%"
+			q = q.Where(text+" ILIKE ?", pattern)
+			rank += " + CASE WHEN " + primary + " ILIKE ? THEN 0.2 ELSE 0.05 END"
+			args = append(args, pattern)
+		}
+		if query.Text != "" {
+			args = append(args, query.Text, headlineOptions)
+		}
+		return q.Select(columns+", "+rank+" AS rank, "+headline+" AS headline", args...).
+			Order("rank DESC").Limit(f.limit)
+	}
+
+	results := make([]searchResult, 0)
+	if f.lessons() {
+		var lessons []searchResult
+		q := f.deletedLessons(f.filterLessons(db.DB.Table("lessons")))
+		if tagJSON != nil {
+			q = q.Where("CAST(lessons.tags AS jsonb) @> ?", string(tagJSON))
+		}
+		doc := "(setweight(to_tsvector(" + config + ", lessons.title), 'A') || " +
+			"setweight(to_tsvector(" + config + ", lessons.description), 'B') || " +
+			"setweight(to_tsvector(" + config + ", lessons.markdown_content), 'C'))"
+		text := "(lessons.title || E'\\n' || lessons.description || E'\\n' || lessons.markdown_content)"
+		if err := match(q, doc, text, "lessons.title",
+			"'lesson' AS type, lessons.id, lessons.id AS lesson_id, lessons.title, lessons.tags, lessons.deleted_at").
+			Scan(&lessons).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, lessons...)
+	}
+	if f.cards() {
+		var cards []searchResult
+		q := f.filterCards(db.DB.Table("flashcards"))
+		if tagJSON != nil {
+			q = q.Where("(CAST(flashcards.tags AS jsonb) @> ? OR CAST(lessons.tags AS jsonb) @> ?)", string(tagJSON), string(tagJSON))
+		}
+		doc := "(setweight(to_tsvector(" + config + ", flashcards.front), 'A') || " +
+			"setweight(to_tsvector(" + config + ", flashcards.back), 'B'))"
+		text := "(flashcards.front || E'\\n' || flashcards.back)"
+		if err := match(q, doc, text, "flashcards.front",
+			"'card' AS type, flashcards.id, flashcards.lesson_id, flashcards.front, flashcards.back, flashcards.tags, "+
+				"flashcards.next_review, GREATEST(flashcards.deleted_at, lessons.deleted_at) AS deleted_at").
+			Scan(&cards).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, cards...)
+	}
+	return results, nil
+}
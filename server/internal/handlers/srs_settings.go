@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSRSSettingsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, srsSettingsResponse(user))
+}
+
+// srsSettingsInput changes the user's default algorithm, FSRS options, daily
+// study limits and leech handling. Nil fields are left unchanged; an empty
+// parameter list resets FSRS to the default weights.
+type srsSettingsInput struct {
+	Algorithm        *srs.Algorithm `json:"algorithm"`
+	DesiredRetention *float64       `json:"desiredRetention"`
+	Parameters       *[]float64     `json:"parameters"`
+	NewCardsPerDay   *int           `json:"newCardsPerDay"`
+	ReviewsPerDay    *int           `json:"reviewsPerDay"`
+	Timezone         *string        `json:"timezone"`
+	LeechThreshold   *int           `json:"leechThreshold"`
+	LeechAction      *string        `json:"leechAction"`
+}
+
+// apply validates the settings and sets them on user, stopping at the first
+// invalid one.
+func (in srsSettingsInput) apply(user *models.User) error {
+	if in.Algorithm != nil {
+		if !in.Algorithm.Valid() {
+			return errors.New("algorithm must be 'sm2' or 'fsrs'")
+		}
+		user.SchedulingAlgorithm = string(*in.Algorithm)
+	}
+	if in.DesiredRetention != nil {
+		if !srs.ValidRetention(*in.DesiredRetention) {
+			return errors.New("desiredRetention must be between 0.7 and 0.97")
+		}
+		user.DesiredRetention = *in.DesiredRetention
+	}
+	if in.Parameters != nil {
+		if len(*in.Parameters) != 0 && !srs.ValidParameters(*in.Parameters) {
+			return errors.New("parameters must contain 17 FSRS weights within their allowed ranges")
+		}
+		user.FSRSParameters = *in.Parameters
+	}
+	if in.NewCardsPerDay != nil {
+		if *in.NewCardsPerDay < 0 || *in.NewCardsPerDay > 9999 {
+			return errors.New("newCardsPerDay must be between 0 and 9999")
+		}
+		user.NewCardsPerDay = *in.NewCardsPerDay
+	}
+	if in.ReviewsPerDay != nil {
+		if *in.ReviewsPerDay < 0 || *in.ReviewsPerDay > 99999 {
+			return errors.New("reviewsPerDay must be between 0 and 99999")
+		}
+		user.ReviewsPerDay = *in.ReviewsPerDay
+	}
+	if in.Timezone != nil {
+		if *in.Timezone == "" {
+			return errors.New("timezone must be an IANA zone name")
+		}
+		if _, err := time.LoadLocation(*in.Timezone); err != nil {
+			return errors.New("Unknown timezone")
+		}
+		user.Timezone = *in.Timezone
+	}
+	if in.LeechThreshold != nil {
+		if *in.LeechThreshold < 0 || *in.LeechThreshold > 99 {
+			return errors.New("leechThreshold must be between 0 (off) and 99")
+		}
+		user.LeechThreshold = *in.LeechThreshold
+	}
+	if in.LeechAction != nil {
+		if *in.LeechAction != LeechActionTag && *in.LeechAction != LeechActionSuspend {
+			return errors.New("leechAction must be 'tag' or 'suspend'")
+		}
+		user.LeechAction = *in.LeechAction
+	}
+	return nil
+}
+
+// srsSettingsColumns are the user columns srsSettingsInput sets.
+var srsSettingsColumns = []string{"scheduling_algorithm", "desired_retention", "fsrs_parameters", "new_cards_per_day", "reviews_per_day", "timezone", "leech_threshold", "leech_action"}
+
+// UpdateSRSSettingsHandler changes the user's study settings, see
+// srsSettingsInput.
+func UpdateSRSSettingsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var req srsSettingsInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := req.apply(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.DB.Model(&user).Select(srsSettingsColumns).Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+	c.JSON(http.StatusOK, srsSettingsResponse(user))
+}
+
+// OptimizeFSRSHandler fits FSRS parameters to the user's review history and
+// saves them. Reviews with an unknown grade are not usable and are skipped.
+// Only the complete histories of the most recently reviewed cards are used,
+// up to srs.MaxOptimizeReviews reviews.
+func OptimizeFSRSHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var logs []models.ReviewLog
+	recentCards := db.DB.Raw(`
+		SELECT card_id FROM (
+			SELECT card_id, SUM(COUNT(*)) OVER (ORDER BY MAX(reviewed_at) DESC, card_id) AS running
+			FROM review_logs WHERE user_id = ? AND grade >= 0 GROUP BY card_id
+		) AS cards WHERE running <= ?
+	`, userID, srs.MaxOptimizeReviews)
+	if err := db.DB.Where("user_id = ? AND grade >= 0 AND card_id IN (?)", userID, recentCards).
+		Order("card_id, reviewed_at ASC").Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load review history"})
+		return
+	}
+
+	histories := make([][]srs.ReviewEvent, 0)
+	for i, entry := range logs {
+		if i == 0 || logs[i-1].CardID != entry.CardID {
+			histories = append(histories, nil)
+		}
+		last := len(histories) - 1
+		histories[last] = append(histories[last], srs.ReviewEvent{Grade: srs.Grade(entry.Grade), ReviewedAt: entry.ReviewedAt})
+	}
+
+	result, err := srs.OptimizeFSRS(c.Request.Context(), histories, user.FSRSParameters)
+	if errors.Is(err, context.Canceled) {
+		return // the client went away
+	}
+	if errors.Is(err, srs.ErrNotEnoughReviews) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "At least 400 reviews spread over several days are needed to optimize"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to optimize parameters"})
+		return
+	}
+
+	user.FSRSParameters = result.Parameters
+	if err := db.DB.Model(&user).Select("fsrs_parameters").Updates(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save parameters"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// schedulerForLesson returns the scheduler for cards of a lesson. The
+// algorithm is the lesson's if it overrides one, then its preset's, then the
+// user's; the preset's study options apply in any case.
+func schedulerForLesson(userID, lessonID string) (srs.Scheduler, error) {
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	settings := userSRSSettings(user)
+
+	var lesson models.Lesson
+	if err := db.DB.Select("scheduling_algorithm", "preset_id").First(&lesson, "id = ?", lessonID).Error; err != nil {
+		return nil, err
+	}
+	if lesson.PresetID != "" {
+		var preset models.StudyPreset
+		if err := db.DB.Where("id = ? AND user_id = ?", lesson.PresetID, userID).Limit(1).Find(&preset).Error; err != nil {
+			return nil, err
+		}
+		if preset.ID != "" {
+			settings.Preset = presetOptions(preset)
+			if algorithm := srs.Algorithm(preset.SchedulingAlgorithm); algorithm.Valid() {
+				settings.Algorithm = algorithm
+			}
+		}
+	}
+	if algorithm := srs.Algorithm(lesson.SchedulingAlgorithm); algorithm.Valid() {
+		settings.Algorithm = algorithm
+	}
+	return srs.NewScheduler(settings), nil
+}
+
+func userSRSSettings(user models.User) srs.Settings {
+	settings := srs.Settings{
+		Algorithm:        srs.Algorithm(user.SchedulingAlgorithm),
+		DesiredRetention: user.DesiredRetention,
+		Parameters:       user.FSRSParameters,
+	}
+	if !settings.Algorithm.Valid() {
+		settings.Algorithm = srs.AlgorithmSM2
+	}
+	return settings
+}
+
+func srsSettingsResponse(user models.User) gin.H {
+	settings := userSRSSettings(user)
+	if settings.DesiredRetention == 0 {
+		settings.DesiredRetention = srs.DefaultDesiredRetention
+	}
+	if len(settings.Parameters) == 0 {
+		settings.Parameters = srs.DefaultParameters
+	}
+	return gin.H{
+		"algorithm":        settings.Algorithm,
+		"desiredRetention": settings.DesiredRetention,
+		"parameters":       settings.Parameters,
+		"newCardsPerDay":   user.NewCardsPerDay,
+		"reviewsPerDay":    user.ReviewsPerDay,
+		"timezone":         user.Timezone,
+		"leechThreshold":   user.LeechThreshold,
+		"leechAction":      user.LeechAction,
+	}
+}
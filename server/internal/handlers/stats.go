@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lingolift-server/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStatsDays = 365
+	maxStatsDays     = 3650
+	forecastDays     = 30
+	// Cards with an interval of at least this many days count as mature
+	matureInterval = 21
+)
+
+type heatmapDay struct {
+	Day     string `json:"day"`
+	Reviews int    `json:"reviews"`
+}
+
+type retentionBucket struct {
+	Reviews   int     `json:"reviews"`
+	Passed    int     `json:"passed"`
+	Retention float64 `json:"retention"` // passed / reviews, 0 without reviews
+}
+
+type forecastDay struct {
+	Day string `json:"day"`
+	Due int    `json:"due"`
+}
+
+type lessonMastery struct {
+	LessonID string  `json:"lessonId"`
+	Title    string  `json:"title"`
+	Cards    int     `json:"cards"`
+	Mature   int     `json:"mature"`
+	Learned  int     `json:"learned"`
+	Mastery  float64 `json:"mastery"` // percentage of cards that are mature
+}
+
+// GetStatsHandler returns the user's study statistics: a daily review heatmap
+// and true retention over the last `days` days, the due forecast for the
+// next month, study streaks and how far each lesson has been mastered. Days
+// are calendar days in the user's timezone. With deckId, only the lessons
+// of that deck and its descendants count.
+func GetStatsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	deckIDs, ok := deckScope(c, userID)
+	if !ok {
+		return
+	}
+	days := defaultStatsDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive integer"})
+			return
+		}
+		days = min(n, maxStatsDays)
+	}
+
+	loc := userLocation(db.DB, userID)
+	tz := loc.String()
+	now := time.Now().In(loc)
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	since := startOfToday.AddDate(0, 0, -(days - 1)).UnixMilli()
+	// Local calendar day of a unix millisecond column
+	localDay := func(column string) string {
+		return "to_char(to_timestamp(" + column + " / 1000.0) AT TIME ZONE ?, 'YYYY-MM-DD')"
+	}
+	// Conditions restricting lessons and review logs to the deck, each taking
+	// deckIDs as the last argument
+	lessonScope, logScope := "", ""
+	scoped := func(args ...any) []any { return args }
+	if deckIDs != nil {
+		lessonScope = " AND lessons.deck_id IN ?"
+		logScope = " AND card_id IN (SELECT flashcards.id FROM flashcards JOIN lessons ON lessons.id = flashcards.lesson_id WHERE lessons.deck_id IN ?)"
+		scoped = func(args ...any) []any { return append(args, deckIDs) }
+	}
+
+	heatmap := make([]heatmapDay, 0)
+	if err := db.DB.Raw(`
+		SELECT `+localDay("reviewed_at")+` AS day, COUNT(*) AS reviews
+		FROM review_logs
+		WHERE user_id = ? AND reviewed_at >= ?`+logScope+`
+		GROUP BY day
+		ORDER BY day
+	`, scoped(tz, userID, since)...).Scan(&heatmap).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute statistics"})
+		return
+	}
+
+	// True retention: share of reviews of already learned cards that were not
+	// forgotten, split by whether the card was young or mature at the time.
+	// First reviews and logs with an unknown grade say nothing about recall.
+	var retentionRows []struct {
+		Mature  bool
+		Reviews int
+		Passed  int
+	}
+	if err := db.DB.Raw(`
+		SELECT prev_interval >= ? AS mature, COUNT(*) AS reviews,
+			SUM(CASE WHEN grade > 0 THEN 1 ELSE 0 END) AS passed
+		FROM review_logs
+		WHERE user_id = ? AND reviewed_at >= ? AND prev_interval > 0 AND grade >= 0`+logScope+`
+		GROUP BY mature
+	`, scoped(matureInterval, userID, since)...).Scan(&retentionRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute statistics"})
+		return
+	}
+	var young, mature, total retentionBucket
+	for _, row := range retentionRows {
+		bucket := &young
+		if row.Mature {
+			bucket = &mature
+		}
+		bucket.Reviews, bucket.Passed = row.Reviews, row.Passed
+		total.Reviews += row.Reviews
+		total.Passed += row.Passed
+	}
+	for _, bucket := range []*retentionBucket{&young, &mature, &total} {
+		if bucket.Reviews > 0 {
+			bucket.Retention = float64(bucket.Passed) / float64(bucket.Reviews)
+		}
+	}
+
+	// Overdue cards are counted as due today
+	var forecastRows []forecastDay
+	forecastEnd := startOfToday.AddDate(0, 0, forecastDays).UnixMilli()
+	if err := db.DB.Raw(`
+		SELECT `+localDay("GREATEST(flashcards.next_review, ?)")+` AS day, COUNT(*) AS due
+		FROM flashcards
+		JOIN lessons ON lessons.id = flashcards.lesson_id
+		WHERE lessons.user_id = ? AND lessons.deleted_at = 0 AND flashcards.deleted_at = 0
+			AND NOT flashcards.suspended AND NOT (`+newCardCondition+`) AND flashcards.next_review < ?`+lessonScope+`
+		GROUP BY day
+	`, scoped(now.UnixMilli(), tz, userID, forecastEnd)...).Scan(&forecastRows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute statistics"})
+		return
+	}
+	dueByDay := make(map[string]int, len(forecastRows))
+	for _, row := range forecastRows {
+		dueByDay[row.Day] = row.Due
+	}
+	forecast := make([]forecastDay, forecastDays)
+	for i := range forecast {
+		day := startOfToday.AddDate(0, 0, i).Format(time.DateOnly)
+		forecast[i] = forecastDay{Day: day, Due: dueByDay[day]}
+	}
+
+	var studyDays []string
+	if err := db.DB.Raw(`
+		SELECT DISTINCT `+localDay("reviewed_at")+` AS day
+		FROM review_logs
+		WHERE user_id = ?`+logScope+`
+		ORDER BY day
+	`, scoped(tz, userID)...).Scan(&studyDays).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute statistics"})
+		return
+	}
+	current, longest := studyStreaks(studyDays, startOfToday)
+
+	lessons := make([]lessonMastery, 0)
+	if err := db.DB.Raw(`
+		SELECT lessons.id AS lesson_id, lessons.title,
+			COUNT(flashcards.id) AS cards,
+			SUM(CASE WHEN flashcards.interval >= ? THEN 1 ELSE 0 END) AS mature,
+			SUM(CASE WHEN flashcards.repetition > 0 THEN 1 ELSE 0 END) AS learned
+		FROM lessons
+		LEFT JOIN flashcards ON flashcards.lesson_id = lessons.id AND flashcards.deleted_at = 0
+		WHERE lessons.user_id = ? AND lessons.deleted_at = 0`+lessonScope+`
+		GROUP BY lessons.id, lessons.title, lessons.created_at
+		ORDER BY lessons.created_at
+	`, scoped(matureInterval, userID)...).Scan(&lessons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute statistics"})
+		return
+	}
+	for i := range lessons {
+		if lessons[i].Cards > 0 {
+			lessons[i].Mastery = float64(lessons[i].Mature) * 100 / float64(lessons[i].Cards)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timezone": tz,
+		"heatmap":  heatmap,
+		"retention": gin.H{
+			"young":  young,
+			"mature": mature,
+			"total":  total,
+		},
+		"forecast": forecast,
+		"streak": gin.H{
+			"current": current,
+			"longest": longest,
+		},
+		"lessons": lessons,
+	})
+}
+
+// studyStreaks returns the current and longest runs of consecutive study
+// days. days must be sorted and distinct. A streak that ended yesterday is
+// still current, since today's reviews may not have happened yet.
+func studyStreaks(days []string, today time.Time) (current, longest int) {
+	run := 0
+	var prev time.Time
+	for _, d := range days {
+		t, err := time.ParseInLocation(time.DateOnly, d, today.Location())
+		if err != nil {
+			continue
+		}
+		if run > 0 && t.Equal(prev.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+		prev = t
+	}
+	if run > 0 && (prev.Equal(today) || prev.Equal(today.AddDate(0, 0, -1))) {
+		current = run
+	}
+	return current, longest
+}
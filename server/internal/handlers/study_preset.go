@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type studyPresetRequest struct {
+	Name                string        `json:"name"`
+	SchedulingAlgorithm srs.Algorithm `json:"schedulingAlgorithm"`
+	LearningSteps       []int         `json:"learningSteps"`
+	GraduatingInterval  int           `json:"graduatingInterval"`
+	EasyBonus           float64       `json:"easyBonus"`
+	MaximumInterval     int           `json:"maximumInterval"`
+	NewCardsPerDay      *int          `json:"newCardsPerDay"` // omitted: no limit of its own
+}
+
+func GetStudyPresetsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	presets := make([]models.StudyPreset, 0)
+	if err := db.DB.Where("user_id = ?", userID).Order("created_at ASC").Find(&presets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch presets"})
+		return
+	}
+	c.JSON(http.StatusOK, presets)
+}
+
+func CreateStudyPresetHandler(c *gin.Context) {
+	userID := getUserID(c)
+	now := time.Now().UnixMilli()
+	preset := models.StudyPreset{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		CreatedAt: now,
+	}
+	if !bindStudyPreset(c, &preset) {
+		return
+	}
+	preset.LastUpdated = now
+
+	if err := db.DB.Create(&preset).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create preset"})
+		return
+	}
+	c.JSON(http.StatusCreated, preset)
+}
+
+// UpdateStudyPresetHandler replaces all options of a preset. Lessons using it
+// pick the new options up on their next review.
+func UpdateStudyPresetHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var preset models.StudyPreset
+	if err := db.DB.First(&preset, "id = ? AND user_id = ?", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+		return
+	}
+	if !bindStudyPreset(c, &preset) {
+		return
+	}
+	preset.LastUpdated = time.Now().UnixMilli()
+
+	if err := db.DB.Save(&preset).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preset"})
+		return
+	}
+	c.JSON(http.StatusOK, preset)
+}
+
+// DeleteStudyPresetHandler deletes a preset; its lessons go back to the
+// user's defaults.
+func DeleteStudyPresetHandler(c *gin.Context) {
+	userID := getUserID(c)
+	id := c.Param("id")
+	now := time.Now().UnixMilli()
+
+	var deleted int64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Lesson{}).
+			Where("preset_id = ? AND user_id = ?", id, userID).
+			Updates(map[string]interface{}{"preset_id": "", "last_updated": now}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND user_id = ?", id, userID).Delete(&models.StudyPreset{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete preset"})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preset not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Preset deleted"})
+}
+
+// bindStudyPreset reads and validates a studyPresetRequest into preset. It
+// writes a 400 response and returns false if the request is invalid.
+func bindStudyPreset(c *gin.Context, preset *models.StudyPreset) bool {
+	var req studyPresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, err.Error())
+		return false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return false
+	}
+	if req.SchedulingAlgorithm != "" && !req.SchedulingAlgorithm.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schedulingAlgorithm must be 'sm2' or 'fsrs'"})
+		return false
+	}
+	options := srs.Preset{
+		LearningSteps:      req.LearningSteps,
+		GraduatingInterval: req.GraduatingInterval,
+		EasyBonus:          req.EasyBonus,
+		MaximumInterval:    req.MaximumInterval,
+	}
+	if err := options.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "learningSteps must be 1 to 10080 minutes (at most 10), intervals 0 to 36500 days and easyBonus 1 to 5"})
+		return false
+	}
+	newCardsPerDay := -1
+	if req.NewCardsPerDay != nil {
+		if *req.NewCardsPerDay < -1 || *req.NewCardsPerDay > 9999 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "newCardsPerDay must be between -1 (no limit) and 9999"})
+			return false
+		}
+		newCardsPerDay = *req.NewCardsPerDay
+	}
+
+	preset.Name = req.Name
+	preset.SchedulingAlgorithm = string(req.SchedulingAlgorithm)
+	preset.LearningSteps = req.LearningSteps
+	preset.GraduatingInterval = req.GraduatingInterval
+	preset.EasyBonus = req.EasyBonus
+	preset.MaximumInterval = req.MaximumInterval
+	preset.NewCardsPerDay = newCardsPerDay
+	return true
+}
+
+func presetOptions(preset models.StudyPreset) *srs.Preset {
+	return &srs.Preset{
+		LearningSteps:      preset.LearningSteps,
+		GraduatingInterval: preset.GraduatingInterval,
+		EasyBonus:          preset.EasyBonus,
+		MaximumInterval:    preset.MaximumInterval,
+	}
+}
+
+// presetNewCardsIntroduced counts, per preset, the cards seen for the first
+// time since start (the beginning of the user's day).
+func presetNewCardsIntroduced(userID string, start int64) (map[string]int, error) {
+	var rows []struct {
+		PresetID string
+		Count    int
+	}
+	err := db.DB.Raw(`
+		SELECT lessons.preset_id, COUNT(DISTINCT review_logs.card_id) AS count
+		FROM review_logs
+		JOIN flashcards ON flashcards.id = review_logs.card_id
+		JOIN lessons ON lessons.id = flashcards.lesson_id
+		WHERE review_logs.user_id = ? AND review_logs.reviewed_at >= ? AND review_logs.prev_interval = 0
+			AND lessons.preset_id <> ''
+			AND NOT EXISTS (
+				SELECT 1 FROM review_logs earlier
+				WHERE earlier.card_id = review_logs.card_id AND earlier.reviewed_at < ?
+			)
+		GROUP BY lessons.preset_id
+	`, userID, start, start).Scan(&rows).Error
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.PresetID] = row.Count
+	}
+	return counts, err
+}
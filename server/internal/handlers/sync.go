@@ -2,25 +2,135 @@ package handlers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"lingolift-server/internal/db"
 	"lingolift-server/internal/models"
+	"lingolift-server/internal/srs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func SyncHandler(c *gin.Context) {
 	userID := getUserID(c)
 	var req models.SyncRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		badBody(c, err, err.Error())
 		return
 	}
 
+	session := negotiateSyncProtocol(c, &req)
+	if session == nil {
+		return
+	}
+	if !session.has(CapabilityLessonEdits) {
+		req.Changes.CreatedLessons = nil
+		req.Changes.ModifiedLessons = nil
+	}
+	if !session.has(CapabilityReviewLogs) {
+		req.Changes.ReviewLogs = nil
+	}
+	if !session.has(CapabilityDecks) {
+		req.Changes.Decks = nil
+		req.Changes.DeletedDeckIDs = nil
+	}
+
+	device := registerDevice(c, userID, req.Device, session.has(CapabilityFullResync))
+	deviceID := ""
+	if device != nil {
+		deviceID = device.ID
+	} else {
+		db.DB.Model(&models.User{}).Where("id = ?", userID).Update("unregistered_sync_at", time.Now().UnixMilli())
+	}
+
+	// Upstream edits are checked for conflicts against the client's cursor,
+	// which is in server time like the LastUpdated stamps of server copies
+	baseTimestamp := req.LastSyncTimestamp
+
+	// Tombstones older than the client's last sync may have been purged, so it
+	// cannot be told about those deletions incrementally.
+	fullResync := false
+	var user models.User
+	if err := db.DB.Select("tombstones_purged_before").First(&user, "id = ?", userID).Error; err == nil &&
+		req.LastSyncTimestamp > 0 && req.LastSyncTimestamp < user.TombstonesPurgedBefore {
+		req.LastSyncTimestamp = 0
+		fullResync = true
+	}
+
 	// 1. Process Upstream Changes
-	fmt.Printf("Debug: Received %d CreatedCards, %d ModifiedCards, %d DeletedCards, %d DeletedLessons\n", len(req.Changes.CreatedCards), len(req.Changes.ModifiedCards), len(req.Changes.DeletedCardIDs), len(req.Changes.DeletedLessonIDs))
+	debugf("Received %d CreatedLessons, %d ModifiedLessons, %d CreatedCards, %d ModifiedCards, %d DeletedCards, %d DeletedLessons", len(req.Changes.CreatedLessons), len(req.Changes.ModifiedLessons), len(req.Changes.CreatedCards), len(req.Changes.ModifiedCards), len(req.Changes.DeletedCardIDs), len(req.Changes.DeletedLessonIDs))
+
+	// Decks go first so lessons can be filed in offline-created decks, and
+	// lessons before cards so created cards can reference offline-created lessons
+	conflictedDeckIDs := applyDeckChanges(userID, req.Changes.Decks, req.Changes.DeletedDeckIDs, baseTimestamp)
+	conflictedLessonIDs := make([]string, 0)
+
+	// L1. Created Lessons
+	for _, newLesson := range req.Changes.CreatedLessons {
+		if _, err := uuid.Parse(newLesson.ID); err != nil || newLesson.Title == "" {
+			debugf("Invalid created lesson %q, skipping", newLesson.ID)
+			continue
+		}
+
+		var existing models.Lesson
+		if err := db.DB.First(&existing, "id = ?", newLesson.ID).Error; err == nil {
+			if existing.UserID != userID || existing.DeletedAt > 0 {
+				debugf("Lesson %s is not editable, skipping", newLesson.ID)
+				continue
+			}
+			// Already created (e.g. a retried sync), treat as an edit
+			if !applyLessonEdit(&existing, newLesson, baseTimestamp, session.has(CapabilityDecks)) {
+				conflictedLessonIDs = append(conflictedLessonIDs, existing.ID)
+			}
+			continue
+		}
+
+		lesson := models.Lesson{
+			ID:              newLesson.ID,
+			UserID:          userID,
+			Title:           newLesson.Title,
+			Description:     newLesson.Description,
+			CreatedAt:       newLesson.CreatedAt,
+			MarkdownContent: newLesson.MarkdownContent,
+			Tags:            newLesson.Tags,
+			LastUpdated:     time.Now().UnixMilli(),
+		}
+		if lesson.CreatedAt == 0 {
+			lesson.CreatedAt = lesson.LastUpdated
+		}
+		if ownsMediaURL(userID, newLesson.AudioURL) {
+			lesson.AudioURL = newLesson.AudioURL
+		}
+		if ownsMediaURL(userID, newLesson.PDFURL) {
+			lesson.PDFURL = newLesson.PDFURL
+		}
+		if session.has(CapabilityDecks) && newLesson.DeckID != "" && ownsDeck(db.DB, userID, newLesson.DeckID) {
+			lesson.DeckID = newLesson.DeckID
+			lesson.Position = newLesson.Position
+		}
+		// Cards of an offline lesson are sent separately as createdCards
+		if err := db.DB.Omit("Flashcards").Create(&lesson).Error; err != nil {
+			log.Printf("Failed to create lesson: %v", err)
+		}
+	}
+
+	// L2. Modified Lessons
+	for _, modifiedLesson := range req.Changes.ModifiedLessons {
+		var lesson models.Lesson
+		if err := db.DB.First(&lesson, "id = ? AND user_id = ? AND deleted_at = 0", modifiedLesson.ID, userID).Error; err != nil {
+			continue
+		}
+		if !applyLessonEdit(&lesson, modifiedLesson, baseTimestamp, session.has(CapabilityDecks)) {
+			conflictedLessonIDs = append(conflictedLessonIDs, lesson.ID)
+		}
+	}
 
 	// A. Created Cards
 	for _, newUserCard := range req.Changes.CreatedCards {
@@ -51,12 +161,23 @@ func SyncHandler(c *gin.Context) {
 				// App is newer, update server
 				card.ID = existingCard.ID
 				card.LessonID = existingCard.LessonID
+				card.NoteID = existingCard.NoteID
+				card.TemplateOrdinal = existingCard.TemplateOrdinal
+				if !session.has(CapabilityCardStates) {
+					card.Suspended = existingCard.Suspended
+					card.BuriedUntil = existingCard.BuriedUntil
+					card.Tags = existingCard.Tags
+				}
 				db.DB.Save(&card)
+				syncBasicNote(db.DB, card, card.LastUpdated)
 				fmt.Println("Debug: Updated existing card with client data")
 			}
 		} else {
 			// Card does not exist, create it
-			if err := db.DB.Create(&card).Error; err != nil {
+			note := basicNoteForCard(&card, userID)
+			if err := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Omit("Flashcards").Create(&note).Error; err != nil {
+				log.Printf("Failed to create note for card: %v", err)
+			} else if err := db.DB.Create(&card).Error; err != nil {
 				fmt.Printf("Debug: Failed to create card: %v\n", err)
 			} else {
 				fmt.Println("Debug: Created new card")
@@ -76,8 +197,14 @@ func SyncHandler(c *gin.Context) {
 			if modifiedCard.LastUpdated > card.LastUpdated {
 				card.Front = modifiedCard.Front
 				card.Back = modifiedCard.Back
+				if session.has(CapabilityCardStates) {
+					card.Suspended = modifiedCard.Suspended
+					card.BuriedUntil = modifiedCard.BuriedUntil
+					card.Tags = modifiedCard.Tags
+				}
 				card.LastUpdated = modifiedCard.LastUpdated
 				db.DB.Save(&card)
+				syncBasicNote(db.DB, card, card.LastUpdated)
 			}
 		}
 	}
@@ -92,6 +219,9 @@ func SyncHandler(c *gin.Context) {
 			SET deleted_at = ?, last_updated = ? 
 			WHERE id IN ? AND lesson_id IN (SELECT id FROM lessons WHERE user_id = ?)
 		`, now, now, req.Changes.DeletedCardIDs, userID)
+		if err := deleteCardNotes(db.DB, userID, req.Changes.DeletedCardIDs, now); err != nil {
+			log.Printf("Failed to update notes of deleted cards: %v", err)
+		}
 	}
 
 	// C2. Deleted Lessons
@@ -104,23 +234,82 @@ func SyncHandler(c *gin.Context) {
 	}
 
 	// D. Progress Updates
+	leechSettings := userLeechSettings(userID)
 	for _, progress := range req.Changes.ProgressUpdates {
+		if err := srs.Validate(progressSRSState(progress)); err != nil {
+			debugf("Rejecting progress for card %s: %v", progress.CardID, err)
+			continue
+		}
+
 		var card models.Flashcard
 		if err := db.DB.Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
 			Where("flashcards.id = ? AND lessons.user_id = ?", progress.CardID, userID).
 			First(&card).Error; err == nil {
 
 			if progress.LastUpdated > card.LastUpdated {
-				card.Interval = progress.Interval
-				card.Repetition = progress.Repetition
-				card.EFactor = progress.EFactor
-				card.NextReview = progress.NextReview
+				prev := cardSRSState(card)
+				applySRSState(&card, progressSRSState(progress))
+				// Older clients don't track these, so don't let their zero values erase history
+				if progress.Stability == 0 {
+					card.Stability = prev.Stability
+				}
+				if progress.Difficulty == 0 {
+					card.Difficulty = prev.Difficulty
+				}
+				card.LearningStep = prev.Step
+				if progress.Lapses == 0 {
+					// Infer the lapse from a learned card being reset, so leech detection still works
+					card.Lapses = prev.Lapses
+					if prev.Repetition > 0 && progress.Repetition == 0 {
+						card.Lapses++
+					}
+				}
+				card.Lapses = max(card.Lapses, prev.Lapses)
+				if card.LastReview == 0 {
+					card.LastReview = progress.LastUpdated
+				}
+				applyLeechRule(&card, prev.Lapses, leechSettings)
 				card.LastUpdated = progress.LastUpdated
 				db.DB.Save(&card)
+
+				// Clients that don't send their own review logs get one reconstructed from the state change
+				if !session.has(CapabilityReviewLogs) {
+					grade := -1
+					if g, ok := srs.InferGrade(prev, cardSRSState(card)); ok {
+						grade = int(g)
+					}
+					reviewLog := newReviewLog(card, userID, deviceID, grade, prev, cardSRSState(card), progress.LastUpdated)
+					if err := appendReviewLog(db.DB, &reviewLog); err != nil {
+						log.Printf("Failed to append review log for card %s: %v", card.ID, err)
+					}
+				}
 			}
 		}
 	}
 
+	// E. Review Logs (append-only, duplicates are ignored)
+	for _, reviewLog := range req.Changes.ReviewLogs {
+		if _, err := uuid.Parse(reviewLog.ID); err != nil || reviewLog.Grade < -1 || reviewLog.Grade > int(srs.Easy) {
+			debugf("Invalid review log %q, skipping", reviewLog.ID)
+			continue
+		}
+		var count int64
+		db.DB.Model(&models.Flashcard{}).
+			Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+			Where("flashcards.id = ? AND lessons.user_id = ?", reviewLog.CardID, userID).
+			Count(&count)
+		if count == 0 {
+			continue
+		}
+		reviewLog.UserID = userID
+		if reviewLog.DeviceID == "" {
+			reviewLog.DeviceID = deviceID
+		}
+		if err := appendReviewLog(db.DB, &reviewLog); err != nil {
+			log.Printf("Failed to append review log %s: %v", reviewLog.ID, err)
+		}
+	}
+
 	// 2. Fetch Downstream Updates
 	var lessons []models.Lesson
 
@@ -145,6 +334,27 @@ func SyncHandler(c *gin.Context) {
 			Find(&lessons)
 	}
 
+	// Make sure the winning server copy of every conflicted lesson goes back down
+	if len(conflictedLessonIDs) > 0 {
+		included := make(map[string]bool, len(lessons))
+		for _, l := range lessons {
+			included[l.ID] = true
+		}
+		missing := make([]string, 0, len(conflictedLessonIDs))
+		for _, id := range conflictedLessonIDs {
+			if !included[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			var conflicted []models.Lesson
+			db.DB.Preload("Flashcards", "deleted_at = 0").
+				Where("id IN ? AND user_id = ? AND deleted_at = 0", missing, userID).
+				Find(&conflicted)
+			lessons = append(lessons, conflicted...)
+		}
+	}
+
 	// Fetch Deleted Lessons
 	var deletedLessons []models.Lesson
 	db.DB.Where("user_id = ? AND deleted_at > ?", userID, req.LastSyncTimestamp).Find(&deletedLessons)
@@ -177,6 +387,10 @@ func SyncHandler(c *gin.Context) {
 			Repetition:  card.Repetition,
 			EFactor:     card.EFactor,
 			NextReview:  card.NextReview,
+			Stability:   card.Stability,
+			Difficulty:  card.Difficulty,
+			Lapses:      card.Lapses,
+			LastReview:  card.LastReview,
 			LastUpdated: card.LastUpdated,
 		})
 	}
@@ -199,12 +413,183 @@ func SyncHandler(c *gin.Context) {
 	// We can skip the checks.
 
 	response := models.SyncResponse{
+		ProtocolVersion: session.version,
+		Capabilities:    session.capabilityList(),
 		ServerTimestamp: time.Now().UnixMilli(),
+		FullResync:      fullResync,
 	}
 	response.Updates.Lessons = lessons
 	response.Updates.RemoteProgress = remoteProgress
-	response.Updates.DeletedLessonIDs = deletedLessonIDs
-	response.Updates.DeletedCardIDs = deletedCardIDs
+	if session.has(CapabilityLessonDeletes) {
+		response.Updates.DeletedLessonIDs = deletedLessonIDs
+	}
+	if session.has(CapabilityCardDeletes) {
+		response.Updates.DeletedCardIDs = deletedCardIDs
+	}
+	if session.has(CapabilityLessonEdits) {
+		response.Updates.ConflictedLessonIDs = conflictedLessonIDs
+	}
+	if session.has(CapabilityReviewLogs) {
+		// Logs uploaded by this device are not echoed back
+		reviewLogs := make([]models.ReviewLog, 0)
+		query := db.DB.Where("user_id = ? AND synced_at > ?", userID, req.LastSyncTimestamp)
+		if deviceID != "" {
+			query = query.Where("device_id <> ?", deviceID)
+		}
+		query.Order("reviewed_at ASC").Find(&reviewLogs)
+		response.Updates.ReviewLogs = reviewLogs
+	}
+	if session.has(CapabilityDecks) {
+		decks := make([]models.Deck, 0)
+		db.DB.Where("user_id = ? AND deleted_at = 0 AND (last_updated > ? OR id IN ?)", userID, req.LastSyncTimestamp, conflictedDeckIDs).
+			Order("position").Find(&decks)
+		var deletedDecks []models.Deck
+		db.DB.Select("id").Where("user_id = ? AND deleted_at > ?", userID, req.LastSyncTimestamp).Find(&deletedDecks)
+		response.Updates.Decks = decks
+		response.Updates.DeletedDeckIDs = recordIDs(deletedDecks, func(d models.Deck) string { return d.ID })
+	}
+
+	if device != nil {
+		db.DB.Model(device).Update("last_sync_cursor", response.ServerTimestamp)
+		schedulePurge(userID)
+	}
 
 	c.JSON(http.StatusOK, response)
 }
+
+// applyLessonEdit merges an upstream lesson edit into lesson. It returns
+// false when the server copy changed after since, the client's last sync,
+// and the edit was discarded. An edit that changes nothing, e.g. a retried
+// create, is accepted as is. Client clocks are never compared with server
+// stamps. Media can only be attached by reference to files that were
+// already uploaded by the same user. The deck is only taken from clients that sync decks,
+// which send it.
+func applyLessonEdit(lesson *models.Lesson, edit models.Lesson, since int64, decks bool) bool {
+	before := *lesson
+	if edit.Title != "" {
+		lesson.Title = edit.Title
+	}
+	lesson.Description = edit.Description
+	lesson.MarkdownContent = edit.MarkdownContent
+	lesson.Tags = edit.Tags
+	if ownsMediaURL(lesson.UserID, edit.AudioURL) {
+		lesson.AudioURL = edit.AudioURL
+	}
+	if ownsMediaURL(lesson.UserID, edit.PDFURL) {
+		lesson.PDFURL = edit.PDFURL
+	}
+	if decks && (edit.DeckID == "" || ownsDeck(db.DB, lesson.UserID, edit.DeckID)) {
+		lesson.DeckID = edit.DeckID
+		lesson.Position = edit.Position
+	}
+	if sameLessonContent(lessonRevisionOf(before), lessonRevisionOf(*lesson)) &&
+		lesson.DeckID == before.DeckID && lesson.Position == before.Position {
+		return true
+	}
+	if before.LastUpdated > since {
+		debugf("Lesson %s conflict. Client synced: %d, Server updated: %d", lesson.ID, since, before.LastUpdated)
+		*lesson = before
+		return false
+	}
+	// Stamp with server time so devices that synced after the client's edit still receive it
+	lesson.LastUpdated = time.Now().UnixMilli()
+
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Flashcards").Save(lesson).Error; err != nil {
+			return err
+		}
+		return recordLessonRevision(tx, before, *lesson, revisionSync)
+	}); err != nil {
+		log.Printf("Failed to update lesson %s: %v", lesson.ID, err)
+	}
+	pruneLessonRevisions(lesson.ID)
+	return true
+}
+
+// applyDeckChanges merges decks created, edited or deleted on a device,
+// discarding edits of decks that changed on the server after since, the
+// client's last sync. It returns the IDs of decks whose edits were discarded, so
+// the server copy can be sent back. A deck is applied once its parent
+// exists, so a device may send new decks and their children in any order.
+func applyDeckChanges(userID string, edits []models.Deck, deletedIDs []string, since int64) []string {
+	now := time.Now().UnixMilli()
+	conflicted := make([]string, 0)
+	pending := make([]models.Deck, 0, len(edits))
+	for _, edit := range edits {
+		if _, err := uuid.Parse(edit.ID); err != nil || strings.TrimSpace(edit.Name) == "" {
+			debugf("Invalid deck %q, skipping", edit.ID)
+			continue
+		}
+		pending = append(pending, edit)
+	}
+	for len(pending) > 0 {
+		waiting := make([]models.Deck, 0)
+		for _, edit := range pending {
+			if edit.ParentID != "" && !ownsDeck(db.DB, userID, edit.ParentID) {
+				waiting = append(waiting, edit)
+			} else if !applyDeckEdit(userID, edit, since, now) {
+				conflicted = append(conflicted, edit.ID)
+			}
+		}
+		if len(waiting) == len(pending) {
+			for _, edit := range waiting {
+				debugf("Parent of deck %s not found, skipping", edit.ID)
+				conflicted = append(conflicted, edit.ID)
+			}
+			break
+		}
+		pending = waiting
+	}
+
+	for _, id := range deletedIDs {
+		var deck models.Deck
+		if err := db.DB.First(&deck, "id = ? AND user_id = ? AND deleted_at = 0", id, userID).Error; err != nil {
+			continue
+		}
+		if err := db.DB.Transaction(func(tx *gorm.DB) error { return deleteDeck(tx, deck, now) }); err != nil {
+			log.Printf("Failed to delete deck %s: %v", id, err)
+		}
+	}
+	return conflicted
+}
+
+// applyDeckEdit creates or updates a deck from an upstream edit. It returns
+// false if the server copy changed after since or the edit would nest the
+// deck inside itself. Edits that change nothing are accepted as is.
+func applyDeckEdit(userID string, edit models.Deck, since, now int64) bool {
+	var deck models.Deck
+	if err := db.DB.First(&deck, "id = ?", edit.ID).Error; err == nil {
+		if deck.UserID != userID || deck.DeletedAt > 0 {
+			debugf("Deck %s is not editable, skipping", edit.ID)
+			return true
+		}
+		if deck.ParentID == edit.ParentID && deck.Name == strings.TrimSpace(edit.Name) && deck.Position == edit.Position {
+			return true
+		}
+		if deck.LastUpdated > since {
+			debugf("Deck %s conflict. Client synced: %d, Server updated: %d", deck.ID, since, deck.LastUpdated)
+			return false
+		}
+		if edit.ParentID != "" {
+			ancestors, err := deckAncestors(db.DB, userID, edit.ParentID)
+			if err != nil || slices.Contains(ancestors, deck.ID) {
+				return false
+			}
+		}
+	} else {
+		deck = models.Deck{ID: edit.ID, UserID: userID, CreatedAt: edit.CreatedAt}
+		if deck.CreatedAt == 0 {
+			deck.CreatedAt = now
+		}
+	}
+
+	deck.ParentID = edit.ParentID
+	deck.Name = strings.TrimSpace(edit.Name)
+	deck.Position = edit.Position
+	// Stamped with server time like lesson edits
+	deck.LastUpdated = now
+	if err := db.DB.Save(&deck).Error; err != nil {
+		log.Printf("Failed to save deck %s: %v", deck.ID, err)
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sync protocol history:
+//
+//	1: cards, card progress and lesson/card deletions. Clients that send no
+//	   protocolVersion are treated as version 1.
+//	2: offline lesson creation and edits (createdLessons/modifiedLessons).
+//	3: review logs synced as append-only events.
+//	4: card suspension, burying and tags.
+//	5: decks and the deck and position of lessons.
+//	6: clients announce that they handle fullResync.
+const SyncProtocolVersion = 6
+
+// Capabilities a client can announce in SyncRequest.Capabilities. Each one
+// unlocks part of the request/response format and needs a minimum protocol.
+const (
+	CapabilityCardDeletes   = "cardDeletes"
+	CapabilityLessonDeletes = "lessonDeletes"
+	CapabilityLessonEdits   = "lessonEdits"
+	CapabilityReviewLogs    = "reviewLogs"
+	CapabilityCardStates    = "cardStates"
+	CapabilityDecks         = "decks"
+	CapabilityFullResync    = "fullResync"
+)
+
+var syncCapabilities = []struct {
+	name       string
+	minVersion int
+}{
+	{CapabilityCardDeletes, 1},
+	{CapabilityLessonDeletes, 1},
+	{CapabilityLessonEdits, 2},
+	{CapabilityReviewLogs, 3},
+	{CapabilityCardStates, 4},
+	{CapabilityDecks, 5},
+	{CapabilityFullResync, 6},
+}
+
+// legacySyncCapabilities is what clients that predate capability negotiation
+// get, i.e. exactly what the server used to send unconditionally.
+var legacySyncCapabilities = []string{CapabilityCardDeletes, CapabilityLessonDeletes}
+
+// minSyncProtocolVersion is the oldest protocol still accepted. It can be
+// raised with SYNC_MIN_PROTOCOL_VERSION to force outdated apps to upgrade.
+var minSyncProtocolVersion = func() int {
+	v := os.Getenv("SYNC_MIN_PROTOCOL_VERSION")
+	if v == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > SyncProtocolVersion {
+		log.Printf("Invalid SYNC_MIN_PROTOCOL_VERSION %q, using 1", v)
+		return 1
+	}
+	return n
+}()
+
+// syncSession is the outcome of protocol negotiation for one sync request.
+type syncSession struct {
+	version      int
+	capabilities map[string]bool
+}
+
+func (s *syncSession) has(capability string) bool {
+	return s.capabilities[capability]
+}
+
+func (s *syncSession) capabilityList() []string {
+	list := make([]string, 0, len(s.capabilities))
+	for _, capability := range syncCapabilities {
+		if s.capabilities[capability.name] {
+			list = append(list, capability.name)
+		}
+	}
+	return list
+}
+
+// negotiateSyncProtocol picks the protocol version and capabilities for req.
+// It writes a 426 Upgrade Required response and returns nil if the client is
+// too old to be served.
+func negotiateSyncProtocol(c *gin.Context, req *models.SyncRequest) *syncSession {
+	version := req.ProtocolVersion
+	if version == 0 {
+		version = 1
+	}
+	if version < minSyncProtocolVersion {
+		c.JSON(http.StatusUpgradeRequired, gin.H{
+			"error":                  "This app version is too old to sync, please update",
+			"code":                   "sync_protocol_unsupported",
+			"protocolVersion":        version,
+			"minProtocolVersion":     minSyncProtocolVersion,
+			"currentProtocolVersion": SyncProtocolVersion,
+		})
+		return nil
+	}
+	// Newer clients are served with the newest protocol we know about
+	if version > SyncProtocolVersion {
+		version = SyncProtocolVersion
+	}
+
+	requested := req.Capabilities
+	if requested == nil {
+		requested = legacySyncCapabilities
+	}
+	session := &syncSession{version: version, capabilities: make(map[string]bool)}
+	for _, name := range requested {
+		for _, capability := range syncCapabilities {
+			if capability.name == name && capability.minVersion <= version {
+				session.capabilities[name] = true
+			}
+		}
+	}
+	return session
+}
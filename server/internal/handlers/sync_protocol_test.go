@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func negotiate(req models.SyncRequest) (*syncSession, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	return negotiateSyncProtocol(c, &req), w
+}
+
+func TestNegotiateSyncProtocol(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      int
+		capabilities []string
+		wantVersion  int
+		want         []string
+	}{
+		{"legacy client", 0, nil, 1, legacySyncCapabilities},
+		{"client announcing no capabilities", 1, []string{}, 1, []string{}},
+		{"capabilities above the version are dropped", 2, []string{CapabilityLessonEdits, CapabilityReviewLogs, CapabilityDecks}, 2, []string{CapabilityLessonEdits}},
+		{"unknown capabilities are ignored", 5, []string{"teleport", CapabilityDecks}, 5, []string{CapabilityDecks}},
+		{"newer client gets the current protocol", SyncProtocolVersion + 3, []string{CapabilityFullResync, CapabilityCardStates}, SyncProtocolVersion, []string{CapabilityCardStates, CapabilityFullResync}},
+	}
+	for _, tt := range tests {
+		session, w := negotiate(models.SyncRequest{ProtocolVersion: tt.version, Capabilities: tt.capabilities})
+		if session == nil {
+			t.Errorf("%s: refused with %d %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		if session.version != tt.wantVersion || !slices.Equal(session.capabilityList(), tt.want) {
+			t.Errorf("%s: got version %d, capabilities %q; want %d, %q", tt.name, session.version, session.capabilityList(), tt.wantVersion, tt.want)
+		}
+	}
+}
+
+func TestNegotiateSyncProtocolRequiresUpgrade(t *testing.T) {
+	previous := minSyncProtocolVersion
+	minSyncProtocolVersion = 3
+	t.Cleanup(func() { minSyncProtocolVersion = previous })
+
+	for _, version := range []int{0, 2} {
+		session, w := negotiate(models.SyncRequest{ProtocolVersion: version})
+		if session != nil || w.Code != http.StatusUpgradeRequired {
+			t.Errorf("version %d: got status %d, want 426", version, w.Code)
+			continue
+		}
+		var body struct {
+			Code               string `json:"code"`
+			MinProtocolVersion int    `json:"minProtocolVersion"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != "sync_protocol_unsupported" || body.MinProtocolVersion != 3 {
+			t.Errorf("version %d: got body %s", version, w.Body.String())
+		}
+	}
+	if session, _ := negotiate(models.SyncRequest{ProtocolVersion: 3}); session == nil {
+		t.Errorf("minimum version was refused")
+	}
+}
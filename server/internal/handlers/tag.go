@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type tagUsage struct {
+	Name    string `json:"name"`
+	Lessons int    `json:"lessons"` // live lessons with the tag
+	Cards   int    `json:"cards"`   // live cards with the tag
+}
+
+// GetTagsHandler lists the tags of the user's lessons and cards with how
+// often each is used, sorted by name.
+func GetTagsHandler(c *gin.Context) {
+	userID := getUserID(c)
+	var lessons []models.Lesson
+	var cards []models.Flashcard
+	if err := db.DB.Select("id, tags").Where("user_id = ? AND deleted_at = 0", userID).Find(&lessons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	if err := userCardsQuery(userID).Select("flashcards.id, flashcards.tags").Find(&cards).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+
+	usage := make(map[string]*tagUsage)
+	lookup := func(tag string) *tagUsage {
+		if usage[tag] == nil {
+			usage[tag] = &tagUsage{Name: tag}
+		}
+		return usage[tag]
+	}
+	for _, lesson := range lessons {
+		for _, tag := range compactTags(lesson.Tags) {
+			lookup(tag).Lessons++
+		}
+	}
+	for _, card := range cards {
+		for _, tag := range compactTags(card.Tags) {
+			lookup(tag).Cards++
+		}
+	}
+
+	tags := make([]tagUsage, 0, len(usage))
+	for _, u := range usage {
+		tags = append(tags, *u)
+	}
+	slices.SortFunc(tags, func(a, b tagUsage) int {
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	c.JSON(http.StatusOK, tags)
+}
+
+// RenameTagHandler renames a tag everywhere. Items that already have the
+// new name keep a single copy, so renaming to an existing tag merges them.
+func RenameTagHandler(c *gin.Context) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	from, to := strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	if from == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is required"})
+		return
+	}
+	if !validTagName(c, to) {
+		return
+	}
+	if from == to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are the same tag"})
+		return
+	}
+	editTags(c, []string{from}, func(string) (string, bool) { return to, true })
+}
+
+// MergeTagsHandler replaces a set of tags with one, new or existing.
+func MergeTagsHandler(c *gin.Context) {
+	var req struct {
+		Tags []string `json:"tags"`
+		Into string   `json:"into"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	into := strings.TrimSpace(req.Into)
+	if !validTagName(c, into) {
+		return
+	}
+	tags := slices.DeleteFunc(compactTags(req.Tags), func(tag string) bool { return tag == into })
+	if len(tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags must name at least one tag other than into"})
+		return
+	}
+	editTags(c, tags, func(string) (string, bool) { return into, true })
+}
+
+// DeleteTagsHandler removes tags from every lesson and card.
+func DeleteTagsHandler(c *gin.Context) {
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badBody(c, err, "Invalid request")
+		return
+	}
+	tags := compactTags(req.Tags)
+	if len(tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags is required"})
+		return
+	}
+	editTags(c, tags, func(string) (string, bool) { return "", false })
+}
+
+// validTagName writes an error response if name can't be used as a tag.
+// Lesson forms send tags comma-separated, so a comma would split it.
+func validTagName(c *gin.Context, name string) bool {
+	switch {
+	case name == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name is required"})
+		return false
+	case strings.Contains(name, ","):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag names can't contain commas"})
+		return false
+	}
+	return true
+}
+
+// editTags replaces each of tags with the result of replace, or removes it
+// if replace returns false, on all of the user's lessons and cards
+// (trashed ones too) in one transaction, and reports how many changed.
+func editTags(c *gin.Context, tags []string, replace func(tag string) (string, bool)) {
+	userID := getUserID(c)
+	now := time.Now().UnixMilli()
+	edit := func(current []string) ([]string, bool) {
+		edited := make([]string, 0, len(current))
+		changed := false
+		for _, tag := range current {
+			if slices.Contains(tags, strings.TrimSpace(tag)) {
+				changed = true
+				var keep bool
+				if tag, keep = replace(tag); !keep {
+					continue
+				}
+			}
+			edited = append(edited, tag)
+		}
+		return compactTags(edited), changed
+	}
+
+	var cardCount int
+	editedLessons := make([]string, 0)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var lessons []models.Lesson
+		var cards []models.Flashcard
+		if err := tx.Select("id, tags").Where("user_id = ?", userID).Find(&lessons).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("flashcards.id, flashcards.tags").
+			Joins("JOIN lessons ON lessons.id = flashcards.lesson_id").
+			Where("lessons.user_id = ?", userID).
+			Find(&cards).Error; err != nil {
+			return err
+		}
+		for _, lesson := range lessons {
+			edited, changed := edit(lesson.Tags)
+			if !changed {
+				continue
+			}
+			var before models.Lesson
+			if err := tx.First(&before, "id = ?", lesson.ID).Error; err != nil {
+				return err
+			}
+			after := before
+			after.Tags, after.LastUpdated = edited, now
+			if err := tx.Model(&after).Select("tags", "last_updated").Updates(&after).Error; err != nil {
+				return err
+			}
+			if err := recordLessonRevision(tx, before, after, revisionTags); err != nil {
+				return err
+			}
+			editedLessons = append(editedLessons, lesson.ID)
+		}
+		for _, card := range cards {
+			if edited, changed := edit(card.Tags); changed {
+				if err := tx.Model(&models.Flashcard{ID: card.ID}).Select("tags", "last_updated").
+					Updates(&models.Flashcard{Tags: edited, LastUpdated: now}).Error; err != nil {
+					return err
+				}
+				cardCount++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+	if len(editedLessons) == 0 && cardCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+	for _, id := range editedLessons {
+		pruneLessonRevisions(id)
+	}
+	c.JSON(http.StatusOK, gin.H{"lessons": len(editedLessons), "cards": cardCount})
+}
+
+// compactTags trims tags and drops empty and repeated ones, keeping the
+// first occurrence of each.
+func compactTags(tags []string) []string {
+	compact := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(compact, tag) {
+			compact = append(compact, tag)
+		}
+	}
+	return compact
+}
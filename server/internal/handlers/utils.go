@@ -1,7 +1,38 @@
 package handlers
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
 
 func getUserID(c *gin.Context) string {
 	return c.GetString("userID")
 }
+
+// badBody answers a request whose body could not be read or parsed: 413 if
+// it went over a size limit, such as the cap on decompressed bodies,
+// otherwise 400 with message.
+func badBody(c *gin.Context, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+}
+
+// debugLogging enables debugf, set with DEBUG_LOG=true.
+var debugLogging, _ = strconv.ParseBool(os.Getenv("DEBUG_LOG"))
+
+// debugf logs details that help trace a request, such as records a sync
+// skipped, when debug logging is enabled.
+func debugf(format string, args ...any) {
+	if debugLogging {
+		log.Printf(format, args...)
+	}
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"lingolift-server/internal/db"
 	"lingolift-server/internal/models"
@@ -21,24 +22,21 @@ func AuthMiddleware() gin.HandlerFunc {
 				var apiKey models.APIKey
 				if err := db.DB.Where("key = ?", apiKeyStr).First(&apiKey).Error; err == nil {
 					c.Set("userID", apiKey.UserID)
-					c.Next()
+					c.Set("apiKeyID", apiKey.ID)
+					checkDevice(c, apiKey.UserID, apiKey.CreatedAt)
 					return
 				}
 			}
 		}
 
 		// 2. Check Cookie (Session for Web)
-		userID, err := c.Cookie("auth_token")
-		if err == nil && userID != "" {
-			// Verify user exists (optional but recommended)
-			// For performance, we might skip DB check on every request if we trust the cookie,
-			// but since it's a raw ID, we MUST verify it exists to prevent spoofing if IDs were guessable (UUIDs are hard to guess but still).
-			// A better approach is signed cookies or JWT.
-			// For this MVP, we'll check DB.
-			var user models.User
-			if err := db.DB.First(&user, "id = ?", userID).Error; err == nil {
-				c.Set("userID", user.ID)
-				c.Next()
+		token, err := c.Cookie("auth_token")
+		if err == nil && token != "" {
+			var session models.Session
+			if err := db.DB.Where("token = ? AND expires_at > ?", token, time.Now().UnixMilli()).First(&session).Error; err == nil {
+				c.Set("userID", session.UserID)
+				c.Set("sessionID", session.ID)
+				checkDevice(c, session.UserID, session.CreatedAt)
 				return
 			}
 		}
@@ -46,3 +44,21 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 	}
 }
+
+// checkDevice rejects requests from a device that was signed out remotely and
+// otherwise continues the chain. Devices identify themselves with X-Device-ID.
+// Credentials issued after the revocation are let through so the user can sign
+// the device back in.
+func checkDevice(c *gin.Context, userID string, credentialCreatedAt int64) {
+	deviceID := c.GetHeader("X-Device-ID")
+	if deviceID != "" {
+		var device models.Device
+		if err := db.DB.First(&device, "id = ? AND user_id = ?", deviceID, userID).Error; err == nil &&
+			device.RevokedAt > 0 && credentialCreatedAt <= device.RevokedAt {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "This device has been signed out", "code": "device_revoked"})
+			return
+		}
+		c.Set("deviceID", deviceID)
+	}
+	c.Next()
+}
@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Responses smaller than this are not worth compressing
+	compressMinSize = 1024
+	// Default cap for request bodies after decompression, see MAX_DECOMPRESSED_BODY_BYTES
+	defaultMaxDecompressedBody = 64 << 20
+)
+
+// Encodings the server can produce, in order of preference
+var supportedEncodings = []string{"zstd", "gzip"}
+
+// Content types that are already compressed and only cost CPU to recompress
+var incompressibleTypes = []string{"image/", "audio/", "video/", "application/pdf", "application/zip", "application/gzip", "application/zstd", "font/woff"}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	},
+}
+
+var zstdEncoderPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	},
+}
+
+// Compress negotiates a response encoding from Accept-Encoding and compresses
+// the body while it is being written, so streamed responses stay streamed.
+// Media under /uploads, range requests and already-compressed content are
+// passed through untouched.
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead ||
+			strings.HasPrefix(c.Request.URL.Path, "/uploads/") ||
+			c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.Close()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value,
+// breaking ties by server preference. It returns "" for identity.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		quality[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the first compressMinSize bytes to decide whether to
+// compress at all, then streams through the encoder.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buf      bytes.Buffer
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= compressMinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Close writes out anything still buffered and finishes the compressed stream.
+func (w *compressWriter) Close() {
+	if !w.decided {
+		w.decide(w.buf.Len() >= compressMinSize)
+	}
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	switch enc := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriterPool.Put(enc)
+	case *zstd.Encoder:
+		zstdEncoderPool.Put(enc)
+	}
+	w.encoder = nil
+}
+
+func (w *compressWriter) decide(worthIt bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+
+	if worthIt && w.shouldCompress() {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		switch w.encoding {
+		case "gzip":
+			enc := gzipWriterPool.Get().(*gzip.Writer)
+			enc.Reset(w.ResponseWriter)
+			w.encoder = enc
+		case "zstd":
+			enc := zstdEncoderPool.Get().(*zstd.Encoder)
+			enc.Reset(w.ResponseWriter)
+			w.encoder = enc
+		}
+	}
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) shouldCompress() bool {
+	status := w.Status()
+	if status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// DecompressRequest accepts request bodies sent with Content-Encoding gzip or
+// zstd. The decompressed size is capped (MAX_DECOMPRESSED_BODY_BYTES, 64 MiB
+// by default) so a small compressed payload cannot expand without bound.
+func DecompressRequest() gin.HandlerFunc {
+	maxSize := int64(defaultMaxDecompressedBody)
+	if v := os.Getenv("MAX_DECOMPRESSED_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("Invalid MAX_DECOMPRESSED_BODY_BYTES %q, using %d", v, maxSize)
+		} else {
+			maxSize = n
+		}
+	}
+
+	return func(c *gin.Context) {
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding")))
+		var body io.ReadCloser
+		switch encoding {
+		case "", "identity":
+			c.Next()
+			return
+		case "gzip":
+			reader, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid gzip body"})
+				return
+			}
+			body = reader
+		case "zstd":
+			reader, err := zstd.NewReader(c.Request.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid zstd body"})
+				return
+			}
+			body = reader.IOReadCloser()
+		default:
+			c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported Content-Encoding: " + encoding})
+			return
+		}
+		defer body.Close()
+
+		c.Request.Body = &limitedBody{ReadCloser: body, limit: maxSize, remaining: maxSize}
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Request.ContentLength = -1
+		c.Next()
+	}
+}
+
+// limitedBody fails with *http.MaxBytesError once more than limit bytes have
+// been read, like http.MaxBytesReader, so handlers answer 413 for it as they
+// do for their own caps. io.LimitReader would silently truncate the body.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
@@ -0,0 +1,325 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lingolift-server/internal/db"
+	"lingolift-server/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyHeader     = "Idempotency-Key"
+	defaultIdempotencyTTL = 24 * time.Hour
+	// Default cap for bodies buffered to fingerprint them, see MAX_IDEMPOTENT_BODY_BYTES
+	defaultMaxIdempotentBody = 64 << 20
+	// Bodies larger than this are spooled to a temporary file instead of memory
+	idempotentBodyInMemory = 1 << 20
+	// How often expired records are deleted
+	idempotencyExpiryInterval = time.Hour
+)
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key. It must run after AuthMiddleware since keys are scoped
+// per user. Requests without the header pass through untouched.
+//
+// The replay window defaults to 24h and can be changed with IDEMPOTENCY_TTL
+// (any time.ParseDuration value, e.g. "48h"). Bodies are buffered to
+// fingerprint the request, large ones on disk, and are capped at
+// MAX_IDEMPOTENT_BODY_BYTES (64 MiB by default). Expired records are deleted
+// by ExpireIdempotencyRecords.
+func Idempotency() gin.HandlerFunc {
+	return idempotency(true)
+}
+
+// IdempotencyWithoutBody is Idempotency for endpoints whose responses are too
+// large to keep, such as /sync. Only the status is stored: a retry of a
+// finished request gets that status and a short message saying the request
+// was already processed, and has to be sent again with a new key to get a
+// full response.
+func IdempotencyWithoutBody() gin.HandlerFunc {
+	return idempotency(false)
+}
+
+func idempotency(storeBody bool) gin.HandlerFunc {
+	ttl := idempotencyTTL()
+	maxBody := int64(defaultMaxIdempotentBody)
+	if v := os.Getenv("MAX_IDEMPOTENT_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("Invalid MAX_IDEMPOTENT_BODY_BYTES %q, using %d", v, maxBody)
+		} else {
+			maxBody = n
+		}
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		userID := c.GetString("userID")
+
+		body, err := bufferBody(c.Request.Body, maxBody)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		defer body.Close()
+		requestHash, err := requestFingerprint(c.Request, body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(body)
+
+		// Claim the key. The unique index on (user_id, key) makes this fail
+		// if another request, finished or still running, already holds it.
+		now := time.Now()
+		record := models.IdempotencyRecord{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Key:         key,
+			RequestHash: requestHash,
+			CreatedAt:   now.UnixMilli(),
+			ExpiresAt:   now.Add(ttl).UnixMilli(),
+		}
+		for attempt := 0; ; attempt++ {
+			if err := db.DB.Create(&record).Error; err == nil {
+				break
+			}
+			var existing models.IdempotencyRecord
+			if err := db.DB.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+				return
+			}
+			// An expired record that wasn't deleted yet no longer holds the key
+			if existing.ExpiresAt < now.UnixMilli() && attempt == 0 {
+				db.DB.Where("id = ? AND expires_at < ?", existing.ID, now.UnixMilli()).Delete(&models.IdempotencyRecord{})
+				continue
+			}
+			replayIdempotentResponse(c, existing, requestHash)
+			return
+		}
+
+		// A panicking handler never finishes the record, which would answer
+		// "still being processed" until it expires
+		defer func() {
+			if r := recover(); r != nil {
+				db.DB.Delete(&record)
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, discard: !storeBody}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			// Server errors are not final, release the key so the client can retry
+			db.DB.Delete(&record)
+			return
+		}
+		contentType, stored := recorder.Header().Get("Content-Type"), recorder.body.Bytes()
+		if !storeBody {
+			contentType, stored = "application/json; charset=utf-8", processedWithoutBody
+		}
+		db.DB.Model(&record).Updates(map[string]interface{}{
+			"status_code":  status,
+			"content_type": contentType,
+			"body":         stored,
+		})
+	}
+}
+
+// processedWithoutBody answers the retry of a request whose response wasn't
+// kept, see IdempotencyWithoutBody.
+var processedWithoutBody = []byte(`{"message":"Request was already processed, send it again with a new Idempotency-Key for a full response","code":"idempotency_response_not_kept"}`)
+
+func idempotencyTTL() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", v, defaultIdempotencyTTL)
+	}
+	return defaultIdempotencyTTL
+}
+
+// ExpireIdempotencyRecords deletes expired Idempotency-Key records every
+// hour until the process exits.
+func ExpireIdempotencyRecords() {
+	for {
+		if err := db.DB.Where("expires_at < ?", time.Now().UnixMilli()).Delete(&models.IdempotencyRecord{}).Error; err != nil {
+			log.Printf("Failed to delete expired idempotency records: %v", err)
+		}
+		time.Sleep(idempotencyExpiryInterval)
+	}
+}
+
+// spooledBody is a buffered request body, in memory or in a temporary file.
+type spooledBody interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type memoryBody struct{ *bytes.Reader }
+
+func (memoryBody) Close() error { return nil }
+
+type fileBody struct{ *os.File }
+
+func (f fileBody) Close() error {
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// bufferBody reads r so it can be read twice, failing with
+// *http.MaxBytesError if it is longer than max. Bodies beyond
+// idempotentBodyInMemory are spooled to disk.
+func bufferBody(r io.Reader, max int64) (spooledBody, error) {
+	var head bytes.Buffer
+	n, err := io.CopyN(&head, r, min(max, idempotentBodyInMemory)+1)
+	if err == io.EOF {
+		return memoryBody{bytes.NewReader(head.Bytes())}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, &http.MaxBytesError{Limit: max}
+	}
+
+	f, err := os.CreateTemp("", "lingolift-request-*")
+	if err != nil {
+		return nil, err
+	}
+	body := fileBody{f}
+	written, err := io.Copy(f, io.MultiReader(&head, io.LimitReader(r, max+1-n)))
+	if err == nil && written > max {
+		err = &http.MaxBytesError{Limit: max}
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+// requestFingerprint hashes what makes a request the same request. Multipart
+// bodies are hashed by their fields and file contents, since clients pick a
+// new boundary every time they encode the same form. body is read from the
+// start and left at the start.
+func requestFingerprint(r *http.Request, body io.ReadSeeker) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	raw := func() (string, error) {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", err
+		}
+		_, err := body.Seek(0, io.SeekStart)
+		return hex.EncodeToString(hash.Sum(nil)), err
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return raw()
+	}
+
+	parts := make([]string, 0)
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Not parseable as sent, fall back to the raw bytes
+			return raw()
+		}
+		digest := sha256.New()
+		io.Copy(digest, part)
+		parts = append(parts, strings.Join([]string{part.FormName(), part.FileName(), hex.EncodeToString(digest.Sum(nil))}, "\x00"))
+	}
+	slices.Sort(parts)
+	for _, part := range parts {
+		hash.Write([]byte(part + "\n"))
+	}
+	_, err := body.Seek(0, io.SeekStart)
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+func replayIdempotentResponse(c *gin.Context, record models.IdempotencyRecord, requestHash string) {
+	if record.RequestHash != requestHash {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "Idempotency-Key was already used with a different request",
+			"code":  "idempotency_key_reused",
+		})
+		return
+	}
+	if record.StatusCode == 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "A request with this Idempotency-Key is still being processed",
+			"code":  "idempotency_key_in_flight",
+		})
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.StatusCode, record.ContentType, record.Body)
+	c.Abort()
+}
+
+// responseRecorder tees everything the handler writes into a buffer, unless
+// it discards the body.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	discard bool
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if !w.discard {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	if !w.discard {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
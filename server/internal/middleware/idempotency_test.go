@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"lingolift-server/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+// useTestDB points db.DB at an in-memory SQLite database holding the
+// idempotency_records table. The queries the middleware runs are plain enough
+// to work on SQLite through the Postgres dialector.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	conn, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Exec(`
+		CREATE TABLE idempotency_records (
+			id TEXT PRIMARY KEY, user_id TEXT, key TEXT, request_hash TEXT, status_code INTEGER,
+			content_type TEXT, body BLOB, created_at INTEGER, expires_at INTEGER, UNIQUE (user_id, key))
+	`).Error; err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() { db.DB = previous })
+}
+
+// idempotentRouter serves POST /items behind the middleware, answering with
+// status and the number of times the handler ran. If release is set, the
+// handler sends on it once it starts and then waits to receive from it.
+func idempotentRouter(middleware gin.HandlerFunc, status int, release chan struct{}) (*gin.Engine, *atomic.Int32) {
+	gin.SetMode(gin.TestMode)
+	calls := new(atomic.Int32)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("userID", "user-1") }, middleware)
+	r.POST("/items", func(c *gin.Context) {
+		n := calls.Add(1)
+		if release != nil {
+			release <- struct{}{}
+			<-release
+		}
+		c.JSON(status, gin.H{"calls": n})
+	})
+	return r, calls
+}
+
+func postItem(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyHeader, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not JSON: %v", w.Body.String(), err)
+	}
+	return body.Code
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	useTestDB(t)
+	r, calls := idempotentRouter(Idempotency(), http.StatusCreated, nil)
+
+	first := postItem(r, "key-1", `{"name":"a"}`)
+	second := postItem(r, "key-1", `{"name":"a"}`)
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Fatalf("got statuses %d and %d, want 201", first.Code, second.Code)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Body.String() != first.Body.String() {
+		t.Errorf("retry got body %q, replayed %q; want %q replayed", second.Body.String(), second.Header().Get("Idempotent-Replayed"), first.Body.String())
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+
+	// Another key is another request
+	if w := postItem(r, "key-2", `{"name":"a"}`); w.Header().Get("Idempotent-Replayed") != "" || calls.Load() != 2 {
+		t.Errorf("new key was replayed")
+	}
+}
+
+func TestIdempotencyRejectsReusedKey(t *testing.T) {
+	useTestDB(t)
+	r, calls := idempotentRouter(Idempotency(), http.StatusCreated, nil)
+
+	postItem(r, "key-1", `{"name":"a"}`)
+	w := postItem(r, "key-1", `{"name":"b"}`)
+	if w.Code != http.StatusUnprocessableEntity || errorCode(t, w) != "idempotency_key_reused" {
+		t.Errorf("got %d %s, want 422 idempotency_key_reused", w.Code, w.Body.String())
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyRejectsRequestInFlight(t *testing.T) {
+	useTestDB(t)
+	release := make(chan struct{})
+	r, _ := idempotentRouter(Idempotency(), http.StatusCreated, release)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postItem(r, "key-1", `{"name":"a"}`) }()
+	<-release // the first request claimed the key and reached the handler
+
+	w := postItem(r, "key-1", `{"name":"a"}`)
+	if w.Code != http.StatusConflict || errorCode(t, w) != "idempotency_key_in_flight" {
+		t.Errorf("got %d %s, want 409 idempotency_key_in_flight", w.Code, w.Body.String())
+	}
+	release <- struct{}{}
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request got %d, want 201", first.Code)
+	}
+	if w := postItem(r, "key-1", `{"name":"a"}`); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("finished request was not replayed: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIdempotencyReleasesKeyOnServerError(t *testing.T) {
+	useTestDB(t)
+	r, calls := idempotentRouter(Idempotency(), http.StatusInternalServerError, nil)
+
+	postItem(r, "key-1", `{}`)
+	if w := postItem(r, "key-1", `{}`); w.Header().Get("Idempotent-Replayed") != "" || calls.Load() != 2 {
+		t.Errorf("retry after a server error was replayed instead of run again")
+	}
+}
+
+func TestIdempotencyWithoutBodyKeepsOnlyStatus(t *testing.T) {
+	useTestDB(t)
+	r, calls := idempotentRouter(IdempotencyWithoutBody(), http.StatusOK, nil)
+
+	postItem(r, "key-1", `{}`)
+	w := postItem(r, "key-1", `{}`)
+	if w.Code != http.StatusOK || errorCode(t, w) != "idempotency_response_not_kept" {
+		t.Errorf("got %d %s, want 200 idempotency_response_not_kept", w.Code, w.Body.String())
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyLimitsBody(t *testing.T) {
+	useTestDB(t)
+	t.Setenv("MAX_IDEMPOTENT_BODY_BYTES", "8")
+	r, calls := idempotentRouter(Idempotency(), http.StatusCreated, nil)
+
+	if w := postItem(r, "key-1", `{"name":"too long"}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", w.Code)
+	}
+	if w := postItem(r, "key-2", `{}`); w.Code != http.StatusCreated || calls.Load() != 1 {
+		t.Errorf("small body got %d, want 201", w.Code)
+	}
+}
+
+func TestRequestFingerprintIgnoresMultipartBoundary(t *testing.T) {
+	form := func(boundary, title string) *http.Request {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		w.SetBoundary(boundary)
+		w.WriteField("title", title)
+		part, _ := w.CreateFormFile("audio", "a.mp3")
+		part.Write([]byte("audio data"))
+		w.Close()
+		req := httptest.NewRequest(http.MethodPut, "/lessons/1", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req
+	}
+	fingerprint := func(req *http.Request) string {
+		body, err := bufferBody(req.Body, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		hash, err := requestFingerprint(req, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	a, b := fingerprint(form("boundary-a", "Lesson")), fingerprint(form("boundary-b", "Lesson"))
+	if a != b {
+		t.Errorf("same form with another boundary got another fingerprint")
+	}
+	if c := fingerprint(form("boundary-a", "Other")); c == a {
+		t.Errorf("different forms got the same fingerprint")
+	}
+}
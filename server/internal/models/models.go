@@ -5,7 +5,26 @@ type User struct {
 	Username  string   `gorm:"uniqueIndex" json:"username"`
 	Password  string   `json:"-"` // Don't return password in JSON
 	APIKeys   []APIKey `gorm:"foreignKey:UserID" json:"apiKeys"`
+	Devices   []Device `gorm:"foreignKey:UserID" json:"devices"`
 	CreatedAt int64    `json:"createdAt"`
+	// Scheduling preferences, see srs.Settings
+	SchedulingAlgorithm string    `json:"schedulingAlgorithm"` // "sm2" (default) or "fsrs"
+	DesiredRetention    float64   `json:"desiredRetention"`
+	FSRSParameters      []float64 `json:"fsrsParameters" gorm:"serializer:json"`
+	// Daily review queue limits, counted in the user's timezone
+	NewCardsPerDay int    `json:"newCardsPerDay" gorm:"default:20"`
+	ReviewsPerDay  int    `json:"reviewsPerDay" gorm:"default:200"`
+	Timezone       string `json:"timezone" gorm:"default:UTC"` // IANA name, e.g. "Asia/Shanghai"
+	// A card that lapses LeechThreshold times is tagged as a leech (0 disables
+	// detection). LeechAction "suspend" also takes it out of the queue.
+	LeechThreshold int    `json:"leechThreshold" gorm:"default:8"`
+	LeechAction    string `json:"leechAction" gorm:"default:tag"` // "tag" or "suspend"
+	// Tombstones deleted before this time have been purged. Devices whose last
+	// sync is older must do a full resync since they could miss deletions.
+	TombstonesPurgedBefore int64 `json:"-"`
+	// Last sync by a client that did not identify as a device. Such clients
+	// can't be tracked, so tombstones are kept while they are active.
+	UnregisteredSyncAt int64 `json:"-" gorm:"default:0"`
 }
 
 type APIKey struct {
@@ -16,6 +35,32 @@ type APIKey struct {
 	CreatedAt int64  `json:"createdAt"`
 }
 
+// Session backs the auth_token cookie used by the web app.
+type Session struct {
+	ID        string `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID    string `gorm:"index" json:"userId"`
+	Token     string `gorm:"uniqueIndex" json:"-"`
+	DeviceID  string `gorm:"index" json:"deviceId"` // Set once the browser syncs as a device
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Device is a client installation that syncs an account. It is registered on
+// its first sync using a client-generated ID sent in SyncRequest.Device.
+type Device struct {
+	ID             string `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID         string `gorm:"index" json:"userId"`
+	APIKeyID       string `json:"apiKeyId"` // Key the device authenticates with, empty for web sessions
+	Name           string `json:"name"`
+	Platform       string `json:"platform"` // e.g. "ios", "android", "desktop", "web"
+	AppVersion     string `json:"appVersion"`
+	LastSyncCursor int64  `json:"lastSyncCursor"`                  // serverTimestamp of the device's last completed sync
+	FullResync     bool   `json:"fullResync" gorm:"default:false"` // App handles SyncResponse.FullResync
+	LastSeenAt     int64  `json:"lastSeenAt"`
+	CreatedAt      int64  `json:"createdAt"`
+	RevokedAt      int64  `json:"revokedAt"`
+}
+
 type Lesson struct {
 	ID              string      `gorm:"primaryKey;type:uuid" json:"id"`
 	UserID          string      `gorm:"index" json:"userId"` // Foreign key
@@ -29,34 +74,162 @@ type Lesson struct {
 	DeletedAt       int64       `json:"deletedAt"`
 	LastUpdated     int64       `json:"lastUpdated"`
 	Flashcards      []Flashcard `gorm:"foreignKey:LessonID" json:"flashcards"`
+
+	// Overrides the user's algorithm for this lesson's cards, empty to inherit
+	SchedulingAlgorithm string `json:"schedulingAlgorithm"`
+	PresetID            string `gorm:"index" json:"presetId"` // StudyPreset, empty for none
+
+	DeckID   string `gorm:"index;default:''" json:"deckId"` // Deck the lesson is filed in, empty for none
+	Position int    `json:"position" gorm:"default:0"`      // order among the lessons of its deck
+}
+
+// Deck is a folder of lessons, e.g. a course or one of its units. Decks nest
+// through ParentID; siblings are ordered by Position.
+type Deck struct {
+	ID          string `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID      string `gorm:"index" json:"userId"`
+	ParentID    string `gorm:"index" json:"parentId"` // empty for a top-level deck
+	Name        string `json:"name"`
+	Position    int    `json:"position"`
+	CreatedAt   int64  `json:"createdAt"`
+	LastUpdated int64  `json:"lastUpdated"`
+	DeletedAt   int64  `json:"deletedAt"`
+}
+
+// LessonRevision is the content of a lesson after one of its edits, kept so
+// edits can be reviewed and undone. The first revision of a lesson holds its
+// content from before the first recorded edit.
+type LessonRevision struct {
+	ID              string   `gorm:"primaryKey;type:uuid" json:"id"`
+	LessonID        string   `gorm:"uniqueIndex:idx_lesson_revision_number" json:"lessonId"`
+	UserID          string   `gorm:"index" json:"userId"`
+	Number          int      `gorm:"uniqueIndex:idx_lesson_revision_number" json:"number"` // counts up from 1 per lesson
+	Source          string   `json:"source"`                                               // "original", "edit", "sync", "tags" or "restore"
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	MarkdownContent string   `json:"markdownContent"`
+	Tags            []string `json:"tags" gorm:"serializer:json"`
+	AudioURL        string   `json:"audioUrl"`
+	PDFURL          string   `json:"pdfUrl"`
+	CreatedAt       int64    `json:"createdAt"`
+}
+
+// StudyPreset is a named set of study options shared by the lessons that use
+// it, e.g. short intervals for an exam cram. See srs.Preset.
+type StudyPreset struct {
+	ID                  string  `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID              string  `gorm:"index" json:"userId"`
+	Name                string  `json:"name"`
+	SchedulingAlgorithm string  `json:"schedulingAlgorithm"`                  // empty to use the user's
+	LearningSteps       []int   `json:"learningSteps" gorm:"serializer:json"` // minutes
+	GraduatingInterval  int     `json:"graduatingInterval"`                   // days
+	EasyBonus           float64 `json:"easyBonus"`
+	MaximumInterval     int     `json:"maximumInterval"` // days, 0 for no limit
+	NewCardsPerDay      int     `json:"newCardsPerDay"`  // -1 to only apply the user's limit
+	CreatedAt           int64   `json:"createdAt"`
+	LastUpdated         int64   `json:"lastUpdated"`
 }
 
 type Flashcard struct {
-	ID            string  `gorm:"primaryKey;type:uuid" json:"id"`
-	LessonID      string  `gorm:"index" json:"lessonId"` // Foreign key
-	Front         string  `json:"front"`
-	Back          string  `json:"back"`
-	IsUserCreated bool    `json:"isUserCreated"`
-	Interval      int     `json:"interval"`
-	Repetition    int     `json:"repetition"`
-	EFactor       float64 `json:"efactor"`
-	NextReview    int64   `json:"nextReview"`
-	LastUpdated   int64   `json:"lastUpdated"`
-	DeletedAt     int64   `json:"deletedAt"`
+	ID              string  `gorm:"primaryKey;type:uuid" json:"id"`
+	LessonID        string  `gorm:"index" json:"lessonId"`                  // Foreign key
+	NoteID          string  `gorm:"index" json:"noteId"`                    // Note the card is rendered from
+	Seq             int64   `gorm:"autoIncrement;<-:create;index" json:"-"` // Creation order, assigned by the database
+	TemplateOrdinal int     `json:"templateOrdinal" gorm:"default:0"`
+	Front           string  `json:"front"`
+	Back            string  `json:"back"`
+	IsUserCreated   bool    `json:"isUserCreated"`
+	Interval        int     `json:"interval"`
+	Repetition      int     `json:"repetition"`
+	EFactor         float64 `json:"efactor"`
+	NextReview      int64   `json:"nextReview"`
+	Stability       float64 `json:"stability" gorm:"default:0"`  // FSRS memory state, 0 under SM-2
+	Difficulty      float64 `json:"difficulty" gorm:"default:0"` // FSRS memory state, 0 under SM-2
+	Lapses          int     `json:"lapses" gorm:"default:0"`
+	LastReview      int64   `json:"lastReview" gorm:"default:0"`
+	LearningStep    int     `json:"learningStep" gorm:"default:0"`
+	LastUpdated     int64   `json:"lastUpdated"`
+	DeletedAt       int64   `json:"deletedAt"`
+
+	// Suspended cards are never shown for review, buried ones not until BuriedUntil (unix millis)
+	Suspended   bool     `json:"suspended" gorm:"default:false"`
+	BuriedUntil int64    `json:"buriedUntil" gorm:"default:0"`
+	Tags        []string `json:"tags" gorm:"serializer:json"`
+}
+
+// Note holds the fields a set of cards is rendered from, one card per
+// template of its note type (see package notes). Front and Back of those
+// cards are kept in sync with the note, so clients can keep using them.
+type Note struct {
+	ID       string            `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID   string            `gorm:"index" json:"userId"`
+	LessonID string            `gorm:"index" json:"lessonId"`
+	NoteType string            `json:"noteType"`
+	Fields   map[string]string `json:"fields" gorm:"serializer:json"`
+	// Paragraph of the lesson's markdown the note was extracted from, if any
+	SourceParagraph *int `json:"sourceParagraph,omitempty"`
+	// Templates whose card the user deleted, which are not rendered again
+	ExcludedOrdinals []int       `json:"excludedOrdinals" gorm:"serializer:json"`
+	CreatedAt        int64       `json:"createdAt"`
+	LastUpdated      int64       `json:"lastUpdated"`
+	DeletedAt        int64       `json:"deletedAt"`
+	Flashcards       []Flashcard `gorm:"foreignKey:NoteID" json:"flashcards"`
+}
+
+// ReviewLog is an append-only record of a single grading event. Logs are
+// never updated, so they sync between devices by ID alone.
+type ReviewLog struct {
+	ID           string  `gorm:"primaryKey;type:uuid" json:"id"` // Client-generated for reviews done offline
+	CardID       string  `gorm:"index" json:"cardId"`
+	UserID       string  `gorm:"index:idx_review_logs_user_synced" json:"userId"`
+	DeviceID     string  `json:"deviceId"`
+	Grade        int     `json:"grade"` // srs.Grade, or -1 if only the resulting state is known
+	TimeTakenMs  int64   `json:"timeTakenMs"`
+	PrevInterval int     `json:"prevInterval"`
+	NextInterval int     `json:"nextInterval"`
+	PrevEFactor  float64 `json:"prevEfactor"`
+	NextEFactor  float64 `json:"nextEfactor"`
+	ReviewedAt   int64   `gorm:"index" json:"reviewedAt"`
+	SyncedAt     int64   `gorm:"index:idx_review_logs_user_synced" json:"syncedAt"` // When the server received it, used as sync cursor
+}
+
+// DailyStudyCounter counts the reviews of a user per local calendar day so
+// daily limits hold across devices.
+type DailyStudyCounter struct {
+	UserID      string `gorm:"primaryKey;type:uuid" json:"userId"`
+	Day         string `gorm:"primaryKey" json:"day"` // YYYY-MM-DD in the user's timezone
+	NewCount    int    `json:"newCount"`              // first-ever reviews of a card
+	ReviewCount int    `json:"reviewCount"`
 }
 
 // Sync structures
 type SyncRequest struct {
-	LastSyncTimestamp int64 `json:"lastSyncTimestamp"`
+	ProtocolVersion   int         `json:"protocolVersion"` // 0 for clients that predate versioning
+	Capabilities      []string    `json:"capabilities"`    // nil for clients that predate negotiation
+	Device            *DeviceInfo `json:"device"`
+	LastSyncTimestamp int64       `json:"lastSyncTimestamp"`
 	Changes           struct {
+		CreatedLessons   []Lesson       `json:"createdLessons"`  // Lessons authored offline, with client-generated IDs
+		ModifiedLessons  []Lesson       `json:"modifiedLessons"` // Metadata/markdown edits, media attached by URL
 		CreatedCards     []NewUserCard  `json:"createdCards"`
 		ModifiedCards    []Flashcard    `json:"modifiedCards"`
 		DeletedCardIDs   []string       `json:"deletedCardIds"`
 		DeletedLessonIDs []string       `json:"deletedLessonIds"`
 		ProgressUpdates  []CardProgress `json:"progressUpdates"`
+		ReviewLogs       []ReviewLog    `json:"reviewLogs"` // Grading events recorded on the device
+		Decks            []Deck         `json:"decks"`      // Created or edited decks
+		DeletedDeckIDs   []string       `json:"deletedDeckIds"`
 	} `json:"changes"`
 }
 
+// DeviceInfo identifies the syncing device. ID is generated once per install.
+type DeviceInfo struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"appVersion"`
+}
+
 type NewUserCard struct {
 	LessonID string    `json:"lessonId"`
 	Card     Flashcard `json:"card"`
@@ -68,15 +241,59 @@ type CardProgress struct {
 	Repetition  int     `json:"repetition"`
 	EFactor     float64 `json:"efactor"`
 	NextReview  int64   `json:"nextReview"`
+	Stability   float64 `json:"stability"`
+	Difficulty  float64 `json:"difficulty"`
+	Lapses      int     `json:"lapses"`
+	LastReview  int64   `json:"lastReview"`
 	LastUpdated int64   `json:"lastUpdated"`
 }
 
+// SyncResponse fields tagged omitzero are only sent to clients that negotiated
+// the matching capability.
 type SyncResponse struct {
-	ServerTimestamp int64 `json:"serverTimestamp"`
-	Updates         struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Capabilities    []string `json:"capabilities"`
+	ServerTimestamp int64    `json:"serverTimestamp"`
+	// FullResync tells the client its lastSyncTimestamp was too old to serve
+	// deletions incrementally; Lessons holds everything and local lessons
+	// missing from it should be dropped.
+	FullResync bool `json:"fullResync,omitzero"`
+	Updates    struct {
 		Lessons          []Lesson       `json:"lessons"`
-		DeletedLessonIDs []string       `json:"deletedLessonIds"`
+		DeletedLessonIDs []string       `json:"deletedLessonIds,omitzero"`
 		RemoteProgress   []CardProgress `json:"remoteProgress"`
-		DeletedCardIDs   []string       `json:"deletedCardIds"`
+		DeletedCardIDs   []string       `json:"deletedCardIds,omitzero"`
+		// Lessons whose upstream edits lost to a newer server version.
+		// The server copy is always included in Lessons so the client can overwrite its local one.
+		ConflictedLessonIDs []string `json:"conflictedLessonIds,omitzero"`
+		// Grading events received from other devices since the last sync
+		ReviewLogs []ReviewLog `json:"reviewLogs,omitzero"`
+		// Decks created or edited since the last sync, all of them on a full sync
+		Decks          []Deck   `json:"decks,omitzero"`
+		DeletedDeckIDs []string `json:"deletedDeckIds,omitzero"`
 	} `json:"updates"`
 }
+
+// Upload records that a file in the uploads directory was stored for a user.
+// Lessons can only refer to uploads of their own user. A file belongs to
+// several users when an account archive holding it is restored into another
+// account.
+type Upload struct {
+	Name      string `gorm:"primaryKey" json:"name"` // file name in the uploads directory
+	UserID    string `gorm:"primaryKey;type:uuid" json:"userId"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// IdempotencyRecord stores the outcome of a mutation sent with an Idempotency-Key
+// so retried requests can be answered without being executed twice.
+type IdempotencyRecord struct {
+	ID          string `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID      string `gorm:"uniqueIndex:idx_idempotency_user_key" json:"userId"`
+	Key         string `gorm:"uniqueIndex:idx_idempotency_user_key" json:"key"`
+	RequestHash string `json:"requestHash"` // SHA-256 of method, path and body
+	StatusCode  int    `json:"statusCode"`  // 0 while the original request is in flight
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"-"`
+	CreatedAt   int64  `json:"createdAt"`
+	ExpiresAt   int64  `gorm:"index" json:"expiresAt"`
+}
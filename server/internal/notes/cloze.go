@@ -0,0 +1,71 @@
+package notes
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// clozeDeletion matches {{c1::answer}} and {{c1::answer::hint}}.
+var clozeDeletion = regexp.MustCompile(`\{\{c(\d+)::(.+?)(?:::([^{}]*?))?\}\}`)
+
+// ClozeIndexes returns the distinct deletion numbers used in text, ascending.
+func ClozeIndexes(text string) []int {
+	indexes := make([]int, 0)
+	for _, match := range clozeDeletion.FindAllStringSubmatch(text, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index < 1 || slices.Contains(indexes, index) {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	return indexes
+}
+
+// Cloze formats a deletion of answer for card index, e.g. {{c2::word::hint}}.
+func Cloze(index int, answer, hint string) string {
+	deletion := "{{c" + strconv.Itoa(index) + "::" + answer
+	if hint != "" {
+		deletion += "::" + hint
+	}
+	return deletion + "}}"
+}
+
+// ValidClozeText reports whether s can be used as a deletion answer or hint
+// without breaking the cloze syntax.
+func ValidClozeText(s string) bool {
+	return !strings.Contains(s, "::") && !strings.ContainsAny(s, "{}")
+}
+
+// renderCloze renders text for the card of deletion index. On the front that
+// deletion is a blank, [...] or [hint]; on the back it shows the answer in
+// brackets. Other deletions show their answer as plain text.
+func renderCloze(text string, index int, back bool) string {
+	return clozeDeletion.ReplaceAllStringFunc(text, func(deletion string) string {
+		match := clozeDeletion.FindStringSubmatch(deletion)
+		if n, _ := strconv.Atoi(match[1]); n != index {
+			return match[2]
+		}
+		switch {
+		case back:
+			return "[" + match[2] + "]"
+		case match[3] != "":
+			return "[" + match[3] + "]"
+		default:
+			return "[...]"
+		}
+	})
+}
+
+// ClozeAnswers returns the answers hidden on the card of deletion index.
+func ClozeAnswers(text string, index int) []string {
+	answers := make([]string, 0)
+	for _, match := range clozeDeletion.FindAllStringSubmatch(text, -1) {
+		if n, _ := strconv.Atoi(match[1]); n == index {
+			answers = append(answers, match[2])
+		}
+	}
+	return answers
+}
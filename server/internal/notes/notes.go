@@ -0,0 +1,143 @@
+// Package notes defines the note types cards are generated from. A note holds
+// named fields; each template of its type renders one card from them, so a
+// vocabulary note can be studied in both directions with separate schedules.
+package notes
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Template renders one card of a note. Front and Back may reference fields
+// as {{Field}}, and cloze types as {{cloze:Field}}.
+type Template struct {
+	Name  string `json:"name"`
+	Front string `json:"front"`
+	Back  string `json:"back"`
+}
+
+type Type struct {
+	Name      string     `json:"name"`
+	Fields    []string   `json:"fields"`
+	Templates []Template `json:"templates"`
+	// A cloze type renders its only template once per deletion number
+	Cloze bool `json:"cloze"`
+}
+
+const (
+	TypeBasic         = "basic"
+	TypeBasicReversed = "basic-reversed"
+	TypeCloze         = "cloze"
+)
+
+// Types are the note types available to every user, in display order.
+var Types = []Type{
+	{
+		Name:   TypeBasic,
+		Fields: []string{"Front", "Back"},
+		Templates: []Template{
+			{Name: "Card 1", Front: "{{Front}}", Back: "{{Back}}"},
+		},
+	},
+	{
+		Name:   TypeBasicReversed,
+		Fields: []string{"Front", "Back"},
+		Templates: []Template{
+			{Name: "Forward", Front: "{{Front}}", Back: "{{Back}}"},
+			{Name: "Reverse", Front: "{{Back}}", Back: "{{Front}}"},
+		},
+	},
+	{
+		Name:   TypeCloze,
+		Fields: []string{"Text", "Extra"},
+		Templates: []Template{
+			{Name: "Cloze", Front: "{{cloze:Text}}", Back: "{{cloze:Text}}\n\n{{Extra}}"},
+		},
+		Cloze: true,
+	},
+}
+
+func Lookup(name string) (Type, bool) {
+	for _, t := range Types {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Type{}, false
+}
+
+var (
+	ErrUnknownField = errors.New("unknown field")
+	ErrEmptyNote    = errors.New("note would produce no cards")
+)
+
+// Card is the content of one card rendered from a note. Ordinal identifies
+// the template (the deletion number for cloze types), so a card keeps its
+// schedule when the note is edited.
+type Card struct {
+	Ordinal int
+	Front   string
+	Back    string
+}
+
+var fieldRef = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// Render generates the cards of a note with the given fields. Templates whose
+// front comes out empty produce no card.
+func (t Type) Render(fields map[string]string) []Card {
+	if t.Cloze {
+		template := t.Templates[0]
+		cards := make([]Card, 0)
+		for _, index := range ClozeIndexes(fields[t.Fields[0]]) {
+			cards = append(cards, Card{
+				Ordinal: index,
+				Front:   renderTemplate(template.Front, fields, index, false),
+				Back:    strings.TrimSpace(renderTemplate(template.Back, fields, index, true)),
+			})
+		}
+		return cards
+	}
+
+	cards := make([]Card, 0, len(t.Templates))
+	for i, template := range t.Templates {
+		front := renderTemplate(template.Front, fields, 0, false)
+		if strings.TrimSpace(front) == "" {
+			continue
+		}
+		cards = append(cards, Card{Ordinal: i, Front: front, Back: renderTemplate(template.Back, fields, 0, true)})
+	}
+	return cards
+}
+
+// Validate checks that fields only uses fields of t and renders to at least one card.
+func (t Type) Validate(fields map[string]string) error {
+	for name := range fields {
+		if !t.hasField(name) {
+			return ErrUnknownField
+		}
+	}
+	if len(t.Render(fields)) == 0 {
+		return ErrEmptyNote
+	}
+	return nil
+}
+
+func (t Type) hasField(name string) bool {
+	for _, field := range t.Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func renderTemplate(template string, fields map[string]string, clozeIndex int, back bool) string {
+	return fieldRef.ReplaceAllStringFunc(template, func(ref string) string {
+		name := fieldRef.FindStringSubmatch(ref)[1]
+		if field, ok := strings.CutPrefix(name, "cloze:"); ok {
+			return renderCloze(fields[field], clozeIndex, back)
+		}
+		return fields[name]
+	})
+}
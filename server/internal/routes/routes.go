@@ -23,17 +23,77 @@ func SetupRoutes(r *gin.Engine) {
 			protected.GET("/auth/profile", handlers.GetProfileHandler)
 			protected.POST("/auth/apikey", handlers.GenerateAPIKeyHandler)
 			protected.DELETE("/auth/apikey/:id", handlers.DeleteAPIKeyHandler)
+			protected.GET("/devices", handlers.GetDevicesHandler)
+			protected.DELETE("/devices/:id", handlers.RevokeDeviceHandler)
 
 			protected.GET("/lessons", handlers.GetLessonsHandler)
-			protected.POST("/lessons", handlers.CreateLessonHandler)
+			protected.POST("/lessons", middleware.Idempotency(), handlers.CreateLessonHandler)
 			protected.PUT("/lessons/:id", handlers.UpdateLessonHandler)
+			protected.PATCH("/lessons/:id", handlers.PatchLessonHandler)
 			protected.DELETE("/lessons/:id", handlers.DeleteLessonHandler)
 			protected.GET("/lessons/trash", handlers.GetDeletedLessonsHandler)
 			protected.POST("/lessons/:id/restore", handlers.RestoreLessonHandler)
-			protected.POST("/sync", handlers.SyncHandler)
-			protected.POST("/cards", handlers.CreateCardHandler)
+			protected.GET("/lessons/:id/cards", handlers.GetLessonCardsHandler)
+			protected.POST("/lessons/:id/move", handlers.MoveLessonHandler)
+			protected.PUT("/lessons/:id/audio", handlers.PutLessonAudioHandler)
+			protected.DELETE("/lessons/:id/audio", handlers.DeleteLessonAudioHandler)
+			protected.PUT("/lessons/:id/pdf", handlers.PutLessonPDFHandler)
+			protected.DELETE("/lessons/:id/pdf", handlers.DeleteLessonPDFHandler)
+			protected.GET("/lessons/:id/revisions", handlers.GetLessonRevisionsHandler)
+			protected.GET("/lessons/:id/revisions/:revisionId", handlers.GetLessonRevisionHandler)
+			protected.POST("/lessons/:id/revisions/:revisionId/restore", handlers.RestoreLessonRevisionHandler)
+			protected.POST("/lessons/:id/cloze", middleware.Idempotency(), handlers.CreateLessonClozeHandler)
+			protected.GET("/lessons/:id/export/anki", handlers.ExportLessonAnkiHandler)
+			protected.POST("/lessons/:id/import/csv", handlers.ImportCSVHandler)
+			protected.GET("/lessons/:id/export/csv", handlers.ExportCSVHandler)
+
+			protected.GET("/decks", handlers.GetDecksHandler)
+			protected.POST("/decks", middleware.Idempotency(), handlers.CreateDeckHandler)
+			protected.PUT("/decks/:id", handlers.UpdateDeckHandler)
+			protected.POST("/decks/:id/move", handlers.MoveDeckHandler)
+			protected.DELETE("/decks/:id", handlers.DeleteDeckHandler)
+
+			protected.GET("/tags", handlers.GetTagsHandler)
+			protected.POST("/tags/rename", handlers.RenameTagHandler)
+			protected.POST("/tags/merge", handlers.MergeTagsHandler)
+			protected.POST("/tags/delete", handlers.DeleteTagsHandler)
+
+			protected.POST("/sync", middleware.DecompressRequest(), middleware.IdempotencyWithoutBody(), handlers.SyncHandler)
+			protected.POST("/media", handlers.UploadMediaHandler)
+			protected.POST("/cards", middleware.DecompressRequest(), middleware.Idempotency(), handlers.CreateCardHandler)
 			protected.DELETE("/cards/:id", handlers.DeleteCardHandler)
-			protected.PUT("/cards/:id", handlers.UpdateCardHandler)
+			protected.PUT("/cards/:id", middleware.DecompressRequest(), handlers.UpdateCardHandler)
+			protected.POST("/cards/:id/review", middleware.Idempotency(), handlers.ReviewCardHandler)
+			protected.POST("/cards/:id/answer", middleware.Idempotency(), handlers.CheckAnswerHandler)
+			protected.GET("/cards/:id/reviews", handlers.GetCardReviewsHandler)
+			protected.GET("/cards/leeches", handlers.GetLeechesHandler)
+			protected.POST("/cards/:id/suspend", handlers.SuspendCardHandler)
+			protected.POST("/cards/:id/unsuspend", handlers.UnsuspendCardHandler)
+			protected.POST("/cards/:id/bury", handlers.BuryCardHandler)
+
+			protected.GET("/note-types", handlers.GetNoteTypesHandler)
+			protected.POST("/notes", middleware.Idempotency(), handlers.CreateNoteHandler)
+			protected.GET("/notes/:id", handlers.GetNoteHandler)
+			protected.PUT("/notes/:id", handlers.UpdateNoteHandler)
+			protected.DELETE("/notes/:id", handlers.DeleteNoteHandler)
+
+			protected.GET("/review/queue", handlers.GetReviewQueueHandler)
+			protected.GET("/stats", handlers.GetStatsHandler)
+			protected.GET("/search", handlers.SearchHandler)
+
+			protected.GET("/srs/settings", handlers.GetSRSSettingsHandler)
+			protected.PUT("/srs/settings", handlers.UpdateSRSSettingsHandler)
+			protected.POST("/srs/optimize", handlers.OptimizeFSRSHandler)
+
+			protected.GET("/presets", handlers.GetStudyPresetsHandler)
+			protected.POST("/presets", handlers.CreateStudyPresetHandler)
+			protected.PUT("/presets/:id", handlers.UpdateStudyPresetHandler)
+			protected.DELETE("/presets/:id", handlers.DeleteStudyPresetHandler)
+
+			protected.POST("/import/anki", handlers.ImportAnkiHandler)
+			protected.GET("/export/anki", handlers.ExportAnkiHandler)
+			protected.GET("/account/export", handlers.ExportAccountHandler)
+			protected.POST("/account/import", handlers.ImportAccountHandler)
 		}
 	}
 }
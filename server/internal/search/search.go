@@ -0,0 +1,161 @@
+// Package search holds the text handling behind full-text search: query
+// parsing, language configurations and highlighting.
+//
+// Search relies on Postgres text search (to_tsvector, websearch_to_tsquery,
+// ts_rank), as the server only runs on Postgres. The tokenizing fallback for
+// databases without it was removed on purpose rather than kept untested.
+//
+// Chinese, Japanese and Korean text has no spaces between words, so it is
+// matched differently: the database looks for CJK runs of the query as
+// substrings.
+package search
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Configs maps language codes to Postgres text search configurations,
+// which stem words of that language. Anything else uses "simple", which
+// only lower-cases.
+var Configs = map[string]string{
+	"da": "danish", "de": "german", "en": "english", "es": "spanish", "fi": "finnish",
+	"fr": "french", "hu": "hungarian", "it": "italian", "nl": "dutch", "no": "norwegian",
+	"pt": "portuguese", "ro": "romanian", "ru": "russian", "sv": "swedish", "tr": "turkish",
+}
+
+const SimpleConfig = "simple"
+
+// Config returns the text search configuration for a language code or
+// configuration name, SimpleConfig for languages without one.
+func Config(lang string) string {
+	lang = strings.ToLower(lang)
+	if config, ok := Configs[lang]; ok {
+		return config
+	}
+	for _, config := range Configs {
+		if config == lang {
+			return config
+		}
+	}
+	return SimpleConfig
+}
+
+// IsCJK reports whether r belongs to a script written without spaces
+// between words.
+func IsCJK(r rune) bool {
+	// The prolonged sound mark ー is shared by both kana scripts
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー'
+}
+
+// Query is a parsed search query.
+type Query struct {
+	Text string   // the query without CJK runs, for websearch_to_tsquery
+	CJK  []string // CJK runs, each to be found as a substring
+}
+
+// Parse splits q into its CJK runs and the rest.
+func Parse(q string) Query {
+	q = norm.NFKC.String(q)
+	var query Query
+	var text, run strings.Builder
+	flush := func() {
+		if run.Len() > 0 && !slices.Contains(query.CJK, run.String()) {
+			query.CJK = append(query.CJK, run.String())
+		}
+		run.Reset()
+	}
+	for _, r := range q {
+		if IsCJK(r) {
+			run.WriteRune(r)
+			continue
+		}
+		flush()
+		text.WriteRune(r)
+	}
+	flush()
+	query.Text = strings.Join(strings.Fields(text.String()), " ")
+	return query
+}
+
+func (q Query) Empty() bool {
+	return q.Text == "" && len(q.CJK) == 0
+}
+
+// Marks delimiting highlights in text from the database, chosen so they
+// can't occur in user text.
+const (
+	StartMark = "\x02"
+	StopMark  = "\x03"
+)
+
+// Snippet returns up to about maxRunes of text around its first highlight,
+// HTML escaped, with highlights as <mark> elements. Highlights are the
+// spans text already marks with StartMark and StopMark, plus occurrences of
+// terms.
+func Snippet(text string, terms []string, maxRunes int) string {
+	folded := []rune(strings.ToLower(text))
+	runes := []rune(text)
+	if len(folded) != len(runes) {
+		folded = runes // lower-casing changed the length, highlight exact matches only
+	}
+	marked := make([]bool, len(runes))
+	for i, inMark := 0, false; i < len(runes); i++ {
+		switch string(runes[i]) {
+		case StartMark:
+			inMark = true
+		case StopMark:
+			inMark = false
+		default:
+			marked[i] = inMark
+		}
+	}
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+		for i := 0; i+len(needle) <= len(folded); i++ {
+			if slices.Equal(folded[i:i+len(needle)], needle) {
+				for j := i; j < i+len(needle); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+
+	// Window around the first highlight
+	first := slices.Index(marked, true)
+	start := max(0, first-maxRunes/3)
+	end := min(len(runes), start+maxRunes)
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if string(runes[i]) == StartMark || string(runes[i]) == StopMark {
+			continue
+		}
+		if marked[i] != inMark {
+			inMark = marked[i]
+			if inMark {
+				b.WriteString("<mark>")
+			} else {
+				b.WriteString("</mark>")
+			}
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+	}
+	if inMark {
+		b.WriteString("</mark>")
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,35 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	tests := map[string]string{
+		"": SimpleConfig, "simple": SimpleConfig, "es": "spanish", "DE": "german",
+		"french": "french", "ja": SimpleConfig, "zh": SimpleConfig, "ko": SimpleConfig, "xx": SimpleConfig,
+	}
+	for lang, want := range tests {
+		if got := Config(lang); got != want {
+			t.Errorf("Config(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	q := Parse("猫 cat  -dog 日本語 猫")
+	if q.Text != "cat -dog" || !slices.Equal(q.CJK, []string{"猫", "日本語"}) {
+		t.Errorf("got text %q, CJK %q", q.Text, q.CJK)
+	}
+	if !Parse("   ").Empty() {
+		t.Errorf("blank query should be empty")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	got := Snippet("The "+StartMark+"cat"+StopMark+" sat on <b>日本</b>", []string{"日本"}, 100)
+	if want := "The <mark>cat</mark> sat on &lt;b&gt;<mark>日本</mark>&lt;/b&gt;"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
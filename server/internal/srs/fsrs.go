@@ -0,0 +1,145 @@
+package srs
+
+import (
+	"math"
+	"time"
+)
+
+// Parameters are the 17 FSRS-4.5 model weights w0..w16.
+type Parameters []float64
+
+// DefaultParameters are the published FSRS-4.5 defaults, fitted on a large
+// pool of Anki review histories. The optimizer refines them per user.
+var DefaultParameters = Parameters{
+	0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031,
+	1.6474, 0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755,
+}
+
+const (
+	DefaultDesiredRetention = 0.9
+	MinDesiredRetention     = 0.7
+	MaxDesiredRetention     = 0.97
+
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0 // makes retrievability 90% after exactly S days
+)
+
+func ValidRetention(r float64) bool {
+	return r >= MinDesiredRetention && r <= MaxDesiredRetention
+}
+
+// FSRS is the Free Spaced Repetition Scheduler (version 4.5). It models each
+// card's memory with a stability and a difficulty and schedules the next
+// review for when recall probability falls to DesiredRetention.
+type FSRS struct {
+	Parameters       Parameters
+	DesiredRetention float64
+	MaximumInterval  int
+}
+
+func (f FSRS) Schedule(s State, g Grade, now time.Time) State {
+	w := f.Parameters
+	rating := float64(g + 1) // FSRS rates 1 (again) to 4 (easy)
+
+	if s.Stability <= 0 && s.Repetition > 0 {
+		s = seedFromSM2(s)
+	}
+
+	if s.Stability <= 0 {
+		s.Stability = w.initialStability(g)
+		s.Difficulty = w.initialDifficulty(rating)
+	} else {
+		r := Retrievability(elapsedDays(s, now), s.Stability)
+		if g == Again {
+			s.Stability = w.forgetStability(s.Difficulty, s.Stability, r)
+		} else {
+			s.Stability = w.recallStability(s.Difficulty, s.Stability, r, g)
+		}
+		s.Difficulty = w.nextDifficulty(s.Difficulty, rating)
+	}
+	// Beyond this the card is never due again anyway
+	s.Stability = math.Min(s.Stability, MaxInterval)
+
+	if g == Again {
+		if s.Repetition > 0 {
+			s.Lapses++
+		}
+		s.Repetition = 0
+		s.Interval = 0
+	} else {
+		s.Repetition++
+		s.Interval = f.nextInterval(s.Stability)
+	}
+
+	s.NextReview = now.Add(time.Duration(s.Interval) * day).UnixMilli()
+	s.LastReview = now.UnixMilli()
+	return s
+}
+
+func (f FSRS) nextInterval(stability float64) int {
+	interval := stability / fsrsFactor * (math.Pow(f.DesiredRetention, 1/fsrsDecay) - 1)
+	maxInterval := f.MaximumInterval
+	if maxInterval <= 0 {
+		maxInterval = MaxInterval
+	}
+	return int(math.Max(1, math.Min(math.Round(interval), float64(maxInterval))))
+}
+
+// Retrievability is the predicted probability of recalling a card with the
+// given stability after elapsed days.
+func Retrievability(elapsed, stability float64) float64 {
+	return math.Pow(1+fsrsFactor*elapsed/stability, fsrsDecay)
+}
+
+func (w Parameters) initialStability(g Grade) float64 {
+	return math.Max(w[int(g)], 0.1)
+}
+
+func (w Parameters) initialDifficulty(rating float64) float64 {
+	return clampDifficulty(w[4] - (rating-3)*w[5])
+}
+
+func (w Parameters) nextDifficulty(d, rating float64) float64 {
+	next := d - w[6]*(rating-3)
+	// Mean reversion towards the default difficulty w4
+	return clampDifficulty(w[7]*w[4] + (1-w[7])*next)
+}
+
+func (w Parameters) recallStability(d, s, r float64, g Grade) float64 {
+	hardPenalty, easyBonus := 1.0, 1.0
+	if g == Hard {
+		hardPenalty = w[15]
+	}
+	if g == Easy {
+		easyBonus = w[16]
+	}
+	return s * (1 + math.Exp(w[8])*(11-d)*math.Pow(s, -w[9])*(math.Exp((1-r)*w[10])-1)*hardPenalty*easyBonus)
+}
+
+func (w Parameters) forgetStability(d, s, r float64) float64 {
+	next := w[11] * math.Pow(d, -w[12]) * (math.Pow(s+1, w[13]) - 1) * math.Exp((1-r)*w[14])
+	return math.Max(0.1, math.Min(next, s))
+}
+
+func clampDifficulty(d float64) float64 {
+	return math.Max(1, math.Min(d, 10))
+}
+
+// elapsedDays is the time since the card was last reviewed. Cards migrated
+// from SM-2 have no review timestamp, so it is derived from their schedule.
+func elapsedDays(s State, now time.Time) float64 {
+	last := s.LastReview
+	if last == 0 {
+		last = s.NextReview - int64(s.Interval)*day.Milliseconds()
+	}
+	return math.Max(0, float64(now.UnixMilli()-last)/float64(day.Milliseconds()))
+}
+
+// seedFromSM2 gives a card that was scheduled by SM-2 an FSRS memory state.
+// Its interval was tuned for roughly 90% recall, which is what stability
+// means, and the easiness range 1.3-3.0 maps onto difficulty 10-1.
+func seedFromSM2(s State) State {
+	s.Stability = math.Max(float64(s.Interval), 0.1)
+	s.Difficulty = clampDifficulty(10 - (s.EFactor-MinEFactor)*9/1.7)
+	return s
+}
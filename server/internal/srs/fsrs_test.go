@@ -0,0 +1,156 @@
+package srs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestFSRS() FSRS {
+	return FSRS{Parameters: DefaultParameters, DesiredRetention: DefaultDesiredRetention, MaximumInterval: MaxInterval}
+}
+
+func TestFSRSFirstReview(t *testing.T) {
+	tests := []struct {
+		grade      Grade
+		stability  float64
+		difficulty float64
+		interval   int
+		repetition int
+	}{
+		{Again, 0.4872, 7.6214, 0, 0},
+		{Hard, 1.4003, 6.3916, 1, 1},
+		{Good, 3.7145, 5.1618, 4, 1},
+		{Easy, 13.8206, 3.932, 14, 1},
+	}
+	for _, tt := range tests {
+		s := newTestFSRS().Schedule(InitialState(testNow), tt.grade, testNow)
+		if math.Abs(s.Stability-tt.stability) > 1e-9 || math.Abs(s.Difficulty-tt.difficulty) > 1e-9 ||
+			s.Interval != tt.interval || s.Repetition != tt.repetition {
+			t.Errorf("grade %d: got stability %v, difficulty %v, interval %d, repetition %d; want %v, %v, %d, %d",
+				tt.grade, s.Stability, s.Difficulty, s.Interval, s.Repetition, tt.stability, tt.difficulty, tt.interval, tt.repetition)
+		}
+		if s.LastReview != testNow.UnixMilli() {
+			t.Errorf("grade %d: lastReview %d, want %d", tt.grade, s.LastReview, testNow.UnixMilli())
+		}
+	}
+}
+
+// Expected values follow the FSRS-4.5 formulas with the default weights, for
+// a card reviewed again 4 days after its first review.
+func TestFSRSSecondReview(t *testing.T) {
+	tests := []struct {
+		first, second Grade
+		stability     float64
+		difficulty    float64
+		interval      int
+		lapses        int
+	}{
+		{Good, Good, 14.808100506, 5.1618, 15, 0},
+		{Good, Again, 1.433234490, 6.901155, 0, 1},
+		// Difficulty reverts towards w4, not towards the initial difficulty of Easy
+		{Easy, Good, 25.999879668, 3.9701238, 26, 0},
+	}
+	for _, tt := range tests {
+		f := newTestFSRS()
+		s := f.Schedule(InitialState(testNow), tt.first, testNow)
+		s = f.Schedule(s, tt.second, testNow.Add(4*day))
+		if math.Abs(s.Stability-tt.stability) > 1e-6 || s.Interval != tt.interval {
+			t.Errorf("%d then %d: got stability %v, interval %d; want %v, %d", tt.first, tt.second, s.Stability, s.Interval, tt.stability, tt.interval)
+		}
+		if math.Abs(s.Difficulty-tt.difficulty) > 1e-6 || s.Lapses != tt.lapses {
+			t.Errorf("%d then %d: got difficulty %v, lapses %d; want %v, %d", tt.first, tt.second, s.Difficulty, s.Lapses, tt.difficulty, tt.lapses)
+		}
+	}
+}
+
+func TestFSRSIntervals(t *testing.T) {
+	f := newTestFSRS()
+	s := f.Schedule(InitialState(testNow), Good, testNow)
+	now := testNow
+	for i := 0; i < 30; i++ {
+		now = time.UnixMilli(s.NextReview).UTC()
+		next := f.Schedule(s, Good, now)
+		if next.Stability < s.Stability || next.Stability > MaxInterval || next.Interval < s.Interval || next.Interval > MaxInterval {
+			t.Fatalf("review %d: stability %v -> %v, interval %d -> %d", i, s.Stability, next.Stability, s.Interval, next.Interval)
+		}
+		if err := Validate(next); err != nil {
+			t.Fatalf("review %d: produced invalid state: %v", i, err)
+		}
+		s = next
+	}
+
+	capped := newTestFSRS()
+	capped.MaximumInterval = 30
+	if got := capped.Schedule(s, Good, now).Interval; got != 30 {
+		t.Errorf("capped interval %d, want 30", got)
+	}
+
+	strict := newTestFSRS()
+	strict.DesiredRetention = MaxDesiredRetention
+	card := f.Schedule(InitialState(testNow), Easy, testNow)
+	if strict.nextInterval(card.Stability) >= f.nextInterval(card.Stability) {
+		t.Errorf("higher retention should give shorter intervals")
+	}
+}
+
+func TestFSRSSeedsFromSM2(t *testing.T) {
+	s := Review(Review(Review(InitialState(testNow), Good, testNow), Good, testNow), Good, testNow)
+	next := newTestFSRS().Schedule(s, Good, time.UnixMilli(s.NextReview))
+	if next.Stability <= float64(s.Interval) || next.Difficulty < 1 || next.Difficulty > 10 {
+		t.Errorf("got stability %v, difficulty %v from SM-2 interval %d", next.Stability, next.Difficulty, s.Interval)
+	}
+}
+
+func TestValidParameters(t *testing.T) {
+	with := func(i int, v float64) []float64 {
+		p := append([]float64(nil), DefaultParameters...)
+		p[i] = v
+		return p
+	}
+	tests := []struct {
+		name   string
+		params []float64
+		valid  bool
+	}{
+		{"defaults", DefaultParameters, true},
+		{"empty", nil, false},
+		{"too few", DefaultParameters[:16], false},
+		{"zero w0", with(0, 0), false},
+		{"negative w3", with(3, -1), false},
+		{"negative w15", with(15, -0.1), false},
+		{"negative w16", with(16, -2), false},
+		{"NaN w8", with(8, math.NaN()), false},
+		{"infinite w11", with(11, math.Inf(1)), false},
+		{"bounds", with(7, 0.75), true},
+	}
+	for _, tt := range tests {
+		if got := ValidParameters(tt.params); got != tt.valid {
+			t.Errorf("%s: ValidParameters = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func TestOptimizeFSRSStopsWhenCancelled(t *testing.T) {
+	histories := make([][]ReviewEvent, 100)
+	for i := range histories {
+		for j := range 6 {
+			grade := Good
+			if (i+j)%4 == 0 {
+				grade = Again
+			}
+			histories[i] = append(histories[i], ReviewEvent{Grade: grade, ReviewedAt: int64(j*(j+1)) * day.Milliseconds()})
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OptimizeFSRS(ctx, histories, DefaultParameters); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if _, err := OptimizeFSRS(context.Background(), histories[:10], DefaultParameters); !errors.Is(err, ErrNotEnoughReviews) {
+		t.Fatalf("got error %v for 50 reviews, want ErrNotEnoughReviews", err)
+	}
+}
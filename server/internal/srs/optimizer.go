@@ -0,0 +1,166 @@
+package srs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// MinOptimizeReviews is the amount of usable history (reviews made at least
+// a day after the previous one) needed before fitting personal parameters.
+const MinOptimizeReviews = 400
+
+// MaxOptimizeReviews bounds the history fitted at once; every pass of the
+// search replays all of it several times.
+const MaxOptimizeReviews = 50000
+
+var ErrNotEnoughReviews = errors.New("not enough review history to optimize")
+
+// ReviewEvent is one graded review of a card.
+type ReviewEvent struct {
+	Grade      Grade
+	ReviewedAt int64 // unix millis
+}
+
+type OptimizeResult struct {
+	Parameters Parameters `json:"parameters"`
+	LossBefore float64    `json:"lossBefore"` // mean log loss with the initial parameters
+	LossAfter  float64    `json:"lossAfter"`
+	Reviews    int        `json:"reviews"`
+}
+
+// parameterBounds keep every weight in the range the FSRS model is defined for.
+var parameterBounds = [17][2]float64{
+	{0.1, 100}, {0.1, 100}, {0.1, 100}, {0.1, 100},
+	{1, 10}, {0.001, 4}, {0.001, 4}, {0.001, 0.75},
+	{0, 4.5}, {0, 0.8}, {0.001, 3.5}, {0.001, 5},
+	{0.001, 0.25}, {0.001, 0.9}, {0, 4}, {0, 1}, {1, 6},
+}
+
+// ValidParameters reports whether p holds 17 weights within the bounds the
+// model is defined for. Outside them stability can reach zero or below,
+// which turns retrievability and intervals into NaN or infinity.
+func ValidParameters(p []float64) bool {
+	if len(p) != len(parameterBounds) {
+		return false
+	}
+	for i, bounds := range parameterBounds {
+		if !(p[i] >= bounds[0] && p[i] <= bounds[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// OptimizeFSRS fits FSRS parameters to a user's review history by minimising
+// the log loss between predicted retrievability and actual recall. histories
+// holds one chronologically ordered slice per card. The search is a bounded
+// coordinate descent starting from initial, which is cheap enough to run on
+// request for a single user with at most MaxOptimizeReviews reviews. It stops
+// with ctx's error once ctx is done.
+func OptimizeFSRS(ctx context.Context, histories [][]ReviewEvent, initial Parameters) (OptimizeResult, error) {
+	if !ValidParameters(initial) {
+		initial = DefaultParameters
+	}
+	histories = dropSameDayReviews(histories)
+
+	best := append(Parameters(nil), initial...)
+	bestLoss, reviews := logLoss(histories, best)
+	if reviews < MinOptimizeReviews {
+		return OptimizeResult{}, ErrNotEnoughReviews
+	}
+	result := OptimizeResult{LossBefore: bestLoss, Reviews: reviews}
+
+	steps := make([]float64, len(best))
+	for i, bounds := range parameterBounds {
+		steps[i] = (bounds[1] - bounds[0]) / 20
+	}
+
+	for pass := 0; pass < 100; pass++ {
+		if err := ctx.Err(); err != nil {
+			return OptimizeResult{}, err
+		}
+		improved := false
+		for i := range best {
+			for _, direction := range []float64{1, -1} {
+				candidate := append(Parameters(nil), best...)
+				candidate[i] = math.Max(parameterBounds[i][0], math.Min(best[i]+direction*steps[i], parameterBounds[i][1]))
+				if candidate[i] == best[i] {
+					continue
+				}
+				if loss, _ := logLoss(histories, candidate); loss < bestLoss {
+					best, bestLoss, improved = candidate, loss, true
+					break
+				}
+			}
+		}
+		if !improved {
+			converged := true
+			for i := range steps {
+				steps[i] /= 2
+				if steps[i] > (parameterBounds[i][1]-parameterBounds[i][0])*1e-4 {
+					converged = false
+				}
+			}
+			if converged {
+				break
+			}
+		}
+	}
+
+	result.Parameters = best
+	result.LossAfter = bestLoss
+	return result, nil
+}
+
+// logLoss replays every history with w and returns the mean binary cross
+// entropy of the recall predictions, along with how many reviews were scored.
+func logLoss(histories [][]ReviewEvent, w Parameters) (float64, int) {
+	const epsilon = 1e-7
+	total, count := 0.0, 0
+	for _, history := range histories {
+		var s, d float64
+		for i, event := range history {
+			rating := float64(event.Grade + 1)
+			if i == 0 {
+				s, d = w.initialStability(event.Grade), w.initialDifficulty(rating)
+				continue
+			}
+			elapsed := float64(event.ReviewedAt-history[i-1].ReviewedAt) / float64(day.Milliseconds())
+			r := math.Max(epsilon, math.Min(Retrievability(elapsed, s), 1-epsilon))
+			if event.Grade == Again {
+				total -= math.Log(1 - r)
+				s = w.forgetStability(d, s, r)
+			} else {
+				total -= math.Log(r)
+				s = w.recallStability(d, s, r, event.Grade)
+			}
+			d = w.nextDifficulty(d, rating)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return total / float64(count), count
+}
+
+// dropSameDayReviews keeps only the first review of a card per day, as
+// FSRS-4.5 does not model short-term memory.
+func dropSameDayReviews(histories [][]ReviewEvent) [][]ReviewEvent {
+	filtered := make([][]ReviewEvent, 0, len(histories))
+	for _, history := range histories {
+		kept := make([]ReviewEvent, 0, len(history))
+		for _, event := range history {
+			if len(kept) > 0 && time.Duration(event.ReviewedAt-kept[len(kept)-1].ReviewedAt)*time.Millisecond < day {
+				continue
+			}
+			kept = append(kept, event)
+		}
+		if len(kept) > 1 {
+			filtered = append(filtered, kept)
+		}
+	}
+	return filtered
+}
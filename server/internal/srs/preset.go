@@ -0,0 +1,94 @@
+package srs
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Preset holds the study options a lesson can override. Zero values keep the
+// behaviour of the underlying algorithm.
+type Preset struct {
+	LearningSteps      []int   // minutes between the reviews of a card being (re)learned
+	GraduatingInterval int     // days, SM-2 only: first interval after the last learning step
+	EasyBonus          float64 // multiplier for intervals answered Easy, SM-2 only
+	MaximumInterval    int     // days
+}
+
+const (
+	MaxLearningSteps = 10
+	maxLearningStep  = 7 * 24 * 60 // one week, in minutes
+	MaxEasyBonus     = 5.0
+)
+
+var ErrInvalidPreset = errors.New("invalid preset")
+
+// Validate reports whether p only holds options the scheduler can honor.
+func (p Preset) Validate() error {
+	if len(p.LearningSteps) > MaxLearningSteps {
+		return ErrInvalidPreset
+	}
+	for _, step := range p.LearningSteps {
+		if step < 1 || step > maxLearningStep {
+			return ErrInvalidPreset
+		}
+	}
+	if p.GraduatingInterval < 0 || p.GraduatingInterval > MaxInterval ||
+		p.MaximumInterval < 0 || p.MaximumInterval > MaxInterval ||
+		p.EasyBonus != 0 && (p.EasyBonus < 1 || p.EasyBonus > MaxEasyBonus) {
+		return ErrInvalidPreset
+	}
+	return nil
+}
+
+// Presetted applies a Preset on top of a base scheduler. New and forgotten
+// cards first go through the learning steps, seen again after a few minutes,
+// and only reach the base scheduler when they graduate: on Good at the last
+// step or on Easy at any step.
+type Presetted struct {
+	Base   Scheduler
+	Preset Preset
+}
+
+func (p Presetted) Schedule(s State, g Grade, now time.Time) State {
+	steps := p.Preset.LearningSteps
+	learning := s.Repetition == 0 && len(steps) > 0
+
+	if learning && g != Again && g != Easy && (g == Hard || s.Step+1 < len(steps)) {
+		// Hard repeats the current step, Good moves on to the next one
+		if g == Good {
+			s.Step++
+		}
+		s.Step = min(s.Step, len(steps)-1)
+		s.NextReview = now.Add(time.Duration(steps[s.Step]) * time.Minute).UnixMilli()
+		s.LastReview = now.UnixMilli()
+		return s
+	}
+
+	graduating := s.Repetition == 0
+	next := p.Base.Schedule(s, g, now)
+	next.Step = 0
+
+	if g == Again {
+		if len(steps) > 0 {
+			next.NextReview = now.Add(time.Duration(steps[0]) * time.Minute).UnixMilli()
+		}
+		return next
+	}
+
+	_, sm2 := p.Base.(SM2)
+	if sm2 {
+		if graduating && p.Preset.GraduatingInterval > 0 {
+			next.Interval = p.Preset.GraduatingInterval
+		}
+		if g == Easy && p.Preset.EasyBonus > 0 {
+			next.Interval = int(math.Ceil(float64(next.Interval) * p.Preset.EasyBonus))
+		}
+	}
+	if p.Preset.MaximumInterval > 0 {
+		next.Interval = min(next.Interval, p.Preset.MaximumInterval)
+	}
+	next.Interval = max(1, min(next.Interval, MaxInterval))
+	next.NextReview = now.Add(time.Duration(next.Interval) * day).UnixMilli()
+	return next
+}
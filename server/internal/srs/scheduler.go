@@ -0,0 +1,67 @@
+package srs
+
+import "time"
+
+// Algorithm names a scheduling algorithm as stored in user and lesson settings.
+type Algorithm string
+
+const (
+	AlgorithmSM2  Algorithm = "sm2"
+	AlgorithmFSRS Algorithm = "fsrs"
+)
+
+func (a Algorithm) Valid() bool {
+	return a == AlgorithmSM2 || a == AlgorithmFSRS
+}
+
+// Scheduler computes the state of a card after it was graded.
+type Scheduler interface {
+	Schedule(s State, g Grade, now time.Time) State
+}
+
+// SM2 is the classic SuperMemo-2 scheduler the clients implement.
+type SM2 struct{}
+
+func (SM2) Schedule(s State, g Grade, now time.Time) State {
+	return Review(s, g, now)
+}
+
+// Settings are the scheduler options of a user, possibly refined by a study
+// preset.
+type Settings struct {
+	Algorithm        Algorithm
+	DesiredRetention float64   // FSRS only, 0 means DefaultDesiredRetention
+	Parameters       []float64 // FSRS weights, nil means DefaultParameters
+	Preset           *Preset   // nil schedules exactly like the clients do
+}
+
+// NewScheduler builds the scheduler described by settings, falling back to
+// SM-2 for unknown algorithms and to defaults for invalid FSRS options.
+func NewScheduler(settings Settings) Scheduler {
+	base := newBaseScheduler(settings)
+	if settings.Preset != nil {
+		return Presetted{Base: base, Preset: *settings.Preset}
+	}
+	return base
+}
+
+func newBaseScheduler(settings Settings) Scheduler {
+	if settings.Algorithm != AlgorithmFSRS {
+		return SM2{}
+	}
+	fsrs := FSRS{
+		Parameters:       DefaultParameters,
+		DesiredRetention: DefaultDesiredRetention,
+		MaximumInterval:  MaxInterval,
+	}
+	if settings.Preset != nil && settings.Preset.MaximumInterval > 0 {
+		fsrs.MaximumInterval = min(settings.Preset.MaximumInterval, MaxInterval)
+	}
+	if ValidParameters(settings.Parameters) {
+		fsrs.Parameters = Parameters(settings.Parameters)
+	}
+	if ValidRetention(settings.DesiredRetention) {
+		fsrs.DesiredRetention = settings.DesiredRetention
+	}
+	return fsrs
+}
@@ -0,0 +1,151 @@
+// Package srs implements the spaced-repetition scheduler used by the server.
+// It mirrors services/srs.ts in the clients so a card graded on the server
+// ends up exactly where it would have if it had been graded on a device.
+package srs
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Grade is the user's rating of a review, same values as the clients' Grade enum.
+type Grade int
+
+const (
+	Again Grade = 0 // Forgot completely (reset)
+	Hard  Grade = 1 // Remembered with difficulty
+	Good  Grade = 2 // Remembered perfectly
+	Easy  Grade = 3 // Remembered easily
+)
+
+func (g Grade) Valid() bool {
+	return g >= Again && g <= Easy
+}
+
+const (
+	InitialEFactor = 2.5
+	MinEFactor     = 1.3
+	// Upper bounds used to reject nonsensical client-submitted progress
+	MaxEFactor  = 10.0
+	MaxInterval = 36500 // days
+)
+
+const day = 24 * time.Hour
+
+// State is the scheduling state stored on a flashcard. Stability and
+// Difficulty are only maintained by FSRS and stay zero under SM-2.
+type State struct {
+	Interval   int     // days
+	Repetition int     // consecutive successful reviews
+	EFactor    float64 // easiness factor
+	NextReview int64   // unix millis
+	Stability  float64 // days until recall probability drops to 90%
+	Difficulty float64 // 1 (easy) to 10 (hard)
+	Lapses     int     // times the card was forgotten after being learned
+	LastReview int64   // unix millis, 0 if never reviewed
+	Step       int     // learning step reached, only used with learning steps
+}
+
+// InitialState is the state of a card that has never been reviewed: due now.
+func InitialState(now time.Time) State {
+	return State{
+		Interval:   0,
+		Repetition: 0,
+		EFactor:    InitialEFactor,
+		NextReview: now.UnixMilli(),
+	}
+}
+
+// Review applies the SuperMemo-2 algorithm to s for a review graded g at now.
+func Review(s State, g Grade, now time.Time) State {
+	// 1. Update Easiness Factor (EF)
+	if g != Again {
+		quality := float64(g) + 2 // Hard=3, Good=4, Easy=5 on the SM-2 0-5 scale
+		s.EFactor += 0.1 - (5-quality)*(0.08+(5-quality)*0.02)
+		if s.EFactor < MinEFactor {
+			s.EFactor = MinEFactor
+		}
+	}
+
+	// 2. Update Repetition and Interval
+	if g == Again {
+		if s.Repetition > 0 {
+			s.Lapses++
+		}
+		s.Repetition = 0
+		s.Interval = 0
+	} else {
+		s.Repetition++
+		switch s.Repetition {
+		case 1:
+			s.Interval = 1
+		case 2:
+			s.Interval = 6
+		default:
+			s.Interval = int(math.Round(float64(s.Interval) * s.EFactor))
+		}
+	}
+	if s.Interval > MaxInterval {
+		s.Interval = MaxInterval
+	}
+
+	// 3. Calculate Next Review Date (failed cards are due again right away)
+	s.NextReview = now.Add(time.Duration(s.Interval) * day).UnixMilli()
+	s.LastReview = now.UnixMilli()
+	return s
+}
+
+var (
+	ErrInvalidInterval   = errors.New("interval out of range")
+	ErrInvalidRepetition = errors.New("repetition out of range")
+	ErrInvalidEFactor    = errors.New("efactor out of range")
+	ErrInvalidNextReview = errors.New("nextReview out of range")
+	ErrInconsistentState = errors.New("interval and repetition are inconsistent")
+	ErrInvalidMemory     = errors.New("stability or difficulty out of range")
+)
+
+// Validate reports whether s is a state the scheduler could have produced.
+// It is used to reject corrupt progress submitted by clients.
+func Validate(s State) error {
+	if s.Interval < 0 || s.Interval > MaxInterval {
+		return ErrInvalidInterval
+	}
+	if s.Repetition < 0 {
+		return ErrInvalidRepetition
+	}
+	if math.IsNaN(s.EFactor) || s.EFactor < MinEFactor || s.EFactor > MaxEFactor {
+		return ErrInvalidEFactor
+	}
+	if s.NextReview <= 0 {
+		return ErrInvalidNextReview
+	}
+	if (s.Repetition == 0) != (s.Interval == 0) {
+		return ErrInconsistentState
+	}
+	if s.Stability < 0 || s.Stability > MaxInterval*10 || s.Difficulty != 0 && (s.Difficulty < 1 || s.Difficulty > 10) || s.Lapses < 0 || s.Step < 0 {
+		return ErrInvalidMemory
+	}
+	return nil
+}
+
+// InferGrade recovers the grade that turned prev into next, for clients that
+// only report the resulting state. SM-2 changes the easiness factor by a
+// fixed amount per grade, which identifies it unless clamping makes two
+// grades produce the same factor.
+func InferGrade(prev, next State) (Grade, bool) {
+	if next.Repetition == 0 {
+		return Again, next.EFactor == prev.EFactor
+	}
+	if next.Repetition != prev.Repetition+1 {
+		return 0, false
+	}
+	const epsilon = 1e-6
+	found, matches := Again, 0
+	for _, g := range []Grade{Hard, Good, Easy} {
+		if math.Abs(Review(prev, g, time.Time{}).EFactor-next.EFactor) < epsilon {
+			found, matches = g, matches+1
+		}
+	}
+	return found, matches == 1
+}
@@ -0,0 +1,69 @@
+package srs
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var testGrades = map[rune]Grade{'A': Again, 'H': Hard, 'G': Good, 'E': Easy}
+
+// Expected states come from running calculateNextReview in services/srs.ts
+// over the same grades.
+func TestReviewMatchesClients(t *testing.T) {
+	tests := []struct {
+		grades     string
+		interval   int
+		repetition int
+		efactor    float64
+		lapses     int
+	}{
+		{"A", 0, 0, 2.5, 0},
+		{"G", 1, 1, 2.5, 0},
+		{"GG", 6, 2, 2.5, 0},
+		{"GGG", 15, 3, 2.5, 0},
+		{"GGGG", 38, 4, 2.5, 0},
+		{"GGHE", 34, 4, 2.46, 0},
+		{"GGGA", 0, 0, 2.5, 1},
+		{"GGGAG", 1, 1, 2.5, 1},
+		{"EEEEE", 147, 5, 3.0, 0},
+		{"HHHHHHHHHH", 241, 10, 1.3, 0},
+	}
+	for _, tt := range tests {
+		s := InitialState(testNow)
+		for _, g := range tt.grades {
+			s = Review(s, testGrades[g], testNow)
+		}
+		if s.Interval != tt.interval || s.Repetition != tt.repetition || math.Abs(s.EFactor-tt.efactor) > 1e-9 || s.Lapses != tt.lapses {
+			t.Errorf("%s: got interval %d, repetition %d, efactor %v, lapses %d; want %d, %d, %v, %d",
+				tt.grades, s.Interval, s.Repetition, s.EFactor, s.Lapses, tt.interval, tt.repetition, tt.efactor, tt.lapses)
+		}
+		if want := testNow.Add(time.Duration(tt.interval) * day).UnixMilli(); s.NextReview != want {
+			t.Errorf("%s: nextReview %d, want %d", tt.grades, s.NextReview, want)
+		}
+		if err := Validate(s); err != nil {
+			t.Errorf("%s: produced invalid state: %v", tt.grades, err)
+		}
+	}
+}
+
+func TestReviewCapsInterval(t *testing.T) {
+	s := State{Interval: MaxInterval, Repetition: 20, EFactor: InitialEFactor, NextReview: testNow.UnixMilli()}
+	if s = Review(s, Good, testNow); s.Interval != MaxInterval {
+		t.Errorf("interval %d, want %d", s.Interval, MaxInterval)
+	}
+}
+
+func TestInferGrade(t *testing.T) {
+	prev := Review(Review(InitialState(testNow), Good, testNow), Good, testNow)
+	for _, g := range []Grade{Hard, Good, Easy} {
+		if got, ok := InferGrade(prev, Review(prev, g, testNow)); !ok || got != g {
+			t.Errorf("InferGrade for %d = %d, %v", g, got, ok)
+		}
+	}
+	if got, ok := InferGrade(prev, Review(prev, Again, testNow)); !ok || got != Again {
+		t.Errorf("InferGrade for Again = %d, %v", got, ok)
+	}
+}
@@ -0,0 +1,145 @@
+// Package textdiff computes line diffs between two versions of a text, e.g.
+// the markdown of a lesson before and after an edit.
+package textdiff
+
+import "strings"
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+	Skip   Op = "skip" // unchanged lines left out by Context
+)
+
+// Line is a line of a diff. Skip lines stand for Count unchanged lines.
+type Line struct {
+	Op    Op     `json:"op"`
+	Text  string `json:"text,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+// maxCells bounds the table of the longest common subsequence. Texts whose
+// changed parts are too large for it are diffed as a whole replacement.
+const maxCells = 4 << 20
+
+// Lines returns the diff turning a into b, line by line.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]Line, 0, len(x)+len(y)-prefix-suffix)
+	for _, line := range x[:prefix] {
+		diff = append(diff, Line{Op: Equal, Text: line})
+	}
+	diff = append(diff, middle(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, line := range x[len(x)-suffix:] {
+		diff = append(diff, Line{Op: Equal, Text: line})
+	}
+	return diff
+}
+
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// middle diffs x and y through their longest common subsequence, deletions
+// before insertions where lines were replaced.
+func middle(x, y []string) []Line {
+	diff := make([]Line, 0, len(x)+len(y))
+	if len(x) == 0 || len(y) == 0 || (len(x)+1)*(len(y)+1) > maxCells {
+		for _, line := range x {
+			diff = append(diff, Line{Op: Delete, Text: line})
+		}
+		for _, line := range y {
+			diff = append(diff, Line{Op: Insert, Text: line})
+		}
+		return diff
+	}
+
+	// lcs[i*w+j] is the length of the LCS of x[i:] and y[j:]
+	w := len(y) + 1
+	lcs := make([]int32, (len(x)+1)*w)
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			diff = append(diff, Line{Op: Equal, Text: x[i]})
+			i, j = i+1, j+1
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			diff = append(diff, Line{Op: Delete, Text: x[i]})
+			i++
+		default:
+			diff = append(diff, Line{Op: Insert, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, Line{Op: Delete, Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, Line{Op: Insert, Text: y[j]})
+	}
+	return diff
+}
+
+// Stats counts the inserted and deleted lines of diff.
+func Stats(diff []Line) (inserted, deleted int) {
+	for _, line := range diff {
+		switch line.Op {
+		case Insert:
+			inserted++
+		case Delete:
+			deleted++
+		}
+	}
+	return inserted, deleted
+}
+
+// Context keeps the unchanged lines of diff that are within n lines of a
+// change and replaces each run of the others with a Skip line.
+func Context(diff []Line, n int) []Line {
+	keep := make([]bool, len(diff))
+	for i, line := range diff {
+		if line.Op == Equal {
+			continue
+		}
+		for j := max(0, i-n); j <= min(len(diff)-1, i+n); j++ {
+			keep[j] = true
+		}
+	}
+	result := make([]Line, 0, len(diff))
+	for i, line := range diff {
+		switch {
+		case keep[i]:
+			result = append(result, line)
+		case len(result) > 0 && result[len(result)-1].Op == Skip:
+			result[len(result)-1].Count++
+		default:
+			result = append(result, Line{Op: Skip, Count: 1})
+		}
+	}
+	return result
+}
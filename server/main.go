@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"lingolift-server/internal/db"
+	"lingolift-server/internal/middleware"
 	"lingolift-server/internal/routes"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +21,7 @@ var webFSEmbed embed.FS
 func main() {
 	// Initialize Database
 	db.InitDB()
+	go middleware.ExpireIdempotencyRecords()
 
 	webFS, err := fs.Sub(webFSEmbed, "web/dist")
 	if err != nil {
@@ -32,8 +34,9 @@ func main() {
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Content-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key, X-Device-ID, If-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, X-Next-Cursor")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -43,6 +46,9 @@ func main() {
 		c.Next()
 	})
 
+	// Response Compression (skips /uploads media)
+	r.Use(middleware.Compress())
+
 	// Setup Routes
 	routes.SetupRoutes(r)
 
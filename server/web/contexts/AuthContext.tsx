@@ -4,6 +4,7 @@ interface User {
     id: string;
     username: string;
     apiKeys: { id: string; key: string; name: string; createdAt: number }[];
+    devices?: { id: string; name: string; platform: string; appVersion: string; lastSeenAt: number }[];
     createdAt: number;
 }
 
@@ -42,6 +42,16 @@ export const Profile: React.FC = () => {
         }
     };
 
+    const handleRevokeDevice = async (id: string) => {
+        if (!confirm('Sign out this device? Its API key will be deleted and it will stop syncing.')) return;
+        try {
+            await fetch(`/api/devices/${id}`, { method: 'DELETE' });
+            await refreshProfile();
+        } catch (error) {
+            console.error('Failed to revoke device', error);
+        }
+    };
+
     const copyToClipboard = (key: string) => {
         navigator.clipboard.writeText(key);
         alert(t.profile.copied);
@@ -158,6 +168,37 @@ export const Profile: React.FC = () => {
                         )}
                     </div>
                 </div>
+
+                <div className="bg-white rounded-2xl p-6 shadow-sm border border-slate-100">
+                    <h2 className="text-lg font-semibold text-slate-900 mb-4">Devices</h2>
+                    <div className="space-y-3">
+                        {user.devices && user.devices.map((device) => (
+                            <div key={device.id} className="flex justify-between items-center border border-slate-100 rounded-xl p-4">
+                                <div>
+                                    <div className="font-medium text-slate-900 text-sm">
+                                        {device.name || 'Unnamed Device'}
+                                        {device.platform && <span className="text-slate-400 font-normal"> · {device.platform}</span>}
+                                    </div>
+                                    <div className="mt-1 text-xs text-slate-400">
+                                        {device.appVersion && <>v{device.appVersion} · </>}
+                                        Last seen: {new Date(device.lastSeenAt).toLocaleString()}
+                                    </div>
+                                </div>
+                                <button
+                                    onClick={() => handleRevokeDevice(device.id)}
+                                    className="text-sm font-medium text-red-600 hover:text-red-700"
+                                >
+                                    Sign out
+                                </button>
+                            </div>
+                        ))}
+                        {(!user.devices || user.devices.length === 0) && (
+                            <div className="text-center text-slate-500 text-sm py-4">
+                                No devices have synced yet.
+                            </div>
+                        )}
+                    </div>
+                </div>
             </main>
         </div>
     );
@@ -1,4 +1,31 @@
-import { Lesson } from "../types";
+import { Lesson, SyncRequest, SyncResponse } from "../types";
+import { generateUUID } from "../utils/uuid";
+
+const DEVICE_ID_KEY = 'lingolift_device_id';
+
+// Stable per-browser ID the server uses to track this device's sync state
+export const getDeviceId = (): string => {
+    let id = localStorage.getItem(DEVICE_ID_KEY);
+    if (!id) {
+        id = generateUUID();
+        localStorage.setItem(DEVICE_ID_KEY, id);
+    }
+    return id;
+};
+
+export const performBiDirectionalSync = async (request: SyncRequest): Promise<SyncResponse> => {
+    const response = await fetch('/api/sync', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', 'X-Device-ID': getDeviceId() },
+        body: JSON.stringify(request),
+    });
+
+    if (!response.ok) {
+        throw new Error(`Sync failed with status: ${response.status}`);
+    }
+
+    return await response.json();
+};
 
 /**
  * Create a new lesson on the server with file uploads
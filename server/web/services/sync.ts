@@ -1,9 +1,13 @@
 import { Lesson, Flashcard, SyncRequest, NewUserCard, CardProgress } from "../types";
-import { getAllLessons, saveLesson } from "./db";
-import { performBiDirectionalSync } from "./api";
+import { getAllLessons, saveLesson, deleteLesson } from "./db";
+import { performBiDirectionalSync, getDeviceId } from "./api";
 
 const SYNC_KEY = 'lingolift_last_sync';
 
+// Sync protocol spoken by this client, see server/internal/handlers/sync_protocol.go
+const SYNC_PROTOCOL_VERSION = 6;
+const SYNC_CAPABILITIES = ['cardDeletes', 'lessonDeletes', 'fullResync'];
+
 export const getLastSyncTime = (): number => {
   return parseInt(localStorage.getItem(SYNC_KEY) || '0', 10);
 };
@@ -45,8 +49,17 @@ export const syncLessons = async (): Promise<void> => {
     }
 
     const request: SyncRequest = {
+      protocolVersion: SYNC_PROTOCOL_VERSION,
+      capabilities: SYNC_CAPABILITIES,
+      device: { id: getDeviceId(), name: navigator.platform, platform: 'web', appVersion: '' },
       lastSyncTimestamp: lastSync,
-      changes: { createdCards, progressUpdates }
+      changes: {
+        createdCards,
+        modifiedCards: [],
+        deletedCardIds: [],
+        deletedLessonIds: [],
+        progressUpdates
+      }
     };
 
     // 2. Perform Network Request
@@ -126,6 +139,17 @@ export const syncLessons = async (): Promise<void> => {
       await saveLesson(mergedLesson);
     }
 
+    // Full Resync: the server purged deletions we never saw, so lessons
+    // holds everything and any other local lesson is gone
+    if (response.fullResync) {
+      const remoteIds = new Set(response.updates.lessons.map(l => l.id));
+      for (const lesson of localLessons) {
+        if (!remoteIds.has(lesson.id)) {
+          await deleteLesson(lesson.id);
+        }
+      }
+    }
+
     // 4. Update Sync Timestamp
     setLastSyncTime(response.serverTimestamp);
 
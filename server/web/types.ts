@@ -63,18 +63,40 @@ export interface NewUserCard {
   card: Flashcard;
 }
 
+export interface DeviceInfo {
+  id: string;
+  name: string;
+  platform: string;
+  appVersion: string;
+}
+
 export interface SyncRequest {
+  protocolVersion: number;
+  capabilities: string[];
+  device?: DeviceInfo;
   lastSyncTimestamp: number;
   changes: {
+    createdLessons?: Lesson[];
+    modifiedLessons?: Lesson[];
     createdCards: NewUserCard[];
+    modifiedCards: Flashcard[];
+    deletedCardIds: string[];
+    deletedLessonIds: string[];
     progressUpdates: CardProgress[];
   };
 }
 
 export interface SyncResponse {
+  protocolVersion: number;
+  capabilities: string[];
   serverTimestamp: number;
+  fullResync?: boolean;
   updates: {
     lessons: Lesson[]; // Full lesson updates or new lessons
     remoteProgress: CardProgress[]; // Progress from other devices
+    // Only present when the matching capability was negotiated
+    deletedLessonIds?: string[];
+    deletedCardIds?: string[];
+    conflictedLessonIds?: string[];
   };
 }
\ No newline at end of file